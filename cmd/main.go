@@ -1,17 +1,98 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"zmirror/internal/config"
 	"zmirror/internal/database"
 	"zmirror/internal/router"
 	"zmirror/internal/service"
+
+	"gorm.io/gorm"
 )
 
 func main() {
+	// 子命令
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "gc":
+			runGC(os.Args[2:])
+			return
+		case "bundle":
+			runBundle(os.Args[2:])
+			return
+		default:
+			log.Fatalf("Unknown command: %s", os.Args[1])
+		}
+	}
+
+	cfg, db := bootstrap()
+
+	// 初始化服务
+	userService := service.NewUserService(db)
+	userService.SetPasswordParams(service.PasswordParams{
+		Memory:      cfg.Auth.PasswordMemory,
+		Iterations:  cfg.Auth.PasswordIterations,
+		Parallelism: cfg.Auth.PasswordParallelism,
+	})
+	if err := userService.SetCredentialCache(time.Duration(cfg.Auth.CredentialCacheTTL)*time.Second, cfg.Auth.CredentialCacheSize); err != nil {
+		log.Fatal("Failed to initialize credential cache:", err)
+	}
+	if err := userService.BootstrapAdmin(cfg.Admin.Username, cfg.Admin.Password); err != nil {
+		log.Fatal("Failed to bootstrap admin account:", err)
+	}
+	registryService := service.NewRegistryService(db)
+	whitelistService := service.NewWhitelistService(db)
+	logService := service.NewLogService(db)
+	proxyService := service.NewProxyService(registryService)
+	proxyService.SetOfflineMode(cfg.Proxy.Offline, cfg.Proxy.AutoOffline)
+	cachePolicyService := service.NewCachePolicyService(db)
+	cacheService := newCacheService(cfg, db, registryService, cachePolicyService)
+	imageService := service.NewImageService(proxyService, cacheService)
+	groupService := service.NewGroupService(db, registryService)
+	pushRouteService := service.NewPushRouteService(db, registryService)
+	replicationService := service.NewReplicationService(db, cacheService)
+	imageService.OnHostedWrite(replicationService.TriggerPush)
+	retentionService := service.NewRetentionService(db, cacheService)
+	watchService := service.NewWatchService(db, proxyService, cacheService, imageService)
+	accessTokenService := service.NewAccessTokenService(db)
+	aclService := service.NewACLService(db)
+	userService.OnUserDeleted(aclService.UserDeleted)
+	policyService := service.NewPolicyService(userService, whitelistService, accessTokenService, aclService, groupService)
+	sessionService, err := service.NewSessionService(db, userService, cfg.Auth.KeyPath, time.Duration(cfg.Auth.SessionTokenTTL)*time.Second, time.Duration(cfg.Auth.SessionIdleTimeout)*time.Second, time.Duration(cfg.Auth.SessionAbsoluteTimeout)*time.Second)
+	if err != nil {
+		log.Fatal("Failed to initialize session service:", err)
+	}
+	tokenService, err := service.NewTokenService(whitelistService, aclService, groupService, cfg.Auth.TokenEnabled, cfg.Auth.KeyPath, cfg.Auth.Realm, cfg.Auth.Issuer, cfg.Auth.Service, cfg.Auth.Algorithm, time.Duration(cfg.Auth.TokenExpiry)*time.Second, time.Duration(cfg.Auth.KeyRotation)*time.Hour)
+	if err != nil {
+		log.Fatal("Failed to initialize token service:", err)
+	}
+	refreshService := service.NewRefreshService(db, proxyService, cacheService, imageService, groupService, pushRouteService, time.Duration(cfg.Cache.RefreshInterval)*time.Second, cfg.Cache.RefreshTop)
+
+	// 启动缓存淘汰、完整性检查、复制、保留策略、tag订阅、热门tag刷新和密钥轮换任务
+	cacheService.StartEvictor(time.Duration(cfg.Cache.EvictInterval) * time.Second)
+	cacheService.StartFsck(time.Duration(cfg.Cache.FsckInterval) * time.Second)
+	replicationService.StartScheduler()
+	retentionService.StartScheduler(time.Duration(cfg.Cache.RetentionInterval) * time.Second)
+	watchService.StartScheduler()
+	refreshService.StartScheduler()
+	tokenService.StartRotation()
+
+	// 设置路由
+	r := router.SetupRouter(proxyService, userService, registryService, whitelistService, logService, cacheService, cachePolicyService, imageService, groupService, replicationService, retentionService, pushRouteService, watchService, refreshService, tokenService, accessTokenService, aclService, policyService, sessionService)
+
+	// 启动服务器
+	address := fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port)
+	log.Printf("Server starting on %s", address)
+	log.Fatal(r.Run(address))
+}
+
+// bootstrap 加载配置并初始化数据库
+func bootstrap() (*config.Config, *gorm.DB) {
 	// 确保data目录存在
 	if err := os.MkdirAll("./data", 0755); err != nil {
 		log.Fatal("Failed to create data directory:", err)
@@ -29,17 +110,131 @@ func main() {
 		log.Fatal("Failed to initialize database:", err)
 	}
 
-	// 初始化服务
-	userService := service.NewUserService(db, cfg.Admin.Username, cfg.Admin.Password)
-	registryService := service.NewRegistryService(db)
-	whitelistService := service.NewWhitelistService(db)
-	logService := service.NewLogService(db)
+	return cfg, db
+}
 
-	// 设置路由
-	r := router.SetupRouter(userService, registryService, whitelistService, logService)
+// newCacheService 根据配置创建缓存服务
+func newCacheService(cfg *config.Config, db *gorm.DB, registryService *service.RegistryService, cachePolicyService *service.CachePolicyService) *service.CacheService {
+	cacheService := service.NewCacheService(
+		db,
+		registryService,
+		cachePolicyService,
+		cfg.Cache.Enabled,
+		cfg.Cache.Path,
+		cfg.Cache.MaxSize,
+		time.Duration(cfg.Cache.ManifestTTL)*time.Second,
+	)
+	cacheService.SetDeleteEnabled(cfg.Cache.DeleteEnabled)
+	if err := cacheService.LinkCachedBlobs(); err != nil {
+		log.Printf("Failed to link cached blobs to repositories: %v", err)
+	}
+	return cacheService
+}
 
-	// 启动服务器
-	address := fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port)
-	log.Printf("Server starting on %s", address)
-	log.Fatal(r.Run(address))
+// runGC 执行缓存垃圾回收：zmirror gc [--dry-run]
+func runGC(args []string) {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report blobs that would be deleted")
+	fs.Parse(args)
+
+	cfg, db := bootstrap()
+	cacheService := newCacheService(cfg, db, service.NewRegistryService(db), service.NewCachePolicyService(db))
+
+	result, err := cacheService.GarbageCollect(*dryRun)
+	if err != nil {
+		log.Fatal("Garbage collection failed:", err)
+	}
+
+	for _, digest := range result.Digests {
+		fmt.Println(digest)
+	}
+	if result.DryRun {
+		fmt.Printf("Dry run: %d manifests scanned, %d blobs (%d bytes) would be deleted\n", result.ManifestsScanned, result.BlobsDeleted, result.BytesReclaimed)
+	} else {
+		fmt.Printf("%d manifests scanned, %d blobs deleted, %d bytes reclaimed\n", result.ManifestsScanned, result.BlobsDeleted, result.BytesReclaimed)
+	}
+}
+
+// runBundle 离线包导出和导入：
+//
+//	zmirror bundle export --images list.txt --out bundle.tar
+//	zmirror bundle import --in bundle.tar
+func runBundle(args []string) {
+	if len(args) == 0 {
+		log.Fatal("Usage: zmirror bundle export|import [flags]")
+	}
+
+	switch args[0] {
+	case "export":
+		fs := flag.NewFlagSet("bundle export", flag.ExitOnError)
+		images := fs.String("images", "", "file with one image reference per line")
+		out := fs.String("out", "bundle.tar", "output file")
+		fs.Parse(args[1:])
+		if *images == "" {
+			log.Fatal("--images is required")
+		}
+
+		f, err := os.Open(*images)
+		if err != nil {
+			log.Fatal("Failed to open image list:", err)
+		}
+		refs, err := service.ReadImageList(f)
+		f.Close()
+		if err != nil {
+			log.Fatal("Failed to read image list:", err)
+		}
+
+		// 先写入临时文件，导出完整后再重命名
+		tmp := *out + ".tmp"
+		w, err := os.Create(tmp)
+		if err != nil {
+			log.Fatal("Failed to create bundle:", err)
+		}
+		if err := newImageService().ExportBundle(w, refs); err != nil {
+			w.Close()
+			os.Remove(tmp)
+			log.Fatal("Bundle export failed: ", err)
+		}
+		if err := w.Close(); err != nil {
+			os.Remove(tmp)
+			log.Fatal("Bundle export failed: ", err)
+		}
+		if err := os.Rename(tmp, *out); err != nil {
+			log.Fatal("Bundle export failed: ", err)
+		}
+		fmt.Printf("Exported %d images to %s\n", len(refs), *out)
+
+	case "import":
+		fs := flag.NewFlagSet("bundle import", flag.ExitOnError)
+		in := fs.String("in", "bundle.tar", "bundle file")
+		fs.Parse(args[1:])
+
+		f, err := os.Open(*in)
+		if err != nil {
+			log.Fatal("Failed to open bundle:", err)
+		}
+		defer f.Close()
+
+		result, err := newImageService().ImportBundle(f)
+		if err != nil {
+			log.Fatal("Bundle import failed: ", err)
+		}
+		for _, image := range result.Images {
+			fmt.Println(image)
+		}
+		fmt.Printf("Imported %d images: %d blobs (%d bytes) imported, %d already present\n", len(result.Images), result.BlobsImported, result.BytesImported, result.BlobsSkipped)
+
+	default:
+		log.Fatalf("Unknown bundle command: %s", args[0])
+	}
+}
+
+// newImageService 为命令行子命令创建镜像服务
+func newImageService() *service.ImageService {
+	cfg, db := bootstrap()
+	registryService := service.NewRegistryService(db)
+	proxyService := service.NewProxyService(registryService)
+	proxyService.SetOfflineMode(cfg.Proxy.Offline, cfg.Proxy.AutoOffline)
+	cacheService := newCacheService(cfg, db, registryService, service.NewCachePolicyService(db))
+	return service.NewImageService(proxyService, cacheService)
 }
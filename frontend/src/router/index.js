@@ -28,18 +28,60 @@ const routes = [
     component: () => import('../views/Registries.vue'),
     meta: { requiresAuth: true }
   },
+  {
+    path: '/groups',
+    name: 'RepositoryGroups',
+    component: () => import('../views/RepositoryGroups.vue'),
+    meta: { requiresAuth: true }
+  },
+  {
+    path: '/replication',
+    name: 'Replication',
+    component: () => import('../views/Replication.vue'),
+    meta: { requiresAuth: true }
+  },
+  {
+    path: '/watches',
+    name: 'TagWatches',
+    component: () => import('../views/TagWatches.vue'),
+    meta: { requiresAuth: true }
+  },
   {
     path: '/whitelists',
     name: 'Whitelists',
     component: () => import('../views/Whitelists.vue'),
     meta: { requiresAuth: true }
   },
+  {
+    path: '/cache-policies',
+    name: 'CachePolicies',
+    component: () => import('../views/CachePolicies.vue'),
+    meta: { requiresAuth: true }
+  },
+  {
+    path: '/retention',
+    name: 'RetentionPolicies',
+    component: () => import('../views/RetentionPolicies.vue'),
+    meta: { requiresAuth: true }
+  },
   {
     path: '/users',
     name: 'Users',
     component: () => import('../views/Users.vue'),
     meta: { requiresAuth: true }
   },
+  {
+    path: '/access-tokens',
+    name: 'AccessTokens',
+    component: () => import('../views/AccessTokens.vue'),
+    meta: { requiresAuth: true }
+  },
+  {
+    path: '/access-control',
+    name: 'AccessControl',
+    component: () => import('../views/AccessControl.vue'),
+    meta: { requiresAuth: true }
+  },
   {
     path: '/logs',
     name: 'Logs',
@@ -5,64 +5,91 @@ import axios from 'axios'
 export const useAuthStore = defineStore('auth', () => {
   const isLoggedIn = ref(false)
   const username = ref('')
-  const token = ref('')
+  const role = ref('')
+
+  // 刷新会话的请求，多个请求同时遇到token过期时只刷新一次
+  let refreshing = null
+
+  // 清除本地登录状态，会话token保存在HttpOnly cookie中，由服务端负责清除
+  const clear = () => {
+    isLoggedIn.value = false
+    username.value = ''
+    role.value = ''
+
+    localStorage.removeItem('auth_username')
+    localStorage.removeItem('auth_role')
+  }
 
   // 初始化
   const init = () => {
-    const savedToken = localStorage.getItem('auth_token')
+    // 旧版本保存在localStorage中的Basic认证信息不再使用
+    localStorage.removeItem('auth_token')
+
     const savedUsername = localStorage.getItem('auth_username')
-    
-    if (savedToken && savedUsername) {
-      token.value = savedToken
+    if (savedUsername) {
       username.value = savedUsername
+      role.value = localStorage.getItem('auth_role') || ''
       isLoggedIn.value = true
-      
-      // 设置axios默认header
-      axios.defaults.headers.common['Authorization'] = savedToken
     }
+
+    // 会话token过期时自动刷新后重试，会话失效时回到登录页
+    axios.interceptors.response.use(undefined, async (error) => {
+      const config = error.config || {}
+      const url = config.url || ''
+      if (error.response?.status !== 401 || !url.startsWith('/api/') || url.startsWith('/api/auth/')) {
+        return Promise.reject(error)
+      }
+
+      if (error.response.data?.error === 'session token expired' && !config._retried) {
+        try {
+          refreshing = refreshing || axios.post('/api/auth/refresh')
+          await refreshing
+        } catch (refreshError) {
+          clear()
+          window.location.hash = '#/login'
+          return Promise.reject(error)
+        } finally {
+          refreshing = null
+        }
+        return axios({ ...config, _retried: true })
+      }
+
+      clear()
+      window.location.hash = '#/login'
+      return Promise.reject(error)
+    })
   }
 
   // 登录
   const login = async (user, password) => {
-    const authToken = 'Basic ' + btoa(user + ':' + password)
-    
     try {
-      // 测试认证
-      await axios.get('/api/registries', {
-        headers: {
-          'Authorization': authToken
-        }
+      const response = await axios.post('/api/auth/login', {
+        username: user,
+        password
       })
-      
+
       // 登录成功
-      token.value = authToken
-      username.value = user
+      username.value = response.data.username
+      role.value = response.data.role
       isLoggedIn.value = true
-      
-      // 保存到localStorage
-      localStorage.setItem('auth_token', authToken)
-      localStorage.setItem('auth_username', user)
-      
-      // 设置axios默认header
-      axios.defaults.headers.common['Authorization'] = authToken
-      
+
+      // 只保存用户名和角色用于显示
+      localStorage.setItem('auth_username', username.value)
+      localStorage.setItem('auth_role', role.value)
     } catch (error) {
       throw new Error('用户名或密码错误')
     }
   }
 
   // 退出登录
-  const logout = () => {
-    isLoggedIn.value = false
-    username.value = ''
-    token.value = ''
-    
-    // 清除localStorage
-    localStorage.removeItem('auth_token')
-    localStorage.removeItem('auth_username')
-    
-    // 清除axios默认header
-    delete axios.defaults.headers.common['Authorization']
+  const logout = async () => {
+    try {
+      // 吊销服务端会话
+      await axios.post('/api/auth/logout')
+    } catch (error) {
+      console.error(error)
+    }
+    clear()
   }
 
   // 初始化
@@ -71,7 +98,7 @@ export const useAuthStore = defineStore('auth', () => {
   return {
     isLoggedIn,
     username,
-    token,
+    role,
     login,
     logout
   }
@@ -9,6 +9,7 @@ require (
 	github.com/glebarez/sqlite v1.9.0
 	github.com/golang-jwt/jwt/v5 v5.3.0
 	github.com/spf13/viper v1.16.0
+	golang.org/x/crypto v0.13.0
 	gorm.io/gorm v1.25.4
 )
 
@@ -46,7 +47,6 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.11 // indirect
 	golang.org/x/arch v0.3.0 // indirect
-	golang.org/x/crypto v0.13.0 // indirect
 	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/sys v0.12.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
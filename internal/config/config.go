@@ -12,6 +12,7 @@ type Config struct {
 		Host string `mapstructure:"host"`
 	} `mapstructure:"server"`
 
+	// 数据库中没有管理员时，用这个账号创建第一个超级管理员，之后不再使用
 	Admin struct {
 		Username string `mapstructure:"username"`
 		Password string `mapstructure:"password"`
@@ -20,6 +21,47 @@ type Config struct {
 	Database struct {
 		Path string `mapstructure:"path"`
 	} `mapstructure:"database"`
+
+	Cache struct {
+		Enabled       bool   `mapstructure:"enabled"`
+		Path          string `mapstructure:"path"`
+		MaxSize       int64  `mapstructure:"max_size"`       // 缓存总大小上限（MB），0表示不限制
+		ManifestTTL   int    `mapstructure:"manifest_ttl"`   // tag类manifest的缓存有效期（秒）
+		EvictInterval int    `mapstructure:"evict_interval"` // 后台淘汰任务的执行间隔（秒）
+		FsckInterval  int    `mapstructure:"fsck_interval"`  // 后台完整性检查的执行间隔（秒），0表示不执行
+
+		RetentionInterval int  `mapstructure:"retention_interval"` // 托管仓库保留策略的执行间隔（秒），0表示不执行
+		DeleteEnabled     bool `mapstructure:"delete_enabled"`     // 允许通过Registry API删除本地托管的manifest和tag
+		RefreshInterval   int  `mapstructure:"refresh_interval"`   // 热门tag后台刷新的执行间隔（秒），0表示不执行
+		RefreshTop        int  `mapstructure:"refresh_top"`        // 每次刷新拉取次数最多的前N个tag
+	} `mapstructure:"cache"`
+
+	Auth struct {
+		TokenEnabled bool   `mapstructure:"token_enabled"` // Registry API使用Bearer token认证，关闭后只使用Basic认证
+		Realm        string `mapstructure:"realm"`         // token服务的外部地址，为空时根据请求的Host生成
+		Service      string `mapstructure:"service"`       // token的audience，即客户端请求token时的service参数
+		Issuer       string `mapstructure:"issuer"`        // token的签发者
+		Algorithm    string `mapstructure:"algorithm"`     // 签名算法：RS256 或 ES256
+		KeyPath      string `mapstructure:"key_path"`      // 签名密钥目录
+		TokenExpiry  int    `mapstructure:"token_expiry"`  // token有效期（秒）
+		KeyRotation  int    `mapstructure:"key_rotation"`  // 签名密钥轮换间隔（小时），0表示不轮换
+
+		PasswordMemory      uint32 `mapstructure:"password_memory"`      // argon2id密码哈希的内存开销（KiB）
+		PasswordIterations  uint32 `mapstructure:"password_iterations"`  // argon2id密码哈希的迭代次数
+		PasswordParallelism uint8  `mapstructure:"password_parallelism"` // argon2id密码哈希的并行度
+
+		CredentialCacheTTL  int `mapstructure:"credential_cache_ttl"`  // 验证成功的用户名和密码缓存时间（秒），0表示不缓存
+		CredentialCacheSize int `mapstructure:"credential_cache_size"` // 凭据缓存的最大条目数
+
+		SessionTokenTTL        int `mapstructure:"session_token_ttl"`        // 管理界面会话token的有效期（秒），过期后自动刷新
+		SessionIdleTimeout     int `mapstructure:"session_idle_timeout"`     // 管理界面会话的空闲超时（秒）
+		SessionAbsoluteTimeout int `mapstructure:"session_absolute_timeout"` // 管理界面会话从登录开始的最长有效期（秒）
+	} `mapstructure:"auth"`
+
+	Proxy struct {
+		Offline     bool `mapstructure:"offline"`      // 离线模式：只从本地缓存响应，不访问上游
+		AutoOffline bool `mapstructure:"auto_offline"` // 所有镜像源熔断时自动进入离线模式
+	} `mapstructure:"proxy"`
 }
 
 // LoadConfig 加载配置文件
@@ -35,6 +77,7 @@ func LoadConfig() (*Config, error) {
 
 	viper.SetConfigFile(configPath)
 	viper.SetConfigType("toml")
+	setDefaults()
 
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, err
@@ -48,18 +91,107 @@ func LoadConfig() (*Config, error) {
 	return &config, nil
 }
 
+// setDefaults 设置默认值，兼容缺少新配置项的旧配置文件
+func setDefaults() {
+	viper.SetDefault("cache.enabled", true)
+	viper.SetDefault("cache.path", "./data/cache")
+	viper.SetDefault("cache.max_size", 0)
+	viper.SetDefault("cache.manifest_ttl", 300)
+	viper.SetDefault("cache.evict_interval", 600)
+	viper.SetDefault("cache.fsck_interval", 86400)
+	viper.SetDefault("cache.retention_interval", 3600)
+	viper.SetDefault("cache.delete_enabled", false)
+	viper.SetDefault("cache.refresh_interval", 120)
+	viper.SetDefault("cache.refresh_top", 20)
+	viper.SetDefault("auth.token_enabled", true)
+	viper.SetDefault("auth.realm", "")
+	viper.SetDefault("auth.service", "zmirror")
+	viper.SetDefault("auth.issuer", "zmirror")
+	viper.SetDefault("auth.algorithm", "ES256")
+	viper.SetDefault("auth.key_path", "./data/keys")
+	viper.SetDefault("auth.token_expiry", 900)
+	viper.SetDefault("auth.key_rotation", 720)
+	viper.SetDefault("auth.password_memory", 19456)
+	viper.SetDefault("auth.password_iterations", 2)
+	viper.SetDefault("auth.password_parallelism", 1)
+	viper.SetDefault("auth.credential_cache_ttl", 60)
+	viper.SetDefault("auth.credential_cache_size", 1024)
+	viper.SetDefault("auth.session_token_ttl", 900)
+	viper.SetDefault("auth.session_idle_timeout", 1800)
+	viper.SetDefault("auth.session_absolute_timeout", 43200)
+	viper.SetDefault("proxy.offline", false)
+	viper.SetDefault("proxy.auto_offline", false)
+}
+
 // createDefaultConfig 创建默认配置文件
 func createDefaultConfig(configPath string) error {
 	defaultConfig := `[server]
 host = "0.0.0.0"
 port = "5080"
 
+# 首次启动时用这个账号创建超级管理员，之后在管理界面中修改密码和管理其他管理员
 [admin]
 username = "admin"
 password = "xiaoz.org"
 
 [database]
 path = "./data/registry.db"
+
+[cache]
+enabled = true
+path = "./data/cache"
+# 缓存总大小上限（MB），0表示不限制
+max_size = 0
+# tag类manifest的缓存有效期（秒）
+manifest_ttl = 300
+# 后台淘汰任务的执行间隔（秒）
+evict_interval = 600
+# 后台完整性检查的执行间隔（秒），0表示不执行
+fsck_interval = 86400
+# 托管仓库保留策略的执行间隔（秒），0表示不执行
+retention_interval = 3600
+# 允许有删除权限的用户通过Registry API删除本地托管的manifest和tag
+delete_enabled = false
+# 热门tag后台刷新的执行间隔（秒），在manifest过期前重新验证，0表示不执行
+refresh_interval = 120
+# 每次刷新拉取次数最多的前N个tag
+refresh_top = 20
+
+[auth]
+# Registry API使用Bearer token认证，关闭后只使用Basic认证
+token_enabled = true
+# token服务的外部地址，如 https://mirror.example.com/token，为空时根据请求的Host生成
+realm = ""
+service = "zmirror"
+issuer = "zmirror"
+# 签名算法：RS256 或 ES256
+algorithm = "ES256"
+# 签名密钥目录
+key_path = "./data/keys"
+# token有效期（秒）
+token_expiry = 900
+# 签名密钥轮换间隔（小时），0表示不轮换
+key_rotation = 720
+# 用户密码使用argon2id哈希：内存开销（KiB）、迭代次数和并行度，修改后已有用户在下次登录时重新哈希
+password_memory = 19456
+password_iterations = 2
+password_parallelism = 1
+# 验证成功的用户名和密码缓存时间（秒），拉取多层镜像时只做一次密码校验，0表示不缓存
+credential_cache_ttl = 60
+# 凭据缓存的最大条目数
+credential_cache_size = 1024
+# 管理界面登录会话：会话token有效期（秒），过期后前端自动刷新
+session_token_ttl = 900
+# 超过这个时间（秒）没有操作需要重新登录
+session_idle_timeout = 1800
+# 从登录开始的最长有效期（秒），到期后必须重新登录
+session_absolute_timeout = 43200
+
+[proxy]
+# 离线模式：只从本地缓存响应，不访问上游
+offline = false
+# 所有镜像源熔断时自动进入离线模式
+auto_offline = false
 `
 
 	return os.WriteFile(configPath, []byte(defaultConfig), 0644)
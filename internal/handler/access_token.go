@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"zmirror/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 机器人账号
+
+func (h *AdminHandler) GetRobots(c *gin.Context) {
+	robots, err := h.accessTokenService.GetAllRobots()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, robots)
+}
+
+func (h *AdminHandler) CreateRobot(c *gin.Context) {
+	var robot model.RobotAccount
+	if err := c.ShouldBindJSON(&robot); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.accessTokenService.CreateRobot(&robot); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(201, robot)
+}
+
+func (h *AdminHandler) DeleteRobot(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid robot id"})
+		return
+	}
+
+	if err := h.accessTokenService.DeleteRobot(uint(id)); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "robot account deleted successfully"})
+}
+
+// 访问令牌
+
+func (h *AdminHandler) GetAccessTokens(c *gin.Context) {
+	tokens, err := h.accessTokenService.GetAllTokens()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, tokens)
+}
+
+func (h *AdminHandler) CreateAccessToken(c *gin.Context) {
+	var req struct {
+		Name         string   `json:"name"`
+		UserID       uint     `json:"user_id"`
+		RobotID      uint     `json:"robot_id"`
+		Repositories []string `json:"repositories"`
+		Actions      []string `json:"actions"`
+		ExpiresIn    int      `json:"expires_in"` // 有效天数，0表示永不过期
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ExpiresIn < 0 {
+		c.JSON(400, gin.H{"error": "expires_in must not be negative"})
+		return
+	}
+
+	token := model.AccessToken{
+		Name:         req.Name,
+		UserID:       req.UserID,
+		RobotID:      req.RobotID,
+		Repositories: req.Repositories,
+		Actions:      req.Actions,
+	}
+	if req.ExpiresIn > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresIn)
+		token.ExpiresAt = &expiresAt
+	}
+
+	secret, err := h.accessTokenService.CreateToken(&token)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 令牌明文只在创建时返回一次
+	c.JSON(201, gin.H{"token": secret, "access_token": token})
+}
+
+func (h *AdminHandler) DeleteAccessToken(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid access token id"})
+		return
+	}
+
+	if err := h.accessTokenService.DeleteToken(uint(id)); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "access token revoked successfully"})
+}
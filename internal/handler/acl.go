@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"strconv"
+
+	"zmirror/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 用户组
+
+func (h *AdminHandler) GetUserGroups(c *gin.Context) {
+	groups, err := h.aclService.GetAllGroups()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, groups)
+}
+
+func (h *AdminHandler) CreateUserGroup(c *gin.Context) {
+	var group model.UserGroup
+	if err := c.ShouldBindJSON(&group); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.aclService.CreateGroup(&group); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(201, gin.H{"message": "user group created successfully"})
+}
+
+func (h *AdminHandler) UpdateUserGroup(c *gin.Context) {
+	var group model.UserGroup
+	if err := c.ShouldBindJSON(&group); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.aclService.UpdateGroup(&group); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "user group updated successfully"})
+}
+
+func (h *AdminHandler) DeleteUserGroup(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user group id"})
+		return
+	}
+
+	if err := h.aclService.DeleteGroup(uint(id)); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "user group deleted successfully"})
+}
+
+// 仓库访问规则
+
+func (h *AdminHandler) GetACLRules(c *gin.Context) {
+	rules, err := h.aclService.GetAllRules()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, rules)
+}
+
+func (h *AdminHandler) CreateACLRule(c *gin.Context) {
+	var rule model.ACLRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.aclService.CreateRule(&rule); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(201, gin.H{"message": "access rule created successfully"})
+}
+
+func (h *AdminHandler) UpdateACLRule(c *gin.Context) {
+	var rule model.ACLRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.aclService.UpdateRule(&rule); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "access rule updated successfully"})
+}
+
+func (h *AdminHandler) DeleteACLRule(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid access rule id"})
+		return
+	}
+
+	if err := h.aclService.DeleteRule(uint(id)); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "access rule deleted successfully"})
+}
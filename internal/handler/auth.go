@@ -5,92 +5,86 @@ import (
 	"strings"
 	"time"
 
+	"zmirror/internal/model"
 	"zmirror/internal/service"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
 type AuthHandler struct {
-	userService *service.UserService
-	jwtSecret   []byte
+	userService        *service.UserService
+	tokenService       *service.TokenService
+	accessTokenService *service.AccessTokenService
 }
 
-func NewAuthHandler(userService *service.UserService) *AuthHandler {
+func NewAuthHandler(userService *service.UserService, tokenService *service.TokenService, accessTokenService *service.AccessTokenService) *AuthHandler {
 	return &AuthHandler{
-		userService: userService,
-		jwtSecret:   []byte("your-secret-key"), // 在生产环境中应该从配置读取
+		userService:        userService,
+		tokenService:       tokenService,
+		accessTokenService: accessTokenService,
 	}
 }
 
-// TokenResponse JWT token响应
+// TokenResponse Docker Registry token响应，token和access_token内容相同以兼容不同客户端
 type TokenResponse struct {
-	Token     string `json:"token"`
-	ExpiresIn int    `json:"expires_in"`
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	IssuedAt    string `json:"issued_at"`
 }
 
-// GetToken 获取Bearer token
+// GetToken 获取Bearer token。不带认证信息时以匿名身份签发，只包含白名单镜像的拉取权限；
+// 请求的scope按用户权限过滤，没有权限的操作不会出现在token中
 func (h *AuthHandler) GetToken(c *gin.Context) {
-	// 获取service和scope参数
-	service := c.Query("service")
-	scope := c.Query("scope")
-
-	// 检查Basic认证
-	authHeader := c.Request.Header.Get("Authorization")
-	if authHeader == "" {
-		c.Header("WWW-Authenticate", `Basic realm="Docker Registry"`)
-		c.JSON(401, gin.H{"error": "authentication required"})
+	if name := c.Query("service"); name != "" && name != h.tokenService.Service() {
+		c.JSON(400, gin.H{"error": "invalid service"})
 		return
 	}
 
-	username, password, ok := parseBasicAuth(authHeader)
-	if !ok {
-		c.Header("WWW-Authenticate", `Basic realm="Docker Registry"`)
-		c.JSON(401, gin.H{"error": "invalid authorization header"})
-		return
-	}
-
-	// 验证用户
-	user, err := h.userService.AuthenticateUser(username, password)
-	if err != nil {
-		c.Header("WWW-Authenticate", `Basic realm="Docker Registry"`)
-		c.JSON(401, gin.H{"error": "invalid credentials"})
-		return
-	}
-
-	// 创建JWT claims
-	claims := jwt.MapClaims{
-		"iss":    "localhost:8080",                 // issuer
-		"sub":    user.Username,                    // subject (username)
-		"aud":    service,                          // audience (service)
-		"exp":    time.Now().Add(time.Hour).Unix(), // expires in 1 hour
-		"nbf":    time.Now().Unix(),                // not before
-		"iat":    time.Now().Unix(),                // issued at
-		"jti":    "random-jwt-id",                  // JWT ID
-		"access": []map[string]interface{}{},       // access permissions
+	var user *model.User
+	var accessToken *model.AccessToken
+	if authHeader := c.Request.Header.Get("Authorization"); authHeader != "" {
+		username, password, ok := parseBasicAuth(authHeader)
+		if !ok {
+			c.Header("WWW-Authenticate", `Basic realm="Docker Registry"`)
+			c.JSON(401, gin.H{"error": "invalid authorization header"})
+			return
+		}
+
+		authenticated, token, err := service.AuthenticateCredential(h.userService, h.accessTokenService, username, password, c.ClientIP())
+		if err != nil {
+			c.Header("WWW-Authenticate", `Basic realm="Docker Registry"`)
+			c.JSON(401, gin.H{"error": "invalid credentials"})
+			return
+		}
+		user = authenticated
+		accessToken = token
 	}
 
-	// 如果有scope，解析并添加到access中
-	if scope != "" {
-		access := parseScope(scope)
-		claims["access"] = access
+	access := h.tokenService.Authorize(user, service.ParseScopes(c.QueryArray("scope")))
+	if accessToken != nil {
+		// 访问令牌只能获得其允许的仓库和操作
+		access = h.tokenService.RestrictAccess(accessToken, access)
 	}
-
-	// 创建token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(h.jwtSecret)
+	issued, err := h.tokenService.Issue(user, access)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "failed to generate token"})
 		return
 	}
 
-	// 返回token
 	c.JSON(200, TokenResponse{
-		Token:     tokenString,
-		ExpiresIn: 3600, // 1 hour
+		Token:       issued.Token,
+		AccessToken: issued.Token,
+		ExpiresIn:   issued.ExpiresIn,
+		IssuedAt:    issued.IssuedAt.UTC().Format(time.RFC3339),
 	})
 }
 
+// GetJWKS 获取校验token使用的公钥
+func (h *AuthHandler) GetJWKS(c *gin.Context) {
+	c.JSON(200, h.tokenService.JWKS())
+}
+
 // parseBasicAuth 解析Basic认证头
 func parseBasicAuth(authHeader string) (username, password string, ok bool) {
 	const prefix = "Basic "
@@ -112,21 +106,3 @@ func parseBasicAuth(authHeader string) (username, password string, ok bool) {
 
 	return parts[0], parts[1], true
 }
-
-// parseScope 解析Docker Registry scope
-func parseScope(scope string) []map[string]interface{} {
-	// scope格式: "repository:helloz/zurl:pull"
-	access := []map[string]interface{}{}
-
-	parts := strings.SplitN(scope, ":", 3)
-	if len(parts) == 3 && parts[0] == "repository" {
-		actions := strings.Split(parts[2], ",")
-		access = append(access, map[string]interface{}{
-			"type":    parts[0],
-			"name":    parts[1],
-			"actions": actions,
-		})
-	}
-
-	return access
-}
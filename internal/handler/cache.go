@@ -0,0 +1,410 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"zmirror/internal/model"
+	"zmirror/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveFromCache 尝试从本地缓存响应manifest或blob请求，命中时返回true。allowStale为true时忽略tag的缓存有效期和客户端的Accept
+func (h *RegistryHandler) serveFromCache(c *gin.Context, name, kind, reference string, allowStale bool) bool {
+	switch kind {
+	case "blobs":
+		// blob按digest全局缓存，只响应关联到该仓库的blob，其他仓库的请求由上游确认
+		if !h.cacheService.BlobLinked(name, reference) {
+			return false
+		}
+		f, blob, err := h.cacheService.OpenBlob(reference)
+		if err != nil {
+			return false
+		}
+		defer f.Close()
+
+		mediaType := blob.MediaType
+		if mediaType == "" {
+			mediaType = "application/octet-stream"
+		}
+		c.Header("Content-Type", mediaType)
+		c.Header("Docker-Content-Digest", blob.Digest)
+		c.Header("Docker-Distribution-API-Version", "registry/2.0")
+		c.Header("ETag", `"`+blob.Digest+`"`)
+		// ServeContent同时处理HEAD和Range请求
+		http.ServeContent(c.Writer, c.Request, "", blob.CreatedAt, f)
+		return true
+
+	case "manifests":
+		manifest, content, err := h.cacheService.GetManifest(name, reference)
+		if err != nil || (!allowStale && !h.cacheService.IsFresh(manifest)) {
+			return false
+		}
+		// tag可能同时有index和单平台manifest等多种格式，缓存的格式不在客户端Accept中时从上游获取
+		if !allowStale && !manifest.Hosted && !service.IsDigest(reference) && !acceptsMediaType(c.Request.Header, manifest.MediaType) {
+			return false
+		}
+
+		c.Header("Docker-Content-Digest", manifest.Digest)
+		c.Header("Docker-Distribution-API-Version", "registry/2.0")
+		c.Header("Content-Length", strconv.Itoa(len(content)))
+		if c.Request.Method == "HEAD" {
+			c.Header("Content-Type", manifest.MediaType)
+			c.Status(200)
+			return true
+		}
+		c.Data(200, manifest.MediaType, content)
+		return true
+	}
+
+	return false
+}
+
+// acceptsMediaType 判断请求的Accept是否包含给定的媒体类型，未指定Accept或包含*/*时接受任意类型
+func acceptsMediaType(header http.Header, mediaType string) bool {
+	mediaType, _, _ = strings.Cut(mediaType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+
+	accepted := false
+	for _, value := range header.Values("Accept") {
+		for _, item := range strings.Split(value, ",") {
+			item, _, _ = strings.Cut(item, ";")
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			if item == "*/*" || strings.EqualFold(item, mediaType) {
+				return true
+			}
+			accepted = true
+		}
+	}
+	return !accepted
+}
+
+// serveOffline 离线模式下只从本地缓存响应，未命中时返回标准Registry错误
+func (h *RegistryHandler) serveOffline(c *gin.Context, name, kind, reference string) {
+	method := c.Request.Method
+	if method != "GET" && method != "HEAD" {
+		registryError(c, 405, "UNSUPPORTED", "operation not supported in offline mode")
+		return
+	}
+
+	if h.cacheService.Enabled() {
+		switch kind {
+		case "manifests", "blobs":
+			if h.serveFromCache(c, name, kind, reference, true) {
+				return
+			}
+		case "tags":
+			tags, err := h.cacheService.GetTags(name)
+			if err == nil && len(tags) > 0 {
+				c.Header("Docker-Distribution-API-Version", "registry/2.0")
+				c.JSON(200, gin.H{"name": name, "tags": tags})
+				return
+			}
+		}
+	}
+
+	switch kind {
+	case "manifests":
+		registryError(c, 404, "MANIFEST_UNKNOWN", "manifest unknown (offline mode)")
+	case "blobs":
+		registryError(c, 404, "BLOB_UNKNOWN", "blob unknown (offline mode)")
+	default:
+		registryError(c, 404, "NAME_UNKNOWN", "repository name not known to registry (offline mode)")
+	}
+}
+
+// copyAndCache 将上游响应写回客户端，同时写入本地缓存
+func (h *RegistryHandler) copyAndCache(c *gin.Context, resp *http.Response, upstream, name, kind, reference string) {
+	switch kind {
+	case "blobs":
+		w, err := h.cacheService.CreateBlobWriter(reference, resp.Header.Get("Content-Type"), upstream)
+		if err != nil {
+			io.Copy(c.Writer, resp.Body)
+			return
+		}
+		if _, err := io.Copy(c.Writer, io.TeeReader(resp.Body, w)); err != nil {
+			w.Cancel()
+			return
+		}
+		if err := w.Commit(); err != nil {
+			log.Printf("Failed to cache blob %s: %v", reference, err)
+			return
+		}
+		if err := h.cacheService.LinkBlobs(name, reference); err != nil {
+			log.Printf("Failed to link blob %s to %s: %v", reference, name, err)
+		}
+
+	case "manifests":
+		content, err := io.ReadAll(io.LimitReader(resp.Body, service.MaxManifestSize+1))
+		if err != nil {
+			return
+		}
+		c.Writer.Write(content)
+		if len(content) > service.MaxManifestSize {
+			io.Copy(c.Writer, resp.Body)
+			return
+		}
+		if _, err := h.cacheService.PutManifest(name, reference, content, resp.Header.Get("Content-Type"), upstream, false); err != nil {
+			log.Printf("Failed to cache manifest %s:%s: %v", name, reference, err)
+		}
+
+	default:
+		io.Copy(c.Writer, resp.Body)
+	}
+}
+
+// 离线模式
+
+func (h *AdminHandler) GetOfflineStatus(c *gin.Context) {
+	status, err := h.proxyService.GetOfflineStatus()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, status)
+}
+
+func (h *AdminHandler) UpdateOfflineMode(c *gin.Context) {
+	var req struct {
+		Offline     bool `json:"offline"`
+		AutoOffline bool `json:"auto_offline"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.proxyService.SetOfflineMode(req.Offline, req.AutoOffline)
+	h.GetOfflineStatus(c)
+}
+
+// 缓存管理
+
+func (h *AdminHandler) GetCacheStats(c *gin.Context) {
+	stats, err := h.cacheService.GetStats()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, stats)
+}
+
+func (h *AdminHandler) EvictCache(c *gin.Context) {
+	result, err := h.cacheService.Evict()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, result)
+}
+
+func (h *AdminHandler) GarbageCollect(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+
+	result, err := h.cacheService.GarbageCollect(dryRun)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, result)
+}
+
+func (h *AdminHandler) GetFsckStatus(c *gin.Context) {
+	c.JSON(200, h.cacheService.GetFsckStatus())
+}
+
+func (h *AdminHandler) RunFsck(c *gin.Context) {
+	if h.cacheService.GetFsckStatus().Running {
+		c.JSON(409, gin.H{"error": service.ErrFsckRunning.Error()})
+		return
+	}
+
+	// 完整性检查需要读取全部缓存，后台执行
+	go func() {
+		if _, err := h.cacheService.Fsck(); err != nil {
+			log.Printf("Cache fsck failed: %v", err)
+		}
+	}()
+	c.JSON(202, gin.H{"message": "fsck started"})
+}
+
+func (h *AdminHandler) GetRefreshStatus(c *gin.Context) {
+	c.JSON(200, h.refreshService.GetStatus())
+}
+
+func (h *AdminHandler) RunRefresh(c *gin.Context) {
+	if !h.cacheService.Enabled() {
+		c.JSON(409, gin.H{"error": service.ErrCacheDisabled.Error()})
+		return
+	}
+	if h.proxyService.IsOffline() {
+		c.JSON(409, gin.H{"error": service.ErrOffline.Error()})
+		return
+	}
+	if h.refreshService.GetStatus().Running {
+		c.JSON(409, gin.H{"error": service.ErrRefreshRunning.Error()})
+		return
+	}
+
+	// 刷新可能需要预取镜像内容，后台执行
+	go func() {
+		if _, err := h.refreshService.Refresh(); err != nil {
+			log.Printf("Refresh failed: %v", err)
+		}
+	}()
+	c.JSON(202, gin.H{"message": "refresh started"})
+}
+
+func (h *AdminHandler) GetCachedRepositories(c *gin.Context) {
+	repositories, err := h.cacheService.GetRepositories()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, repositories)
+}
+
+func (h *AdminHandler) PurgeCachedRepository(c *gin.Context) {
+	repository := c.Query("repository")
+	if repository == "" {
+		c.JSON(400, gin.H{"error": "repository is required"})
+		return
+	}
+
+	result, err := h.cacheService.PurgeRepository(repository)
+	if err != nil {
+		respondCacheError(c, err)
+		return
+	}
+	c.JSON(200, result)
+}
+
+func (h *AdminHandler) GetCachedManifests(c *gin.Context) {
+	repository := c.Query("repository")
+	if repository == "" {
+		c.JSON(400, gin.H{"error": "repository is required"})
+		return
+	}
+
+	manifests, err := h.cacheService.GetManifests(repository)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, manifests)
+}
+
+func (h *AdminHandler) PurgeCachedManifest(c *gin.Context) {
+	repository := c.Query("repository")
+	reference := c.Query("reference")
+	if repository == "" || reference == "" {
+		c.JSON(400, gin.H{"error": "repository and reference are required"})
+		return
+	}
+
+	result, err := h.cacheService.PurgeManifest(repository, reference)
+	if err != nil {
+		respondCacheError(c, err)
+		return
+	}
+	c.JSON(200, result)
+}
+
+func (h *AdminHandler) GetCachedImage(c *gin.Context) {
+	repository := c.Query("repository")
+	reference := c.Query("reference")
+	if repository == "" || reference == "" {
+		c.JSON(400, gin.H{"error": "repository and reference are required"})
+		return
+	}
+
+	image, err := h.cacheService.GetImage(repository, reference)
+	if err != nil {
+		respondCacheError(c, err)
+		return
+	}
+	c.JSON(200, image)
+}
+
+func (h *AdminHandler) PurgeCachedBlob(c *gin.Context) {
+	result, err := h.cacheService.PurgeBlob(c.Param("digest"))
+	if err != nil {
+		respondCacheError(c, err)
+		return
+	}
+	c.JSON(200, result)
+}
+
+// respondCacheError 缓存未命中返回404，其他错误返回500
+func respondCacheError(c *gin.Context, err error) {
+	if errors.Is(err, service.ErrCacheMiss) {
+		c.JSON(404, gin.H{"error": "not found in cache"})
+		return
+	}
+	c.JSON(500, gin.H{"error": err.Error()})
+}
+
+// 缓存规则
+
+func (h *AdminHandler) GetCachePolicies(c *gin.Context) {
+	policies, err := h.cachePolicyService.GetAllPolicies()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, policies)
+}
+
+func (h *AdminHandler) CreateCachePolicy(c *gin.Context) {
+	var policy model.CachePolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.cachePolicyService.CreatePolicy(&policy); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(201, gin.H{"message": "cache policy created successfully"})
+}
+
+func (h *AdminHandler) UpdateCachePolicy(c *gin.Context) {
+	var policy model.CachePolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.cachePolicyService.UpdatePolicy(&policy); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "cache policy updated successfully"})
+}
+
+func (h *AdminHandler) DeleteCachePolicy(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid cache policy id"})
+		return
+	}
+
+	if err := h.cachePolicyService.DeletePolicy(uint(id)); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "cache policy deleted successfully"})
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"zmirror/internal/model"
+	"zmirror/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// deleteManifest 删除本地托管仓库中的manifest或tag：
+// DELETE /v2/<name>/manifests/<digest> 删除manifest及指向它的所有tag，
+// DELETE /v2/<name>/manifests/<tag> 只删除tag。每次请求都记录审计日志
+func (h *RegistryHandler) deleteManifest(c *gin.Context, name, kind, reference string) {
+	entry := &model.AuditLog{
+		ClientIP:   c.ClientIP(),
+		Action:     "delete_tag",
+		Repository: name,
+		Reference:  reference,
+		CreatedAt:  time.Now(),
+	}
+	if service.IsDigest(reference) {
+		entry.Action = "delete_manifest"
+	}
+	if user, ok := c.Get("user"); ok {
+		if u, ok := user.(*model.User); ok {
+			entry.Username = u.Username
+		}
+	}
+
+	status, code, message := h.performDelete(c, entry, kind, name, reference)
+	entry.StatusCode = status
+	if status != 202 {
+		entry.Message = message
+	}
+	if err := h.logService.LogAudit(entry); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+
+	if status == 202 {
+		c.Header("Docker-Distribution-API-Version", "registry/2.0")
+		c.Status(202)
+		return
+	}
+	if status == 401 {
+		c.Header("WWW-Authenticate", `Basic realm="Docker Registry"`)
+	}
+	registryError(c, status, code, message)
+}
+
+// performDelete 检查开关和权限后执行删除，返回状态码及错误信息
+func (h *RegistryHandler) performDelete(c *gin.Context, entry *model.AuditLog, kind, name, reference string) (int, string, string) {
+	if kind != "manifests" || reference == "" {
+		return 405, "UNSUPPORTED", "only manifests and tags can be deleted"
+	}
+	if !h.cacheService.DeleteEnabled() {
+		return 405, "UNSUPPORTED", "deletion is disabled"
+	}
+
+	user, _ := c.Get("user")
+	u, ok := user.(*model.User)
+	if !ok {
+		return 401, "UNAUTHORIZED", "authentication required"
+	}
+	if !u.IsAdmin && !u.CanDelete {
+		return 403, "DENIED", "delete permission required"
+	}
+
+	digest, _, err := h.cacheService.DeleteHostedManifest(name, reference)
+	if err != nil {
+		if errors.Is(err, service.ErrCacheMiss) {
+			return 404, "MANIFEST_UNKNOWN", "manifest unknown"
+		}
+		return 500, "UNKNOWN", err.Error()
+	}
+	entry.Digest = digest
+	log.Printf("Deleted %s:%s (%s) by %s", name, reference, digest, u.Username)
+	return 202, "", ""
+}
@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"errors"
 	"io"
+	"net/http"
 	"strconv"
 	"time"
 
@@ -18,13 +20,19 @@ type RegistryHandler struct {
 	proxyService    *service.ProxyService
 	registryService *service.RegistryService
 	logService      *service.LogService
+	cacheService    *service.CacheService
+	groupService    *service.GroupService
+	pushService     *service.PushRouteService
 }
 
-func NewRegistryHandler(proxyService *service.ProxyService, registryService *service.RegistryService, logService *service.LogService) *RegistryHandler {
+func NewRegistryHandler(proxyService *service.ProxyService, registryService *service.RegistryService, logService *service.LogService, cacheService *service.CacheService, groupService *service.GroupService, pushService *service.PushRouteService) *RegistryHandler {
 	return &RegistryHandler{
 		proxyService:    proxyService,
 		registryService: registryService,
 		logService:      logService,
+		cacheService:    cacheService,
+		groupService:    groupService,
+		pushService:     pushService,
 	}
 }
 
@@ -37,8 +45,53 @@ func (h *RegistryHandler) ProxyToRegistry(c *gin.Context) {
 		path += "?" + c.Request.URL.RawQuery
 	}
 
+	name, kind, reference := service.ParseRegistryPath(c.Request.URL.Path)
+
+	// 仓库组：去掉组名后先查找本地托管内容，再按组内顺序代理
+	group, name := h.groupService.Resolve(name)
+	if group != nil {
+		path = "/v2/" + name + "/" + kind + "/" + reference
+		if c.Request.URL.RawQuery != "" {
+			path += "?" + c.Request.URL.RawQuery
+		}
+		if kind == "tags" && h.serveHostedTags(c, name) {
+			return
+		}
+	}
+
+	// 删除只作用于本地托管内容，不转发到上游
+	if method == "DELETE" {
+		h.deleteManifest(c, name, kind, reference)
+		return
+	}
+
+	// 离线模式下不访问上游
+	if h.proxyService.IsOffline() {
+		h.serveOffline(c, name, kind, reference)
+		return
+	}
+
+	// 推送路由：推送相关请求连同请求体透传到指定镜像源，拉取只有路由开启了 ProxyPulls 时才发往该镜像源
+	push := isPushRequest(method, kind, reference) || isPushCheck(c, method, kind)
+	_, target, err := h.pushService.Match(name, push)
+	if err != nil {
+		registryError(c, 500, "UNKNOWN", "failed to match push route")
+		return
+	}
+	if push && target != nil {
+		h.forwardPush(c, target, name, kind, reference, path)
+		return
+	}
+
+	// 优先从本地缓存响应
+	if h.cacheService.Enabled() && (method == "GET" || method == "HEAD") {
+		if h.serveFromCache(c, name, kind, reference, false) {
+			return
+		}
+	}
+
 	// 代理请求
-	resp, _, err := h.proxyService.ProxyRequest(method, path, c.Request.Header)
+	resp, upstream, err := h.proxy(group, target, name, method, path, c.Request.Header)
 	if err != nil {
 		c.JSON(500, gin.H{"errors": []gin.H{{"code": "UNKNOWN", "message": "failed to proxy request"}}})
 		return
@@ -55,8 +108,12 @@ func (h *RegistryHandler) ProxyToRegistry(c *gin.Context) {
 	// 设置状态码
 	c.Status(resp.StatusCode)
 
-	// 复制响应体
-	io.Copy(c.Writer, resp.Body)
+	// 复制响应体，完整的GET响应同时写入缓存
+	if h.cacheService.Enabled() && method == "GET" && resp.StatusCode == 200 {
+		h.copyAndCache(c, resp, upstream, name, kind, reference)
+	} else {
+		io.Copy(c.Writer, resp.Body)
+	}
 
 	// 记录代理日志
 	if user, exists := c.Get("user"); exists {
@@ -75,6 +132,44 @@ func (h *RegistryHandler) ProxyToRegistry(c *gin.Context) {
 	}
 }
 
+// proxy 代理请求到上游。推送路由开启了 ProxyPulls 的仓库使用路由的镜像源及其凭证，仓库组按组内配置的镜像源顺序查找
+func (h *RegistryHandler) proxy(group *model.RepositoryGroup, route *model.Registry, name, method, path string, headers http.Header) (*http.Response, string, error) {
+	if route != nil {
+		resp, err := h.proxyService.Forward(route, name, method, path, headers, nil, 0)
+		return resp, route.URL, err
+	}
+	if group == nil {
+		return h.proxyService.ProxyRequest(method, path, headers)
+	}
+
+	registries, err := h.groupService.GroupRegistries(group)
+	if err != nil {
+		return nil, "", err
+	}
+	return h.proxyService.ProxyRequestTo(registries, method, path, headers)
+}
+
+// serveHostedTags 仓库包含本地托管内容时直接返回托管的tag列表
+func (h *RegistryHandler) serveHostedTags(c *gin.Context, name string) bool {
+	if !h.cacheService.Enabled() {
+		return false
+	}
+	tags, err := h.cacheService.GetHostedTags(name)
+	if err != nil || len(tags) == 0 {
+		return false
+	}
+
+	c.Header("Docker-Distribution-API-Version", "registry/2.0")
+	c.JSON(200, gin.H{"name": name, "tags": tags})
+	return true
+}
+
+// registryError 返回Docker Registry规范格式的错误
+func registryError(c *gin.Context, status int, code, message string) {
+	c.Header("Docker-Distribution-API-Version", "registry/2.0")
+	c.JSON(status, gin.H{"errors": []gin.H{{"code": code, "message": message}}})
+}
+
 // GetVersion Docker Registry版本检查
 func (h *RegistryHandler) GetVersion(c *gin.Context) {
 	c.Header("Docker-Distribution-API-Version", "registry/2.0")
@@ -85,18 +180,46 @@ func (h *RegistryHandler) GetVersion(c *gin.Context) {
 }
 
 type AdminHandler struct {
-	userService      *service.UserService
-	registryService  *service.RegistryService
-	whitelistService *service.WhitelistService
-	logService       *service.LogService
+	proxyService       *service.ProxyService
+	userService        *service.UserService
+	registryService    *service.RegistryService
+	whitelistService   *service.WhitelistService
+	logService         *service.LogService
+	cacheService       *service.CacheService
+	cachePolicyService *service.CachePolicyService
+	imageService       *service.ImageService
+	groupService       *service.GroupService
+	replicationService *service.ReplicationService
+	retentionService   *service.RetentionService
+	pushRouteService   *service.PushRouteService
+	watchService       *service.WatchService
+	refreshService     *service.RefreshService
+	accessTokenService *service.AccessTokenService
+	aclService         *service.ACLService
+	policyService      *service.PolicyService
+	sessionService     *service.SessionService
 }
 
-func NewAdminHandler(userService *service.UserService, registryService *service.RegistryService, whitelistService *service.WhitelistService, logService *service.LogService) *AdminHandler {
+func NewAdminHandler(proxyService *service.ProxyService, userService *service.UserService, registryService *service.RegistryService, whitelistService *service.WhitelistService, logService *service.LogService, cacheService *service.CacheService, cachePolicyService *service.CachePolicyService, imageService *service.ImageService, groupService *service.GroupService, replicationService *service.ReplicationService, retentionService *service.RetentionService, pushRouteService *service.PushRouteService, watchService *service.WatchService, refreshService *service.RefreshService, accessTokenService *service.AccessTokenService, aclService *service.ACLService, policyService *service.PolicyService, sessionService *service.SessionService) *AdminHandler {
 	return &AdminHandler{
-		userService:      userService,
-		registryService:  registryService,
-		whitelistService: whitelistService,
-		logService:       logService,
+		proxyService:       proxyService,
+		userService:        userService,
+		registryService:    registryService,
+		whitelistService:   whitelistService,
+		logService:         logService,
+		cacheService:       cacheService,
+		cachePolicyService: cachePolicyService,
+		imageService:       imageService,
+		groupService:       groupService,
+		replicationService: replicationService,
+		retentionService:   retentionService,
+		pushRouteService:   pushRouteService,
+		watchService:       watchService,
+		refreshService:     refreshService,
+		accessTokenService: accessTokenService,
+		aclService:         aclService,
+		policyService:      policyService,
+		sessionService:     sessionService,
 	}
 }
 
@@ -108,6 +231,10 @@ func (h *AdminHandler) GetUsers(c *gin.Context) {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	// 不返回密码哈希
+	for i := range users {
+		users[i].Password = ""
+	}
 	c.JSON(200, users)
 }
 
@@ -119,7 +246,7 @@ func (h *AdminHandler) CreateUser(c *gin.Context) {
 	}
 
 	if err := h.userService.CreateUser(&user); err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -135,6 +262,10 @@ func (h *AdminHandler) DeleteUser(c *gin.Context) {
 	}
 
 	if err := h.userService.DeleteUser(uint(id)); err != nil {
+		if errors.Is(err, service.ErrLastSuperAdmin) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
@@ -142,6 +273,89 @@ func (h *AdminHandler) DeleteUser(c *gin.Context) {
 	c.JSON(200, gin.H{"message": "user deleted successfully"})
 }
 
+func (h *AdminHandler) UpdateUserPassword(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	var req struct {
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.userService.UpdatePassword(uint(id), req.Password); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "user password updated successfully"})
+}
+
+func (h *AdminHandler) UpdateUserPermissions(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	var req struct {
+		CanDelete bool `json:"can_delete"`
+		CanPush   bool `json:"can_push"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.userService.UpdatePermissions(uint(id), req.CanDelete, req.CanPush); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "user permissions updated successfully"})
+}
+
+func (h *AdminHandler) UpdateUserRole(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"` // 为空表示降为普通用户
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.userService.UpdateRole(uint(id), req.Role); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "user role updated successfully"})
+}
+
+// GetCurrentUser 返回当前登录的管理员，管理界面据此显示角色
+func (h *AdminHandler) GetCurrentUser(c *gin.Context) {
+	user := c.MustGet("admin").(*model.User)
+	c.JSON(200, gin.H{
+		"id":       user.ID,
+		"username": user.Username,
+		"role":     user.Role,
+	})
+}
+
 // 镜像源管理
 
 func (h *AdminHandler) GetRegistries(c *gin.Context) {
@@ -150,6 +364,10 @@ func (h *AdminHandler) GetRegistries(c *gin.Context) {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	// 不返回镜像源密码
+	for i := range registries {
+		registries[i].Password = ""
+	}
 	c.JSON(200, registries)
 }
 
@@ -199,6 +417,63 @@ func (h *AdminHandler) DeleteRegistry(c *gin.Context) {
 	c.JSON(200, gin.H{"message": "registry deleted successfully"})
 }
 
+// 仓库组管理
+
+func (h *AdminHandler) GetGroups(c *gin.Context) {
+	groups, err := h.groupService.GetAllGroups()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, groups)
+}
+
+func (h *AdminHandler) CreateGroup(c *gin.Context) {
+	var group model.RepositoryGroup
+	if err := c.ShouldBindJSON(&group); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.groupService.CreateGroup(&group); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(201, gin.H{"message": "group created successfully"})
+}
+
+func (h *AdminHandler) UpdateGroup(c *gin.Context) {
+	var group model.RepositoryGroup
+	if err := c.ShouldBindJSON(&group); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.groupService.UpdateGroup(&group); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "group updated successfully"})
+}
+
+func (h *AdminHandler) DeleteGroup(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid group id"})
+		return
+	}
+
+	if err := h.groupService.DeleteGroup(uint(id)); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "group deleted successfully"})
+}
+
 // 白名单管理
 
 func (h *AdminHandler) GetWhitelists(c *gin.Context) {
@@ -218,13 +493,28 @@ func (h *AdminHandler) CreateWhitelist(c *gin.Context) {
 	}
 
 	if err := h.whitelistService.CreateWhitelist(&whitelist); err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(201, gin.H{"message": "whitelist created successfully"})
 }
 
+func (h *AdminHandler) UpdateWhitelist(c *gin.Context) {
+	var whitelist model.Whitelist
+	if err := c.ShouldBindJSON(&whitelist); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.whitelistService.UpdateWhitelist(&whitelist); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "whitelist updated successfully"})
+}
+
 func (h *AdminHandler) DeleteWhitelist(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseUint(idStr, 10, 32)
@@ -267,6 +557,21 @@ func (h *AdminHandler) ClearAccessLogs(c *gin.Context) {
 	c.JSON(200, gin.H{"message": "access logs cleared successfully"})
 }
 
+func (h *AdminHandler) GetAuditLogs(c *gin.Context) {
+	limitStr := c.DefaultQuery("limit", "100")
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil {
+		limit = 100
+	}
+
+	logs, err := h.logService.GetAuditLogs(limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, logs)
+}
+
 // 系统信息
 
 func (h *AdminHandler) GetVersion(c *gin.Context) {
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"log"
+	"strings"
+
+	"zmirror/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 镜像导出
+
+func (h *AdminHandler) ExportImage(c *gin.Context) {
+	ref := c.Query("ref")
+	if ref == "" {
+		c.JSON(400, gin.H{"error": "ref is required"})
+		return
+	}
+	format := c.DefaultQuery("format", service.ExportFormatDocker)
+	if format != service.ExportFormatDocker && format != service.ExportFormatOCI {
+		c.JSON(400, gin.H{"error": "format must be docker or oci"})
+		return
+	}
+
+	export, err := h.imageService.PrepareExport(ref, c.Query("platform"))
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidReference) || errors.Is(err, service.ErrInvalidPlatform) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrPlatformNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-tar")
+	c.Header("Content-Disposition", `attachment; filename="`+export.FileName()+`"`)
+	c.Header("Docker-Content-Digest", export.Manifest.Digest)
+	c.Status(200)
+
+	// 响应头已发出，出错时只能中断tar流，不完整的tar会被 docker load 拒绝
+	if err := h.imageService.WriteExport(c.Writer, export, format); err != nil {
+		log.Printf("Failed to export image %s: %v", ref, err)
+	}
+}
+
+// ResolveImage 解析镜像引用为digest，部署工具可据此按digest固定镜像版本
+func (h *AdminHandler) ResolveImage(c *gin.Context) {
+	ref := c.Query("ref")
+	if ref == "" {
+		c.JSON(400, gin.H{"error": "ref is required"})
+		return
+	}
+
+	resolved, err := h.imageService.Resolve(ref, c.Query("platform"))
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidReference) || errors.Is(err, service.ErrInvalidPlatform) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrPlatformNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, resolved)
+}
+
+// 离线包
+
+func (h *AdminHandler) ExportBundle(c *gin.Context) {
+	var req struct {
+		Images []string `json:"images" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	bundle, err := h.imageService.PrepareBundle(req.Images)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-tar")
+	c.Header("Content-Disposition", `attachment; filename="bundle.tar"`)
+	c.Status(200)
+
+	if err := h.imageService.WriteBundle(c.Writer, bundle); err != nil {
+		log.Printf("Failed to export bundle: %v", err)
+	}
+}
+
+func (h *AdminHandler) ImportBundle(c *gin.Context) {
+	// 支持直接上传tar包，或以multipart表单的file字段上传
+	var body io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+
+	result, err := h.imageService.ImportBundle(body)
+	if err != nil {
+		if errors.Is(err, service.ErrCacheDisabled) {
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, result)
+}
@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"zmirror/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EvaluatePolicy 模拟一次仓库访问，返回最终结果和各条规则的匹配过程
+func (h *AdminHandler) EvaluatePolicy(c *gin.Context) {
+	var req service.PolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	decision, err := h.policyService.Evaluate(req)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, decision)
+}
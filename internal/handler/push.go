@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"zmirror/internal/model"
+	"zmirror/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isPushRequest 判断是否为推送相关的请求：上传会话和manifest上传
+func isPushRequest(method, kind, reference string) bool {
+	switch method {
+	case "POST", "PATCH", "PUT":
+		return true
+	}
+	return kind == "blobs" && strings.HasPrefix(reference, "uploads/")
+}
+
+// isPushCheck 判断是否为推送前对layer的存在性检查（HEAD）。只有具备推送权限的用户才转发到推送路由，
+// 否则本地缓存或其他镜像源上存在的layer会被跳过上传，推送manifest时目标镜像源找不到这些layer
+func isPushCheck(c *gin.Context, method, kind string) bool {
+	if method != "HEAD" || kind != "blobs" {
+		return false
+	}
+	user, ok := c.Get("user")
+	if !ok {
+		return false
+	}
+	u, ok := user.(*model.User)
+	return ok && (u.IsAdmin || u.CanPush)
+}
+
+// forwardPush 将推送请求连同请求体透传到推送路由的镜像源，上传会话的Location改写为经过本服务的相对路径
+func (h *RegistryHandler) forwardPush(c *gin.Context, route *model.Registry, name, kind, reference, path string) {
+	method := c.Request.Method
+	write := method != "GET" && method != "HEAD"
+
+	var username string
+	if user, ok := c.Get("user"); ok {
+		if u, ok := user.(*model.User); ok {
+			username = u.Username
+			if write && !u.IsAdmin && !u.CanPush {
+				registryError(c, 403, "DENIED", "push permission required")
+				return
+			}
+		}
+	}
+	if write && username == "" {
+		c.Header("WWW-Authenticate", `Basic realm="Docker Registry"`)
+		registryError(c, 401, "UNAUTHORIZED", "authentication required")
+		return
+	}
+
+	var body io.Reader
+	if write && c.Request.Body != nil && c.Request.ContentLength != 0 {
+		body = c.Request.Body
+	}
+	resp, err := h.proxyService.Forward(route, name, method, path, c.Request.Header, body, c.Request.ContentLength)
+	if err != nil {
+		log.Printf("Push to %s failed: %v", route.URL, err)
+		registryError(c, 502, "UNKNOWN", "failed to forward request to upstream")
+		return
+	}
+	defer resp.Body.Close()
+
+	for header, values := range resp.Header {
+		for _, value := range values {
+			if strings.EqualFold(header, "Location") {
+				value = h.proxyService.RelativeLocation(route, value)
+			}
+			c.Header(header, value)
+		}
+	}
+	c.Status(resp.StatusCode)
+	io.Copy(c.Writer, resp.Body)
+
+	// manifest推送成功后移除旧的缓存并记录审计日志
+	if method == "PUT" && kind == "manifests" {
+		if resp.StatusCode == 201 && !service.IsDigest(reference) {
+			h.cacheService.InvalidateManifest(name, reference)
+		}
+		entry := &model.AuditLog{
+			Username:   username,
+			ClientIP:   c.ClientIP(),
+			Action:     "push_manifest",
+			Repository: name,
+			Reference:  reference,
+			Digest:     resp.Header.Get("Docker-Content-Digest"),
+			StatusCode: resp.StatusCode,
+			Message:    route.URL,
+			CreatedAt:  time.Now(),
+		}
+		if err := h.logService.LogAudit(entry); err != nil {
+			log.Printf("Failed to write audit log: %v", err)
+		}
+	}
+}
+
+// 推送路由
+
+func (h *AdminHandler) GetPushRoutes(c *gin.Context) {
+	routes, err := h.pushRouteService.GetAllRoutes()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, routes)
+}
+
+func (h *AdminHandler) CreatePushRoute(c *gin.Context) {
+	var route model.PushRoute
+	if err := c.ShouldBindJSON(&route); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.pushRouteService.CreateRoute(&route); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(201, gin.H{"message": "push route created successfully"})
+}
+
+func (h *AdminHandler) UpdatePushRoute(c *gin.Context) {
+	var route model.PushRoute
+	if err := c.ShouldBindJSON(&route); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.pushRouteService.UpdateRoute(&route); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "push route updated successfully"})
+}
+
+func (h *AdminHandler) DeletePushRoute(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid push route id"})
+		return
+	}
+
+	if err := h.pushRouteService.DeleteRoute(uint(id)); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "push route deleted successfully"})
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+
+	"zmirror/internal/model"
+	"zmirror/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 复制规则
+
+func (h *AdminHandler) GetReplicationRules(c *gin.Context) {
+	rules, err := h.replicationService.GetAllRules()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, rules)
+}
+
+func (h *AdminHandler) CreateReplicationRule(c *gin.Context) {
+	var rule model.ReplicationRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.replicationService.CreateRule(&rule); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(201, gin.H{"message": "replication rule created successfully"})
+}
+
+func (h *AdminHandler) UpdateReplicationRule(c *gin.Context) {
+	var rule model.ReplicationRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.replicationService.UpdateRule(&rule); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "replication rule updated successfully"})
+}
+
+func (h *AdminHandler) DeleteReplicationRule(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid replication rule id"})
+		return
+	}
+
+	if err := h.replicationService.DeleteRule(uint(id)); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "replication rule deleted successfully"})
+}
+
+func (h *AdminHandler) RunReplicationRule(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid replication rule id"})
+		return
+	}
+
+	run, err := h.replicationService.Trigger(uint(id))
+	if err != nil {
+		if errors.Is(err, service.ErrReplicationRunning) {
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(202, run)
+}
+
+func (h *AdminHandler) GetReplicationRuns(c *gin.Context) {
+	ruleID, _ := strconv.ParseUint(c.DefaultQuery("rule_id", "0"), 10, 32)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil {
+		limit = 50
+	}
+
+	runs, err := h.replicationService.GetRuns(uint(ruleID), limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, runs)
+}
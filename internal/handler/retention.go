@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+
+	"zmirror/internal/model"
+	"zmirror/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 保留策略
+
+func (h *AdminHandler) GetRetentionPolicies(c *gin.Context) {
+	policies, err := h.retentionService.GetAllPolicies()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, policies)
+}
+
+func (h *AdminHandler) CreateRetentionPolicy(c *gin.Context) {
+	var policy model.RetentionPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.retentionService.CreatePolicy(&policy); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(201, gin.H{"message": "retention policy created successfully"})
+}
+
+func (h *AdminHandler) UpdateRetentionPolicy(c *gin.Context) {
+	var policy model.RetentionPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.retentionService.UpdatePolicy(&policy); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "retention policy updated successfully"})
+}
+
+func (h *AdminHandler) DeleteRetentionPolicy(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid retention policy id"})
+		return
+	}
+
+	if err := h.retentionService.DeletePolicy(uint(id)); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "retention policy deleted successfully"})
+}
+
+func (h *AdminHandler) RunRetention(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+
+	report, err := h.retentionService.Apply(dryRun)
+	if err != nil {
+		if errors.Is(err, service.ErrCacheDisabled) {
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, report)
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"zmirror/internal/model"
+	"zmirror/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 管理界面登录会话
+
+type loginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// Login 用用户名和密码登录管理界面，会话token写入HttpOnly cookie，同时在响应中返回，便于脚本作为Bearer token使用
+func (h *AdminHandler) Login(c *gin.Context) {
+	var req loginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, issued, err := h.sessionService.Login(req.Username, req.Password, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			c.JSON(401, gin.H{"error": "invalid username or password"})
+			return
+		}
+		if errors.Is(err, service.ErrAdminRequired) {
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	respondSession(c, user, issued)
+}
+
+// RefreshSession 换取新的会话token，原token过期后仍可刷新，直到会话空闲超时或达到绝对有效期
+func (h *AdminHandler) RefreshSession(c *gin.Context) {
+	token, fromCookie := sessionToken(c)
+	if token == "" {
+		c.JSON(401, gin.H{"error": "authorization required"})
+		return
+	}
+
+	user, issued, err := h.sessionService.Refresh(token)
+	if err != nil {
+		clearSessionCookies(c)
+		c.JSON(401, gin.H{"error": err.Error()})
+		return
+	}
+	if fromCookie && !h.sessionService.CheckCSRF(issued.Session, c.GetHeader(service.CSRFHeader)) {
+		c.JSON(403, gin.H{"error": "invalid csrf token"})
+		return
+	}
+	if user.Role == "" {
+		h.sessionService.Revoke(issued.Session.ID)
+		clearSessionCookies(c)
+		c.JSON(401, gin.H{"error": "admin access required"})
+		return
+	}
+
+	respondSession(c, user, issued)
+}
+
+// Logout 退出登录，吊销当前会话
+func (h *AdminHandler) Logout(c *gin.Context) {
+	if session, ok := c.Get("session"); ok {
+		if err := h.sessionService.Revoke(session.(*model.AdminSession).ID); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	clearSessionCookies(c)
+	c.JSON(200, gin.H{"message": "logged out successfully"})
+}
+
+// GetSessions 获取所有有效的登录会话
+func (h *AdminHandler) GetSessions(c *gin.Context) {
+	sessions, err := h.sessionService.GetActiveSessions()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, sessions)
+}
+
+// DeleteSession 吊销指定的登录会话，对应的用户需要重新登录
+func (h *AdminHandler) DeleteSession(c *gin.Context) {
+	if err := h.sessionService.Revoke(c.Param("id")); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "session revoked successfully"})
+}
+
+// respondSession 写入会话cookie并返回会话token和当前用户
+func respondSession(c *gin.Context, user *model.User, issued *service.IssuedSession) {
+	maxAge := int(time.Until(issued.Session.ExpiresAt).Seconds())
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(service.SessionCookie, issued.Token, maxAge, "/api", "", secure, true)
+	// CSRF token需要前端脚本读取，不能设置HttpOnly
+	c.SetCookie(service.CSRFCookie, issued.CSRFToken, maxAge, "/", "", secure, false)
+
+	c.JSON(200, gin.H{
+		"token":      issued.Token,
+		"expires_in": issued.ExpiresIn,
+		"csrf_token": issued.CSRFToken,
+		"id":         user.ID,
+		"username":   user.Username,
+		"role":       user.Role,
+	})
+}
+
+// clearSessionCookies 删除浏览器中的会话cookie
+func clearSessionCookies(c *gin.Context) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(service.SessionCookie, "", -1, "/api", "", secure, true)
+	c.SetCookie(service.CSRFCookie, "", -1, "/", "", secure, false)
+}
+
+// sessionToken 从Bearer认证头或cookie中读取会话token
+func sessionToken(c *gin.Context) (token string, fromCookie bool) {
+	if authHeader := c.GetHeader("Authorization"); len(authHeader) > 7 && strings.EqualFold(authHeader[:7], "Bearer ") {
+		return strings.TrimSpace(authHeader[7:]), false
+	}
+	token, _ = c.Cookie(service.SessionCookie)
+	return token, true
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"strconv"
+
+	"zmirror/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tag订阅
+
+func (h *AdminHandler) GetTagWatches(c *gin.Context) {
+	watches, err := h.watchService.GetAllWatches()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, watches)
+}
+
+func (h *AdminHandler) CreateTagWatch(c *gin.Context) {
+	var watch model.TagWatch
+	if err := c.ShouldBindJSON(&watch); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.watchService.CreateWatch(&watch); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(201, gin.H{"message": "tag watch created successfully"})
+}
+
+func (h *AdminHandler) UpdateTagWatch(c *gin.Context) {
+	var watch model.TagWatch
+	if err := c.ShouldBindJSON(&watch); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.watchService.UpdateWatch(&watch); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "tag watch updated successfully"})
+}
+
+func (h *AdminHandler) DeleteTagWatch(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid tag watch id"})
+		return
+	}
+
+	if err := h.watchService.DeleteWatch(uint(id)); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "tag watch deleted successfully"})
+}
+
+// CheckTagWatch 立即检查一个订阅，返回检测到的变化；部分tag检查失败时同时返回错误信息
+func (h *AdminHandler) CheckTagWatch(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid tag watch id"})
+		return
+	}
+
+	// 未能开始检查时changes为nil
+	changes, err := h.watchService.Check(uint(id))
+	if changes == nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	result := gin.H{"changes": changes}
+	if err != nil {
+		result["error"] = err.Error()
+	}
+	c.JSON(200, result)
+}
+
+func (h *AdminHandler) GetTagHistory(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil {
+		limit = 100
+	}
+
+	changes, err := h.watchService.GetHistory(c.Query("repository"), limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, changes)
+}
@@ -3,6 +3,8 @@ package middleware
 import (
 	"encoding/base64"
 	"fmt"
+	"log"
+	"slices"
 	"strings"
 	"time"
 
@@ -12,12 +14,10 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware Docker认证中间件
-func AuthMiddleware(userService *service.UserService, whitelistService *service.WhitelistService, logService *service.LogService) gin.HandlerFunc {
+// AuthMiddleware Docker认证中间件。启用token认证时向客户端返回Bearer挑战，
+// 之后的请求只校验token中的访问范围；Basic认证仍然可用，便于脚本直接调用
+func AuthMiddleware(userService *service.UserService, whitelistService *service.WhitelistService, logService *service.LogService, tokenService *service.TokenService, accessTokenService *service.AccessTokenService, aclService *service.ACLService, groupService *service.GroupService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		fmt.Printf("DEBUG: Processing request %s %s\n", c.Request.Method, c.Request.URL.Path)
-		fmt.Printf("DEBUG: Authorization header: %s\n", c.Request.Header.Get("Authorization"))
-
 		// 记录访问日志
 		accessLog := &model.AccessLog{
 			ClientIP:  c.ClientIP(),
@@ -30,95 +30,286 @@ func AuthMiddleware(userService *service.UserService, whitelistService *service.
 		// 从路径中提取镜像名
 		imageName := extractImageNameFromPath(c.Request.URL.Path)
 		accessLog.ImageName = imageName
-		fmt.Printf("DEBUG: Extracted image name: %s\n", imageName)
 
-		// 检查是否在白名单中
+		// 按访问策略规则检查：禁止访问的镜像直接拒绝拉取和推送（仍允许删除以便清理），
+		// 允许匿名的镜像可以不认证拉取，删除等写操作仍需认证。仓库组的请求按去掉组名后的镜像名检查
+		readOnly := c.Request.Method == "GET" || c.Request.Method == "HEAD"
 		if imageName != "" {
-			isWhitelisted, err := whitelistService.IsImageWhitelisted(imageName)
-			fmt.Printf("DEBUG: Whitelist check - image: %s, whitelisted: %v, error: %v\n", imageName, isWhitelisted, err)
-			if err == nil && isWhitelisted {
-				fmt.Printf("DEBUG: Image is whitelisted, allowing access\n")
-				accessLog.StatusCode = 200
-				logService.LogAccess(accessLog)
-				c.Next()
-				return
+			rule, err := whitelistService.Evaluate(groupService.ResolveName(imageName), extractReferenceFromPath(c.Request.URL.Path))
+			if err != nil {
+				log.Printf("Failed to evaluate access policy for %s: %v", imageName, err)
+			} else {
+				switch service.WhitelistEffect(rule) {
+				case service.WhitelistDeny:
+					if c.Request.Method != "DELETE" {
+						accessLog.StatusCode = 403
+						logService.LogAccess(accessLog)
+						c.Header("Docker-Distribution-API-Version", "registry/2.0")
+						c.JSON(403, gin.H{"errors": []gin.H{{"code": "DENIED", "message": "image is forbidden by access policy"}}})
+						c.Abort()
+						return
+					}
+				case service.WhitelistAnonymous:
+					if readOnly {
+						accessLog.StatusCode = 200
+						logService.LogAccess(accessLog)
+						c.Next()
+						return
+					}
+				}
 			}
 		}
 
-		// 需要认证
-		authHeader := c.Request.Header.Get("Authorization")
-		fmt.Printf("DEBUG: Auth header: %s\n", authHeader)
-		if authHeader == "" {
-			fmt.Printf("DEBUG: No auth header, returning 401 with Basic realm\n")
-			// 使用标准的Basic认证方式，更兼容Docker客户端
-			c.Header("WWW-Authenticate", `Basic realm="Docker Registry"`)
-			c.Header("Docker-Distribution-API-Version", "registry/2.0")
+		resourceType, resourceName, actions := requiredAccess(c.Request.Method, c.Request.URL.Path, imageName)
+		unauthorized := func(errorCode, message string) {
 			accessLog.StatusCode = 401
 			logService.LogAccess(accessLog)
-			c.JSON(401, gin.H{"errors": []gin.H{{"code": "UNAUTHORIZED", "message": "authentication required"}}})
+			setAuthChallenge(c, tokenService, resourceType, resourceName, actions, errorCode)
+			c.Header("Docker-Distribution-API-Version", "registry/2.0")
+			c.JSON(401, gin.H{"errors": []gin.H{{"code": "UNAUTHORIZED", "message": message}}})
 			c.Abort()
-			return
 		}
 
-		// 解析认证信息 (只支持Basic认证)
-		username, password, ok := parseBasicAuth(authHeader)
-		fmt.Printf("DEBUG: Parsed auth - username: %s, password: %s, ok: %v\n", username, password, ok)
-		if !ok {
-			fmt.Printf("DEBUG: Failed to parse Basic auth\n")
-			accessLog.StatusCode = 401
-			logService.LogAccess(accessLog)
-			c.Header("WWW-Authenticate", `Basic realm="Docker Registry"`)
-			c.Header("Docker-Distribution-API-Version", "registry/2.0")
-			c.JSON(401, gin.H{"errors": []gin.H{{"code": "UNAUTHORIZED", "message": "invalid authorization header"}}})
-			c.Abort()
+		// 需要认证
+		authHeader := c.Request.Header.Get("Authorization")
+		if authHeader == "" {
+			unauthorized("", "authentication required")
 			return
 		}
 
-		// 验证用户
-		user, err := userService.AuthenticateUser(username, password)
-		fmt.Printf("DEBUG: User authentication - user: %v, error: %v\n", user, err)
-		if err != nil {
-			fmt.Printf("DEBUG: User authentication failed: %v\n", err)
-			accessLog.StatusCode = 401
-			logService.LogAccess(accessLog)
-			// 确保Docker客户端重新发送认证信息
-			c.Header("WWW-Authenticate", `Basic realm="Docker Registry"`)
-			c.Header("Docker-Distribution-API-Version", "registry/2.0")
-			c.JSON(401, gin.H{"errors": []gin.H{{"code": "UNAUTHORIZED", "message": "invalid credentials"}}})
-			c.Abort()
-			return
+		var user *model.User
+		bearer := false
+		if token, ok := parseBearerAuth(authHeader); ok && tokenService.Enabled() {
+			bearer = true
+			claims, err := tokenService.Verify(token)
+			if err != nil {
+				unauthorized("invalid_token", "invalid token")
+				return
+			}
+			// token需要覆盖本次请求的所有操作，/v2/ 只要求token有效
+			for _, action := range actions {
+				if !claims.Allows(resourceType, resourceName, action) {
+					unauthorized("insufficient_scope", "insufficient scope")
+					return
+				}
+			}
+			// 匿名token没有对应用户，只能用于白名单镜像
+			if strings.HasPrefix(claims.Subject, service.RobotUserPrefix) {
+				// 机器人账号的权限完全由token中的访问范围决定
+				if _, err := accessTokenService.GetRobot(strings.TrimPrefix(claims.Subject, service.RobotUserPrefix)); err != nil {
+					unauthorized("invalid_token", "robot account no longer exists")
+					return
+				}
+				user = &model.User{
+					Username:  claims.Subject,
+					CanPush:   claims.Allows(resourceType, resourceName, "push"),
+					CanDelete: claims.Allows(resourceType, resourceName, "delete"),
+				}
+			} else if claims.Subject != "" {
+				if user, err = userService.GetUser(claims.Subject); err != nil {
+					unauthorized("invalid_token", "user no longer exists")
+					return
+				}
+			}
+		} else {
+			// 解析Basic认证信息
+			username, password, ok := parseBasicAuth(authHeader)
+			if !ok {
+				unauthorized("", "invalid authorization header")
+				return
+			}
+
+			// 验证用户，密码也可以是访问令牌
+			authenticated, accessToken, err := service.AuthenticateCredential(userService, accessTokenService, username, password, c.ClientIP())
+			if err != nil {
+				unauthorized("", "invalid credentials")
+				return
+			}
+			// 访问令牌只能用于其允许的仓库和操作，仓库组按去掉组名后的仓库检查
+			if accessToken != nil && !accessTokenPermits(accessToken, resourceType, groupService.ResolveName(resourceName), actions) {
+				accessLog.Username = authenticated.Username
+				accessLog.StatusCode = 403
+				logService.LogAccess(accessLog)
+				c.Header("Docker-Distribution-API-Version", "registry/2.0")
+				c.JSON(403, gin.H{"errors": []gin.H{{"code": "DENIED", "message": "access token does not permit this operation"}}})
+				c.Abort()
+				return
+			}
+			user = authenticated
+		}
+
+		if user != nil {
+			accessLog.Username = user.Username
+		}
+
+		// Basic认证通过后按访问规则检查用户能否对仓库执行操作，仓库组的请求按去掉组名后的仓库检查；
+		// Bearer token的访问范围在签发时已经按访问规则过滤，不再重复检查
+		if user != nil && !bearer && resourceType == "repository" {
+			repository := groupService.ResolveName(resourceName)
+			for _, action := range actions {
+				decision, err := aclService.Check(user, repository, action)
+				if err != nil || !decision.Allowed {
+					accessLog.StatusCode = 403
+					logService.LogAccess(accessLog)
+					c.Header("Docker-Distribution-API-Version", "registry/2.0")
+					c.JSON(403, gin.H{"errors": []gin.H{{"code": "DENIED", "message": "access denied by repository access rules"}}})
+					c.Abort()
+					return
+				}
+			}
 		}
 
-		accessLog.Username = user.Username
 		accessLog.StatusCode = 200
 		logService.LogAccess(accessLog)
 
 		// 将用户信息存储到上下文中
-		c.Set("user", user)
+		if user != nil {
+			c.Set("user", user)
+		}
 		c.Next()
 	}
 }
 
-// AdminAuthMiddleware WEB管理界面认证中间件
-func AdminAuthMiddleware(userService *service.UserService) gin.HandlerFunc {
+// requiredAccess 返回请求需要的资源和操作：读取需要pull，上传需要push，删除需要delete，
+// 目录列表需要registry:catalog:*。/v2/ 版本检查不需要任何操作
+func requiredAccess(method, path, imageName string) (resourceType, name string, actions []string) {
+	if path == "/v2/_catalog" {
+		return "registry", "catalog", []string{"*"}
+	}
+	if imageName == "" {
+		return "", "", nil
+	}
+
+	switch method {
+	case "GET", "HEAD":
+		return "repository", imageName, []string{"pull"}
+	case "DELETE":
+		return "repository", imageName, []string{"delete"}
+	default:
+		return "repository", imageName, []string{"pull", "push"}
+	}
+}
+
+// accessTokenPermits 判断访问令牌是否允许请求需要的所有操作，目录列表需要admin-api
+func accessTokenPermits(token *model.AccessToken, resourceType, name string, actions []string) bool {
+	if resourceType == "registry" {
+		return slices.Contains(token.Actions, "admin-api")
+	}
+	for _, action := range actions {
+		if !service.AccessTokenAllows(token, name, action) {
+			return false
+		}
+	}
+	return true
+}
+
+// setAuthChallenge 设置WWW-Authenticate挑战头。启用token认证时返回Bearer挑战，
+// 告诉客户端到哪里获取token以及需要的scope
+func setAuthChallenge(c *gin.Context, tokenService *service.TokenService, resourceType, name string, actions []string, errorCode string) {
+	if !tokenService.Enabled() {
+		// 使用标准的Basic认证方式，更兼容Docker客户端
+		c.Header("WWW-Authenticate", `Basic realm="Docker Registry"`)
+		return
+	}
+
+	realm := tokenService.Realm()
+	if realm == "" {
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+		if proto := c.Request.Header.Get("X-Forwarded-Proto"); proto != "" {
+			scheme = proto
+		}
+		host := c.Request.Host
+		if forwarded := c.Request.Header.Get("X-Forwarded-Host"); forwarded != "" {
+			host = forwarded
+		}
+		realm = scheme + "://" + host + "/token"
+	}
+
+	challenge := fmt.Sprintf(`Bearer realm=%q,service=%q`, realm, tokenService.Service())
+	if len(actions) > 0 {
+		challenge += fmt.Sprintf(`,scope="%s:%s:%s"`, resourceType, name, strings.Join(actions, ","))
+	}
+	if errorCode != "" {
+		challenge += fmt.Sprintf(`,error=%q`, errorCode)
+	}
+	c.Header("WWW-Authenticate", challenge)
+}
+
+// AdminAuthMiddleware WEB管理界面认证中间件。管理界面使用登录会话（HttpOnly cookie），
+// 脚本可以使用Basic认证、会话token或带admin-api操作的访问令牌作为Bearer token。
+// 只有具有管理员角色的用户可以访问，并按 requiredAdminRole 检查角色是否满足当前路由
+func AdminAuthMiddleware(userService *service.UserService, accessTokenService *service.AccessTokenService, sessionService *service.SessionService) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		var user *model.User
 		authHeader := c.Request.Header.Get("Authorization")
 		if authHeader == "" {
-			c.JSON(401, gin.H{"error": "authorization required"})
-			c.Abort()
-			return
+			token, err := c.Cookie(service.SessionCookie)
+			if err != nil || token == "" {
+				c.JSON(401, gin.H{"error": "authorization required"})
+				c.Abort()
+				return
+			}
+			authenticated, session, err := sessionService.Authenticate(token)
+			if err != nil {
+				c.JSON(401, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+			// cookie由浏览器自动携带，修改类请求必须同时提交CSRF token
+			readOnly := c.Request.Method == "GET" || c.Request.Method == "HEAD"
+			if !readOnly && !sessionService.CheckCSRF(session, c.GetHeader(service.CSRFHeader)) {
+				c.JSON(403, gin.H{"error": "invalid csrf token"})
+				c.Abort()
+				return
+			}
+			c.Set("session", session)
+			user = authenticated
+		} else if token, ok := parseBearerAuth(authHeader); ok && strings.HasPrefix(token, service.AccessTokenPrefix) {
+			// 令牌代表的用户只有在令牌包含admin-api时才保留管理员角色
+			authenticated, _, err := accessTokenService.Authenticate("", token, c.ClientIP())
+			if err != nil {
+				c.JSON(401, gin.H{"error": "admin access required"})
+				c.Abort()
+				return
+			}
+			user = authenticated
+		} else if ok {
+			// 其他Bearer token按会话token处理，不需要CSRF token
+			authenticated, session, err := sessionService.Authenticate(token)
+			if err != nil {
+				c.JSON(401, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+			c.Set("session", session)
+			user = authenticated
+		} else {
+			username, password, ok := parseBasicAuth(authHeader)
+			if !ok {
+				c.JSON(401, gin.H{"error": "invalid authorization header"})
+				c.Abort()
+				return
+			}
+
+			authenticated, err := userService.AuthenticateUser(username, password)
+			if err != nil {
+				c.JSON(401, gin.H{"error": "admin access required"})
+				c.Abort()
+				return
+			}
+			user = authenticated
 		}
 
-		username, password, ok := parseBasicAuth(authHeader)
-		if !ok {
-			c.JSON(401, gin.H{"error": "invalid authorization header"})
+		if user.Role == "" {
+			c.JSON(401, gin.H{"error": "admin access required"})
 			c.Abort()
 			return
 		}
-
-		user, err := userService.AuthenticateUser(username, password)
-		if err != nil || !user.IsAdmin {
-			c.JSON(401, gin.H{"error": "admin access required"})
+		if required := requiredAdminRole(c.Request.Method, c.FullPath()); !service.RoleAtLeast(user.Role, required) {
+			c.JSON(403, gin.H{"error": fmt.Sprintf("%s role required", required)})
 			c.Abort()
 			return
 		}
@@ -128,6 +319,55 @@ func AdminAuthMiddleware(userService *service.UserService) gin.HandlerFunc {
 	}
 }
 
+// superAdminRoutes 只有超级管理员可以修改的管理API：账号、令牌和访问控制相关的配置，以及访问日志
+var superAdminRoutes = []string{
+	"/api/users",
+	"/api/robots",
+	"/api/access-tokens",
+	"/api/sessions",
+	"/api/user-groups",
+	"/api/acl-rules",
+	"/api/whitelists",
+	"/api/logs",
+}
+
+// superAdminReadRoutes 读取也需要超级管理员的管理API：所有管理员的登录会话、所有访问令牌，以及导出镜像内容
+var superAdminReadRoutes = []string{
+	"/api/sessions",
+	"/api/access-tokens",
+	"/api/images/export",
+	"/api/bundles/export",
+}
+
+// requiredAdminRole 返回访问管理API路由需要的最低角色：读取一般只需要审计员，
+// 账号和访问控制相关的修改以及敏感的读取需要超级管理员，其他修改需要运维人员
+func requiredAdminRole(method, route string) string {
+	if matchRoutes(superAdminReadRoutes, route) {
+		return service.RoleSuperAdmin
+	}
+	if method == "GET" || method == "HEAD" {
+		return service.RoleAuditor
+	}
+	// 访问模拟不修改任何数据，退出登录只影响自己的会话
+	if route == "/api/policy/evaluate" || route == "/api/auth/logout" {
+		return service.RoleAuditor
+	}
+	if matchRoutes(superAdminRoutes, route) {
+		return service.RoleSuperAdmin
+	}
+	return service.RoleOperator
+}
+
+// matchRoutes 判断路由是否为给定路径或其子路径
+func matchRoutes(prefixes []string, route string) bool {
+	for _, prefix := range prefixes {
+		if route == prefix || strings.HasPrefix(route, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 // CacheHeadersMiddleware 设置CDN缓存头
 func CacheHeadersMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -189,6 +429,16 @@ func parseBasicAuth(authHeader string) (username, password string, ok bool) {
 	return parts[0], parts[1], true
 }
 
+// parseBearerAuth 解析Bearer认证头
+func parseBearerAuth(authHeader string) (token string, ok bool) {
+	const prefix = "Bearer "
+	if len(authHeader) < len(prefix) || !strings.EqualFold(authHeader[:len(prefix)], prefix) {
+		return "", false
+	}
+	token = strings.TrimSpace(authHeader[len(prefix):])
+	return token, token != ""
+}
+
 // extractImageNameFromPath 从请求路径中提取镜像名
 func extractImageNameFromPath(path string) string {
 	// Docker Registry API路径格式：
@@ -215,6 +465,15 @@ func extractImageNameFromPath(path string) string {
 	return strings.Join(nameParts, "/")
 }
 
+// extractReferenceFromPath 从manifest请求路径中提取标签或digest，其他请求返回空
+func extractReferenceFromPath(path string) string {
+	idx := strings.LastIndex(path, "/manifests/")
+	if !strings.HasPrefix(path, "/v2/") || idx < 0 {
+		return ""
+	}
+	return path[idx+len("/manifests/"):]
+}
+
 // shouldCache 判断路径是否应该被缓存
 func shouldCache(path string) bool {
 	return isManifest(path) || isBlob(path)
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"testing"
+
+	"zmirror/internal/service"
+)
+
+func TestRequiredAdminRole(t *testing.T) {
+	tests := []struct {
+		method string
+		route  string
+		want   string
+	}{
+		// 一般的读取只需要审计员
+		{"GET", "/api/registries", service.RoleAuditor},
+		{"GET", "/api/users", service.RoleAuditor},
+		{"GET", "/api/robots", service.RoleAuditor},
+		{"GET", "/api/cache/repositories", service.RoleAuditor},
+		{"GET", "/api/resolve", service.RoleAuditor},
+		{"HEAD", "/api/logs", service.RoleAuditor},
+		{"POST", "/api/policy/evaluate", service.RoleAuditor},
+		{"POST", "/api/auth/logout", service.RoleAuditor},
+
+		// 会话、访问令牌和镜像导出连读取也需要超级管理员
+		{"GET", "/api/sessions", service.RoleSuperAdmin},
+		{"DELETE", "/api/sessions/:id", service.RoleSuperAdmin},
+		{"GET", "/api/access-tokens", service.RoleSuperAdmin},
+		{"HEAD", "/api/access-tokens", service.RoleSuperAdmin},
+		{"GET", "/api/images/export", service.RoleSuperAdmin},
+		{"POST", "/api/bundles/export", service.RoleSuperAdmin},
+
+		// 账号和访问控制的修改需要超级管理员
+		{"POST", "/api/users", service.RoleSuperAdmin},
+		{"PUT", "/api/users/:id/role", service.RoleSuperAdmin},
+		{"POST", "/api/robots", service.RoleSuperAdmin},
+		{"POST", "/api/access-tokens", service.RoleSuperAdmin},
+		{"PUT", "/api/acl-rules", service.RoleSuperAdmin},
+		{"DELETE", "/api/whitelists/:id", service.RoleSuperAdmin},
+		{"DELETE", "/api/logs", service.RoleSuperAdmin},
+
+		// 其他修改需要运维人员
+		{"POST", "/api/registries", service.RoleOperator},
+		{"PUT", "/api/offline", service.RoleOperator},
+		{"POST", "/api/cache/gc", service.RoleOperator},
+		{"POST", "/api/bundles/import", service.RoleOperator},
+		{"DELETE", "/api/push-routes/:id", service.RoleOperator},
+
+		// 只按完整的路径段匹配前缀
+		{"POST", "/api/users-export", service.RoleOperator},
+		{"GET", "/api/sessions-summary", service.RoleAuditor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.route, func(t *testing.T) {
+			if got := requiredAdminRole(tt.method, tt.route); got != tt.want {
+				t.Errorf("requiredAdminRole(%q, %q) = %q, want %q", tt.method, tt.route, got, tt.want)
+			}
+		})
+	}
+}
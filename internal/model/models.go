@@ -11,28 +11,113 @@ type User struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	Username  string    `gorm:"uniqueIndex;not null" json:"username"`
 	Password  string    `gorm:"not null" json:"password"`
-	IsAdmin   bool      `gorm:"default:false" json:"is_admin"` // 注意：管理员用户存储在配置文件中，数据库中只存储普通用户
+	Role      string    `gorm:"default:''" json:"role"`          // 管理员角色：superadmin, operator, auditor，为空表示普通用户
+	IsAdmin   bool      `gorm:"default:false" json:"is_admin"`   // 镜像仓库管理员权限，由角色决定：superadmin和operator为true
+	CanDelete bool      `gorm:"default:false" json:"can_delete"` // 允许通过Registry API删除本地托管的manifest和tag
+	CanPush   bool      `gorm:"default:false" json:"can_push"`   // 允许通过推送路由向上游推送镜像
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// RobotAccount 机器人账号：不对应具体的人，只能通过访问令牌认证，用于CI等自动化场景
+type RobotAccount struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"uniqueIndex;not null" json:"name"` // 认证时的用户名为 robot$<name>
+	Description string    `json:"description"`
+	Role        string    `gorm:"not null;default:auditor" json:"role"` // 带admin-api的令牌访问管理API时使用的角色，默认为只读的auditor
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// AccessToken 访问令牌，绑定到普通用户或机器人账号，可以作为docker login的密码或 /api 的Bearer token
+type AccessToken struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	Name         string     `gorm:"not null" json:"name"`
+	TokenHash    string     `gorm:"uniqueIndex;not null" json:"-"`       // 令牌的SHA-256，明文只在创建时返回一次
+	Prefix       string     `json:"prefix"`                              // 令牌开头的几位，便于辨认
+	UserID       uint       `gorm:"index" json:"user_id"`                // 绑定的普通用户，0表示绑定机器人账号
+	RobotID      uint       `gorm:"index" json:"robot_id"`               // 绑定的机器人账号
+	Repositories []string   `gorm:"serializer:json" json:"repositories"` // 允许访问的仓库前缀或glob，为空表示全部仓库
+	Actions      []string   `gorm:"serializer:json" json:"actions"`      // 允许的操作：pull、push、delete、admin-api
+	ExpiresAt    *time.Time `json:"expires_at"`                          // 为空表示永不过期
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	LastUsedIP   string     `json:"last_used_ip"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// AdminSession 管理界面的登录会话，会话token中只保存会话ID，每次请求都按这里的记录校验是否已吊销或超时
+type AdminSession struct {
+	ID         string    `gorm:"primaryKey;size:32" json:"id"`
+	UserID     uint      `gorm:"index;not null" json:"user_id"`
+	Username   string    `json:"username"`
+	ClientIP   string    `json:"client_ip"`  // 登录时的客户端地址
+	UserAgent  string    `json:"user_agent"` // 登录时的User-Agent
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `gorm:"index" json:"expires_at"` // 绝对过期时间，刷新token不会延长
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// UserGroup 用户组，用于按组配置仓库访问规则
+type UserGroup struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"uniqueIndex;not null" json:"name"`
+	Description string    `json:"description"`
+	UserIDs     []uint    `gorm:"serializer:json" json:"user_ids"` // 组成员
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ACLRule 仓库访问规则，对已认证用户按优先级匹配，第一条匹配的规则决定允许还是拒绝，
+// 没有规则匹配时保持原有行为
+type ACLRule struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	SubjectType string    `gorm:"not null" json:"subject_type"`   // user、group 或 all（所有已认证用户）
+	Subject     string    `json:"subject"`                        // 用户名（机器人账号为 robot$<名称>）或用户组名
+	Repository  string    `json:"repository"`                     // 仓库前缀或glob，为空表示全部仓库
+	Actions     []string  `gorm:"serializer:json" json:"actions"` // pull、push、delete
+	Effect      string    `gorm:"not null" json:"effect"`         // allow 或 deny
+	Priority    int       `gorm:"default:0" json:"priority"`      // 越小优先级越高
+	Enabled     bool      `gorm:"default:true" json:"enabled"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
 // Registry 镜像源模型
 type Registry struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	URL       string    `gorm:"not null" json:"url"`
-	Priority  int       `gorm:"default:0" json:"priority"` // 越小优先级越高
-	Enabled   bool      `gorm:"default:true" json:"enabled"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	URL        string    `gorm:"not null" json:"url"`
+	Priority   int       `gorm:"default:0" json:"priority"` // 越小优先级越高
+	Enabled    bool      `gorm:"default:true" json:"enabled"`
+	CacheQuota int64     `gorm:"default:0" json:"cache_quota"` // 该镜像源的缓存大小上限（MB），0表示不限制
+	Username   string    `json:"username"`                     // 推送时使用的凭证
+	Password   string    `json:"password,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
 }
 
-// Whitelist 白名单模型
+// PushRoute 推送路由，将匹配的仓库的推送请求透传到指定镜像源
+type PushRoute struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Pattern    string    `gorm:"uniqueIndex;not null" json:"pattern"` // 仓库前缀或glob，如 ourteam/、ourteam/*
+	RegistryID uint      `gorm:"not null" json:"registry_id"`
+	Enabled    bool      `gorm:"default:true" json:"enabled"`
+	ProxyPulls bool      `gorm:"default:false" json:"proxy_pulls"` // 拉取也发往该镜像源并使用推送凭证，默认拉取走普通的缓存和代理
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Whitelist 访问策略规则（白名单），按优先级匹配镜像名和标签，决定匿名可拉取、需要认证或禁止访问
 type Whitelist struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Prefix    string    `gorm:"uniqueIndex;not null" json:"prefix"` // 镜像名前缀
-	Enabled   bool      `gorm:"default:true" json:"enabled"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Prefix      string    `gorm:"not null" json:"prefix"`           // 镜像名规则，按match_type解释
+	MatchType   string    `gorm:"default:prefix" json:"match_type"` // prefix, glob, regex
+	Reference   string    `json:"reference"`                        // 标签或digest的glob，如 latest、sha256:*，为空表示任意
+	Effect      string    `gorm:"default:anonymous" json:"effect"`  // anonymous（允许匿名拉取）, auth（需要认证）, deny（禁止访问）
+	Priority    int       `gorm:"default:0" json:"priority"`        // 数字越小越先匹配
+	Description string    `json:"description"`
+	Enabled     bool      `gorm:"default:true" json:"enabled"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
 }
 
 // AccessLog 访问日志模型
@@ -48,14 +133,165 @@ type AccessLog struct {
 	CreatedAt  time.Time `json:"created_at"`
 }
 
+// AuditLog 审计日志，记录对本地托管内容的删除等写操作
+type AuditLog struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Username   string    `gorm:"index" json:"username"`
+	ClientIP   string    `json:"client_ip"`
+	Action     string    `json:"action"` // delete_manifest、delete_tag
+	Repository string    `gorm:"index" json:"repository"`
+	Reference  string    `json:"reference"`
+	Digest     string    `json:"digest,omitempty"`
+	StatusCode int       `json:"status_code"`
+	Message    string    `json:"message,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CachedBlob 已缓存的blob（镜像层、配置或manifest内容），按digest寻址
+type CachedBlob struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	Digest       string    `gorm:"uniqueIndex;not null" json:"digest"`
+	Size         int64     `json:"size"`
+	MediaType    string    `json:"media_type"`
+	Upstream     string    `gorm:"index" json:"upstream"` // 来源镜像源URL
+	LastAccessAt time.Time `gorm:"index" json:"last_access_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CachedBlobLink 仓库与已缓存blob的关联。blob按digest全局存储，只有关联的仓库才能从缓存读取
+type CachedBlobLink struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Digest     string    `gorm:"uniqueIndex:idx_blob_link;not null" json:"digest"`
+	Repository string    `gorm:"uniqueIndex:idx_blob_link;not null" json:"repository"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CachedManifest 仓库引用（tag或digest）到manifest digest的映射
+type CachedManifest struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	Repository   string    `gorm:"uniqueIndex:idx_manifest_ref;not null" json:"repository"`
+	Reference    string    `gorm:"uniqueIndex:idx_manifest_ref;not null" json:"reference"`
+	Digest       string    `gorm:"index;not null" json:"digest"`
+	MediaType    string    `json:"media_type"`
+	Upstream     string    `json:"upstream"`
+	Hosted       bool      `gorm:"default:false" json:"hosted"` // 本地托管的manifest不会被淘汰
+	LastAccessAt time.Time `json:"last_access_at"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// CachePolicy 缓存保留规则，按仓库前缀或glob匹配
+type CachePolicy struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Pattern     string    `gorm:"uniqueIndex;not null" json:"pattern"` // 仓库前缀或glob，如 library/nginx、nightly/*
+	Pinned      bool      `gorm:"default:false" json:"pinned"`         // 固定缓存，永不淘汰
+	MaxAge      int       `gorm:"default:0" json:"max_age"`            // 最长保留时间（小时），0表示不限制
+	MaxVersions int       `gorm:"default:0" json:"max_versions"`       // 每个仓库最多保留的tag数量，0表示不限制
+	Priority    int       `gorm:"default:0" json:"priority"`           // 越小优先级越高
+	Enabled     bool      `gorm:"default:true" json:"enabled"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// RetentionPolicy 托管仓库的tag保留策略
+type RetentionPolicy struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	Pattern      string    `gorm:"uniqueIndex;not null" json:"pattern"` // 仓库前缀或glob，如 ourteam/app、ci/*
+	KeepLast     int       `gorm:"default:0" json:"keep_last"`          // 按写入时间保留最近的N个tag，0表示不按数量删除
+	KeepPattern  string    `json:"keep_pattern"`                        // 匹配该正则的tag始终保留，如 ^v\d+\.\d+\.\d+$
+	UntaggedDays int       `gorm:"default:0" json:"untagged_days"`      // 删除超过N天未被tag引用的manifest，0表示不删除
+	Priority     int       `gorm:"default:0" json:"priority"`           // 越小优先级越高
+	Enabled      bool      `gorm:"default:true" json:"enabled"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// RepositoryGroup 仓库组：以一个虚拟命名空间对外提供服务，先查找本地托管的内容，再按顺序查找代理的镜像源
+type RepositoryGroup struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"uniqueIndex;not null" json:"name"`    // 虚拟命名空间，如 mirror，对应 /v2/mirror/...
+	RegistryIDs []uint    `gorm:"serializer:json" json:"registry_ids"` // 按顺序查找的镜像源，为空表示所有启用的镜像源按优先级查找
+	Enabled     bool      `gorm:"default:true" json:"enabled"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ReplicationRule 复制规则：将本地托管的仓库复制到远程镜像仓库
+type ReplicationRule struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	Name            string     `gorm:"uniqueIndex;not null" json:"name"`
+	Filter          string     `json:"filter"`                     // 源仓库前缀或glob，为空表示全部托管仓库
+	TargetURL       string     `gorm:"not null" json:"target_url"` // 目标镜像仓库地址，如 https://registry.example.com
+	TargetNamespace string     `json:"target_namespace"`           // 目标仓库名前缀，为空时使用源仓库名
+	Username        string     `json:"username"`                   // 目标镜像仓库的用户名
+	Password        string     `json:"password,omitempty"`         // 目标镜像仓库的密码，查询时不返回
+	TriggerOnPush   bool       `json:"trigger_on_push"`            // 本地托管内容写入时触发
+	Schedule        int        `gorm:"default:0" json:"schedule"`  // 定时执行间隔（分钟），0表示不定时执行
+	MaxRetries      int        `json:"max_retries"`                // 失败后的重试次数
+	Enabled         bool       `gorm:"default:true" json:"enabled"`
+	LastRunAt       *time.Time `json:"last_run_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// ReplicationRun 复制执行记录
+type ReplicationRun struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	RuleID        uint       `gorm:"index;not null" json:"rule_id"`
+	Trigger       string     `json:"trigger"`        // manual、push、schedule
+	Status        string     `json:"status"`         // running、success、failed
+	Images        int        `json:"images"`         // 复制的镜像数
+	ImagesSkipped int        `json:"images_skipped"` // 目标已是最新的镜像数
+	BlobsCopied   int        `json:"blobs_copied"`
+	BlobsSkipped  int        `json:"blobs_skipped"`
+	BytesCopied   int64      `json:"bytes_copied"`
+	Attempts      int        `json:"attempts"`
+	Error         string     `json:"error"`
+	StartedAt     time.Time  `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at"`
+}
+
+// TagWatch 上游镜像tag订阅
+type TagWatch struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	Pattern       string     `gorm:"uniqueIndex;not null" json:"pattern"` // 仓库和tag，tag支持通配符，如 library/postgres:16*
+	Interval      int        `json:"interval"`                            // 检查间隔（分钟）
+	WebhookURL    string     `json:"webhook_url"`                         // digest变化时通知的地址，为空表示不通知
+	Prefetch      bool       `json:"prefetch"`                            // digest变化时预取镜像到缓存
+	Platform      string     `json:"platform"`                            // 预取的平台，如 linux/amd64，为空表示全部平台
+	Enabled       bool       `gorm:"default:true" json:"enabled"`
+	LastCheckedAt *time.Time `json:"last_checked_at"`
+	LastError     string     `json:"last_error"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// TagDigestChange 上游tag的digest变化记录，第一次检查时记录初始digest
+type TagDigestChange struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	Repository     string    `gorm:"index:idx_tag_digest_changes_tag;not null" json:"repository"`
+	Tag            string    `gorm:"index:idx_tag_digest_changes_tag;not null" json:"tag"`
+	Digest         string    `gorm:"not null" json:"digest"`
+	PreviousDigest string    `json:"previous_digest"` // 为空表示首次记录
+	Upstream       string    `json:"upstream"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
 // InitDatabase 初始化数据库
 func InitDatabase(db *gorm.DB) error {
 	// 自动迁移表结构
-	err := db.AutoMigrate(&User{}, &Registry{}, &Whitelist{}, &AccessLog{})
+	err := db.AutoMigrate(&User{}, &RobotAccount{}, &AccessToken{}, &AdminSession{}, &UserGroup{}, &ACLRule{}, &Registry{}, &PushRoute{}, &Whitelist{}, &AccessLog{}, &AuditLog{}, &CachedBlob{}, &CachedBlobLink{}, &CachedManifest{}, &CachePolicy{}, &RetentionPolicy{}, &RepositoryGroup{}, &ReplicationRule{}, &ReplicationRun{}, &TagWatch{}, &TagDigestChange{})
 	if err != nil {
 		return err
 	}
 
+	// 同一镜像名可以按标签配置多条规则，去掉旧版本白名单前缀上的唯一索引
+	if db.Migrator().HasIndex(&Whitelist{}, "idx_whitelists_prefix") {
+		if err := db.Migrator().DropIndex(&Whitelist{}, "idx_whitelists_prefix"); err != nil {
+			return err
+		}
+	}
+
 	// 创建默认镜像源
 	var count int64
 	db.Model(&Registry{}).Count(&count)
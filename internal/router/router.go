@@ -11,18 +11,36 @@ import (
 
 // SetupRouter 设置所有路由
 func SetupRouter(
+	proxyService *service.ProxyService,
 	userService *service.UserService,
 	registryService *service.RegistryService,
 	whitelistService *service.WhitelistService,
 	logService *service.LogService,
+	cacheService *service.CacheService,
+	cachePolicyService *service.CachePolicyService,
+	imageService *service.ImageService,
+	groupService *service.GroupService,
+	replicationService *service.ReplicationService,
+	retentionService *service.RetentionService,
+	pushRouteService *service.PushRouteService,
+	watchService *service.WatchService,
+	refreshService *service.RefreshService,
+	tokenService *service.TokenService,
+	accessTokenService *service.AccessTokenService,
+	aclService *service.ACLService,
+	policyService *service.PolicyService,
+	sessionService *service.SessionService,
 ) *gin.Engine {
 	// 初始化处理器
 	registryHandler := handler.NewRegistryHandler(
-		service.NewProxyService(registryService),
+		proxyService,
 		registryService,
 		logService,
+		cacheService,
+		groupService,
+		pushRouteService,
 	)
-	adminHandler := handler.NewAdminHandler(userService, registryService, whitelistService, logService)
+	adminHandler := handler.NewAdminHandler(proxyService, userService, registryService, whitelistService, logService, cacheService, cachePolicyService, imageService, groupService, replicationService, retentionService, pushRouteService, watchService, refreshService, accessTokenService, aclService, policyService, sessionService)
 
 	// 设置Gin模式
 	gin.SetMode(gin.ReleaseMode)
@@ -39,10 +57,10 @@ func SetupRouter(
 	router.GET("/", controller.Home)
 
 	// 设置Docker Registry API路由
-	setupRegistryRoutes(router, registryHandler, userService, whitelistService, logService)
+	setupRegistryRoutes(router, registryHandler, userService, whitelistService, logService, tokenService, accessTokenService, aclService, groupService)
 
 	// 设置管理API路由
-	setupAdminRoutes(router, adminHandler, userService)
+	setupAdminRoutes(router, adminHandler, userService, accessTokenService, sessionService)
 
 	return router
 }
@@ -54,7 +72,18 @@ func setupRegistryRoutes(
 	userService *service.UserService,
 	whitelistService *service.WhitelistService,
 	logService *service.LogService,
+	tokenService *service.TokenService,
+	accessTokenService *service.AccessTokenService,
+	aclService *service.ACLService,
+	groupService *service.GroupService,
 ) {
+	// token认证服务
+	if tokenService.Enabled() {
+		authHandler := handler.NewAuthHandler(userService, tokenService, accessTokenService)
+		router.GET("/token", authHandler.GetToken)
+		router.GET("/.well-known/jwks.json", authHandler.GetJWKS)
+	}
+
 	// Docker Registry API路由 - 使用NoRoute处理
 	// 使用NoRoute处理所有v2请求，包括/v2/
 	router.NoRoute(func(c *gin.Context) {
@@ -63,7 +92,7 @@ func setupRegistryRoutes(
 		// 处理v2路径（包括/v2/和其他v2路径）
 		if path == "/v2/" || path == "/v2" || (len(path) > 4 && path[:4] == "/v2/") {
 			// 应用认证中间件
-			middleware.AuthMiddleware(userService, whitelistService, logService)(c)
+			middleware.AuthMiddleware(userService, whitelistService, logService, tokenService, accessTokenService, aclService, groupService)(c)
 			if c.IsAborted() {
 				return
 			}
@@ -83,15 +112,47 @@ func setupRegistryRoutes(
 }
 
 // setupAdminRoutes 设置管理API路由
-func setupAdminRoutes(router *gin.Engine, adminHandler *handler.AdminHandler, userService *service.UserService) {
+func setupAdminRoutes(router *gin.Engine, adminHandler *handler.AdminHandler, userService *service.UserService, accessTokenService *service.AccessTokenService, sessionService *service.SessionService) {
+	// 登录和刷新会话不经过管理员认证中间件，由处理器自己校验凭据
+	router.POST("/api/auth/login", adminHandler.Login)
+	router.POST("/api/auth/refresh", adminHandler.RefreshSession)
+
 	// 管理API路由
 	api := router.Group("/api")
-	api.Use(middleware.AdminAuthMiddleware(userService))
+	api.Use(middleware.AdminAuthMiddleware(userService, accessTokenService, sessionService))
 	{
 		// 用户管理
 		api.GET("/users", adminHandler.GetUsers)
 		api.POST("/users", adminHandler.CreateUser)
+		api.PUT("/users/:id", adminHandler.UpdateUserPassword)
 		api.DELETE("/users/:id", adminHandler.DeleteUser)
+		api.PUT("/users/:id/permissions", adminHandler.UpdateUserPermissions)
+		api.PUT("/users/:id/role", adminHandler.UpdateUserRole)
+		api.GET("/me", adminHandler.GetCurrentUser)
+
+		// 登录会话
+		api.POST("/auth/logout", adminHandler.Logout)
+		api.GET("/sessions", adminHandler.GetSessions)
+		api.DELETE("/sessions/:id", adminHandler.DeleteSession)
+
+		// 机器人账号和访问令牌
+		api.GET("/robots", adminHandler.GetRobots)
+		api.POST("/robots", adminHandler.CreateRobot)
+		api.DELETE("/robots/:id", adminHandler.DeleteRobot)
+		api.GET("/access-tokens", adminHandler.GetAccessTokens)
+		api.POST("/access-tokens", adminHandler.CreateAccessToken)
+		api.DELETE("/access-tokens/:id", adminHandler.DeleteAccessToken)
+
+		// 用户组和仓库访问规则
+		api.GET("/user-groups", adminHandler.GetUserGroups)
+		api.POST("/user-groups", adminHandler.CreateUserGroup)
+		api.PUT("/user-groups", adminHandler.UpdateUserGroup)
+		api.DELETE("/user-groups/:id", adminHandler.DeleteUserGroup)
+		api.GET("/acl-rules", adminHandler.GetACLRules)
+		api.POST("/acl-rules", adminHandler.CreateACLRule)
+		api.PUT("/acl-rules", adminHandler.UpdateACLRule)
+		api.DELETE("/acl-rules/:id", adminHandler.DeleteACLRule)
+		api.POST("/policy/evaluate", adminHandler.EvaluatePolicy)
 
 		// 镜像源管理
 		api.GET("/registries", adminHandler.GetRegistries)
@@ -99,15 +160,85 @@ func setupAdminRoutes(router *gin.Engine, adminHandler *handler.AdminHandler, us
 		api.PUT("/registries", adminHandler.UpdateRegistry)
 		api.DELETE("/registries/:id", adminHandler.DeleteRegistry)
 
+		// 推送路由
+		api.GET("/push-routes", adminHandler.GetPushRoutes)
+		api.POST("/push-routes", adminHandler.CreatePushRoute)
+		api.PUT("/push-routes", adminHandler.UpdatePushRoute)
+		api.DELETE("/push-routes/:id", adminHandler.DeletePushRoute)
+
+		// 仓库组管理
+		api.GET("/groups", adminHandler.GetGroups)
+		api.POST("/groups", adminHandler.CreateGroup)
+		api.PUT("/groups", adminHandler.UpdateGroup)
+		api.DELETE("/groups/:id", adminHandler.DeleteGroup)
+
+		// 复制规则
+		api.GET("/replication/rules", adminHandler.GetReplicationRules)
+		api.POST("/replication/rules", adminHandler.CreateReplicationRule)
+		api.PUT("/replication/rules", adminHandler.UpdateReplicationRule)
+		api.DELETE("/replication/rules/:id", adminHandler.DeleteReplicationRule)
+		api.POST("/replication/rules/:id/run", adminHandler.RunReplicationRule)
+		api.GET("/replication/runs", adminHandler.GetReplicationRuns)
+
 		// 白名单管理
 		api.GET("/whitelists", adminHandler.GetWhitelists)
 		api.POST("/whitelists", adminHandler.CreateWhitelist)
+		api.PUT("/whitelists", adminHandler.UpdateWhitelist)
 		api.DELETE("/whitelists/:id", adminHandler.DeleteWhitelist)
 
+		// 离线模式
+		api.GET("/offline", adminHandler.GetOfflineStatus)
+		api.PUT("/offline", adminHandler.UpdateOfflineMode)
+
+		// 缓存管理
+		api.GET("/cache/stats", adminHandler.GetCacheStats)
+		api.POST("/cache/evict", adminHandler.EvictCache)
+		api.POST("/cache/gc", adminHandler.GarbageCollect)
+		api.GET("/cache/fsck", adminHandler.GetFsckStatus)
+		api.POST("/cache/fsck", adminHandler.RunFsck)
+		api.GET("/cache/refresh", adminHandler.GetRefreshStatus)
+		api.POST("/cache/refresh", adminHandler.RunRefresh)
+		api.GET("/cache/repositories", adminHandler.GetCachedRepositories)
+		api.DELETE("/cache/repositories", adminHandler.PurgeCachedRepository)
+		api.GET("/cache/manifests", adminHandler.GetCachedManifests)
+		api.DELETE("/cache/manifests", adminHandler.PurgeCachedManifest)
+		api.GET("/cache/image", adminHandler.GetCachedImage)
+		api.DELETE("/cache/blobs/:digest", adminHandler.PurgeCachedBlob)
+
+		// 缓存规则
+		api.GET("/cache/policies", adminHandler.GetCachePolicies)
+		api.POST("/cache/policies", adminHandler.CreateCachePolicy)
+		api.PUT("/cache/policies", adminHandler.UpdateCachePolicy)
+		api.DELETE("/cache/policies/:id", adminHandler.DeleteCachePolicy)
+
+		// 托管仓库保留策略
+		api.GET("/retention/policies", adminHandler.GetRetentionPolicies)
+		api.POST("/retention/policies", adminHandler.CreateRetentionPolicy)
+		api.PUT("/retention/policies", adminHandler.UpdateRetentionPolicy)
+		api.DELETE("/retention/policies/:id", adminHandler.DeleteRetentionPolicy)
+		api.POST("/retention/run", adminHandler.RunRetention)
+
+		// 上游tag订阅
+		api.GET("/watches", adminHandler.GetTagWatches)
+		api.POST("/watches", adminHandler.CreateTagWatch)
+		api.PUT("/watches", adminHandler.UpdateTagWatch)
+		api.DELETE("/watches/:id", adminHandler.DeleteTagWatch)
+		api.POST("/watches/:id/check", adminHandler.CheckTagWatch)
+		api.GET("/watches/history", adminHandler.GetTagHistory)
+
+		// 镜像导出和离线包
+		api.GET("/images/export", adminHandler.ExportImage)
+		api.GET("/resolve", adminHandler.ResolveImage)
+		api.POST("/bundles/export", adminHandler.ExportBundle)
+		api.POST("/bundles/import", adminHandler.ImportBundle)
+
 		// 访问日志
 		api.GET("/logs", adminHandler.GetAccessLogs)
 		api.DELETE("/logs", adminHandler.ClearAccessLogs)
 
+		// 审计日志
+		api.GET("/audit", adminHandler.GetAuditLogs)
+
 		// 系统信息
 		api.GET("/version", adminHandler.GetVersion)
 	}
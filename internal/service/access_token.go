@@ -0,0 +1,320 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"zmirror/internal/model"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// AccessTokenPrefix 访问令牌的固定前缀，用于和普通密码区分
+	AccessTokenPrefix = "zmt_"
+
+	// RobotUserPrefix 机器人账号认证时使用的用户名前缀
+	RobotUserPrefix = "robot$"
+
+	// accessTokenTouchInterval 最近使用时间的更新间隔，避免每个blob请求都写数据库
+	accessTokenTouchInterval = time.Minute
+)
+
+// AccessTokenActions 访问令牌可以授予的操作
+var AccessTokenActions = []string{"pull", "push", "delete", "admin-api"}
+
+// ErrInvalidAccessToken 访问令牌不存在、已过期或与用户名不匹配
+var ErrInvalidAccessToken = errors.New("invalid access token")
+
+var robotNamePattern = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+// AccessTokenService 管理机器人账号和访问令牌
+type AccessTokenService struct {
+	db *gorm.DB
+}
+
+func NewAccessTokenService(db *gorm.DB) *AccessTokenService {
+	return &AccessTokenService{db: db}
+}
+
+// GetAllRobots 获取所有机器人账号
+func (s *AccessTokenService) GetAllRobots() ([]model.RobotAccount, error) {
+	var robots []model.RobotAccount
+	err := s.db.Order("id ASC").Find(&robots).Error
+	return robots, err
+}
+
+// GetRobot 按名称获取机器人账号
+func (s *AccessTokenService) GetRobot(name string) (*model.RobotAccount, error) {
+	var robot model.RobotAccount
+	if err := s.db.Where("name = ?", name).First(&robot).Error; err != nil {
+		return nil, err
+	}
+	return &robot, nil
+}
+
+// CreateRobot 创建机器人账号，名称只能包含小写字母、数字和 . _ -，未指定角色时为auditor
+func (s *AccessTokenService) CreateRobot(robot *model.RobotAccount) error {
+	if !robotNamePattern.MatchString(robot.Name) {
+		return fmt.Errorf("robot name may only contain lowercase letters, digits and . _ -")
+	}
+	if robot.Role == "" {
+		robot.Role = RoleAuditor
+	}
+	if err := validateRole(robot.Role); err != nil {
+		return err
+	}
+	return s.db.Create(robot).Error
+}
+
+// DeleteRobot 删除机器人账号及其所有访问令牌
+func (s *AccessTokenService) DeleteRobot(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("robot_id = ?", id).Delete(&model.AccessToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.RobotAccount{}, id).Error
+	})
+}
+
+// GetToken 按ID获取访问令牌
+func (s *AccessTokenService) GetToken(id uint) (*model.AccessToken, error) {
+	var token model.AccessToken
+	if err := s.db.First(&token, id).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// GetAllTokens 获取所有访问令牌，不包含令牌明文
+func (s *AccessTokenService) GetAllTokens() ([]model.AccessToken, error) {
+	var tokens []model.AccessToken
+	err := s.db.Order("id ASC").Find(&tokens).Error
+	return tokens, err
+}
+
+// CreateToken 创建访问令牌，返回只在此时可见的令牌明文
+func (s *AccessTokenService) CreateToken(token *model.AccessToken) (string, error) {
+	if err := s.validateToken(token); err != nil {
+		return "", err
+	}
+
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	secret := AccessTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	token.ID = 0
+	token.TokenHash = hashAccessToken(secret)
+	token.Prefix = secret[:len(AccessTokenPrefix)+6]
+	token.LastUsedAt = nil
+	token.LastUsedIP = ""
+	if err := s.db.Create(token).Error; err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// DeleteToken 吊销访问令牌
+func (s *AccessTokenService) DeleteToken(id uint) error {
+	return s.db.Delete(&model.AccessToken{}, id).Error
+}
+
+// validateToken 校验令牌的所属账号、操作和仓库规则
+func (s *AccessTokenService) validateToken(token *model.AccessToken) error {
+	if strings.TrimSpace(token.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if (token.UserID == 0) == (token.RobotID == 0) {
+		return fmt.Errorf("token must belong to exactly one user or robot account")
+	}
+
+	if len(token.Actions) == 0 {
+		return fmt.Errorf("at least one action is required")
+	}
+	actions := []string{}
+	for _, action := range token.Actions {
+		if !containsString(AccessTokenActions, action) {
+			return fmt.Errorf("unknown action %q", action)
+		}
+		if !containsString(actions, action) {
+			actions = append(actions, action)
+		}
+	}
+	token.Actions = actions
+
+	repositories := []string{}
+	for _, pattern := range token.Repositories {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if strings.ContainsAny(pattern, "*?[") {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid repository pattern %q: %v", pattern, err)
+			}
+		}
+		repositories = append(repositories, pattern)
+	}
+	token.Repositories = repositories
+
+	if token.UserID != 0 {
+		var user model.User
+		if err := s.db.First(&user, token.UserID).Error; err != nil {
+			return fmt.Errorf("user %d not found", token.UserID)
+		}
+		if containsString(token.Actions, "admin-api") && user.Role == "" {
+			return fmt.Errorf("admin-api is only available to administrators and robot accounts")
+		}
+	} else {
+		var robot model.RobotAccount
+		if err := s.db.First(&robot, token.RobotID).Error; err != nil {
+			return fmt.Errorf("robot account %d not found", token.RobotID)
+		}
+	}
+	return nil
+}
+
+// Authenticate 用访问令牌认证。username为空时只按令牌查找（/api 的Bearer认证），
+// 否则必须与令牌所属的用户名或 robot$<机器人名> 一致。返回令牌代表的用户，
+// 其推送、删除和管理权限是所属账号权限与令牌操作的交集
+func (s *AccessTokenService) Authenticate(username, secret, clientIP string) (*model.User, *model.AccessToken, error) {
+	if !strings.HasPrefix(secret, AccessTokenPrefix) {
+		return nil, nil, ErrInvalidAccessToken
+	}
+
+	var token model.AccessToken
+	if err := s.db.Where("token_hash = ?", hashAccessToken(secret)).First(&token).Error; err != nil {
+		return nil, nil, ErrInvalidAccessToken
+	}
+	if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
+		return nil, nil, ErrInvalidAccessToken
+	}
+
+	user, err := s.tokenUser(&token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if username != "" && username != user.Username {
+		return nil, nil, ErrInvalidAccessToken
+	}
+
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= accessTokenTouchInterval || token.LastUsedIP != clientIP {
+		s.db.Model(&model.AccessToken{}).Where("id = ?", token.ID).UpdateColumns(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": clientIP,
+		})
+		token.LastUsedAt = &now
+		token.LastUsedIP = clientIP
+	}
+	return user, &token, nil
+}
+
+// tokenUser 返回令牌代表的用户，其推送、删除和管理权限是所属账号权限与令牌操作的交集
+func (s *AccessTokenService) tokenUser(token *model.AccessToken) (*model.User, error) {
+	if token.UserID != 0 {
+		var owner model.User
+		if err := s.db.First(&owner, token.UserID).Error; err != nil {
+			return nil, ErrInvalidAccessToken
+		}
+		owner.CanPush = owner.CanPush && containsString(token.Actions, "push")
+		owner.CanDelete = owner.CanDelete && containsString(token.Actions, "delete")
+		// 不含admin-api的令牌不能访问管理API，也不具有镜像仓库管理员权限
+		if !containsString(token.Actions, "admin-api") {
+			owner.Role = ""
+			owner.IsAdmin = false
+		}
+		return &owner, nil
+	}
+
+	var robot model.RobotAccount
+	if err := s.db.First(&robot, token.RobotID).Error; err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+	user := &model.User{
+		Username:  RobotUserPrefix + robot.Name,
+		CanPush:   containsString(token.Actions, "push"),
+		CanDelete: containsString(token.Actions, "delete"),
+	}
+	// 带admin-api的机器人令牌按机器人账号的角色调用管理API
+	if containsString(token.Actions, "admin-api") {
+		user.Role = robot.Role
+		user.IsAdmin = isRegistryAdmin(robot.Role)
+	}
+	return user, nil
+}
+
+// AccessTokenAllows 判断访问令牌是否允许对仓库执行操作
+func AccessTokenAllows(token *model.AccessToken, repository, action string) bool {
+	if !containsString(token.Actions, action) {
+		return false
+	}
+	if len(token.Repositories) == 0 {
+		return true
+	}
+	for _, pattern := range token.Repositories {
+		if MatchRepository(pattern, repository) {
+			return true
+		}
+	}
+	return false
+}
+
+// RestrictAccess 把已按用户权限过滤的token访问范围进一步限制在访问令牌允许的仓库和操作内，
+// 仓库组的访问按去掉组名后的仓库检查
+func (s *TokenService) RestrictAccess(token *model.AccessToken, access []TokenAccess) []TokenAccess {
+	restricted := []TokenAccess{}
+	for _, entry := range access {
+		var actions []string
+		switch entry.Type {
+		case "repository":
+			repository := s.groupService.ResolveName(entry.Name)
+			for _, action := range entry.Actions {
+				if action == "*" {
+					// * 只在令牌同时允许拉取、推送和删除时保留
+					if AccessTokenAllows(token, repository, "pull") && AccessTokenAllows(token, repository, "push") && AccessTokenAllows(token, repository, "delete") {
+						actions = append(actions, action)
+					}
+					continue
+				}
+				if AccessTokenAllows(token, repository, action) {
+					actions = append(actions, action)
+				}
+			}
+		case "registry":
+			if containsString(token.Actions, "admin-api") {
+				actions = entry.Actions
+			}
+		}
+		if len(actions) > 0 {
+			restricted = append(restricted, TokenAccess{Type: entry.Type, Name: entry.Name, Actions: actions})
+		}
+	}
+	return restricted
+}
+
+// hashAccessToken 计算令牌明文的SHA-256。令牌本身是高熵随机值，不需要慢哈希
+func hashAccessToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// AuthenticateCredential 校验Basic认证的用户名和密码。以访问令牌前缀开头的密码按访问令牌认证，
+// 返回的访问令牌不为nil时，调用方需要用它限制可访问的仓库和操作
+func AuthenticateCredential(userService *UserService, accessTokenService *AccessTokenService, username, password, clientIP string) (*model.User, *model.AccessToken, error) {
+	if strings.HasPrefix(password, AccessTokenPrefix) {
+		return accessTokenService.Authenticate(username, password, clientIP)
+	}
+	user, err := userService.AuthenticateUser(username, password)
+	return user, nil, err
+}
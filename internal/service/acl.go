@@ -0,0 +1,298 @@
+package service
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"zmirror/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// ACLActions 访问规则可以控制的操作
+var ACLActions = []string{"pull", "push", "delete"}
+
+// ACLDecision 访问规则的判定结果
+type ACLDecision struct {
+	Allowed bool
+	Rule    *model.ACLRule // 决定结果的规则，nil表示没有规则匹配，按原有权限处理
+}
+
+// ACLService 管理用户组和仓库访问规则
+type ACLService struct {
+	db *gorm.DB
+
+	// 启用的规则和用户组成员关系缓存在内存中，每个Registry请求都要判定；通过管理API修改后整体替换
+	mu       sync.Mutex
+	snapshot atomic.Pointer[aclSnapshot]
+}
+
+// aclSnapshot 启用的访问规则（按优先级排序）和每个用户所属的用户组
+type aclSnapshot struct {
+	rules  []model.ACLRule
+	groups map[uint][]string
+}
+
+func NewACLService(db *gorm.DB) *ACLService {
+	return &ACLService{db: db}
+}
+
+// GetAllGroups 获取所有用户组
+func (s *ACLService) GetAllGroups() ([]model.UserGroup, error) {
+	var groups []model.UserGroup
+	err := s.db.Order("id ASC").Find(&groups).Error
+	return groups, err
+}
+
+// CreateGroup 创建用户组
+func (s *ACLService) CreateGroup(group *model.UserGroup) error {
+	if err := validateUserGroup(group); err != nil {
+		return err
+	}
+	if err := s.db.Create(group).Error; err != nil {
+		return err
+	}
+	_, err := s.reload()
+	return err
+}
+
+// UpdateGroup 更新用户组，改名时引用该组的规则一并更新
+func (s *ACLService) UpdateGroup(group *model.UserGroup) error {
+	if err := validateUserGroup(group); err != nil {
+		return err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var existing model.UserGroup
+		if err := tx.First(&existing, group.ID).Error; err != nil {
+			return err
+		}
+		if existing.Name != group.Name {
+			err := tx.Model(&model.ACLRule{}).Where("subject_type = ? AND subject = ?", "group", existing.Name).
+				Update("subject", group.Name).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Select("name", "description", "user_ids").Updates(group).Error
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.reload()
+	return err
+}
+
+// DeleteGroup 删除用户组及引用该组的规则
+func (s *ACLService) DeleteGroup(id uint) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var group model.UserGroup
+		if err := tx.First(&group, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("subject_type = ? AND subject = ?", "group", group.Name).Delete(&model.ACLRule{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&group).Error
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.reload()
+	return err
+}
+
+// validateUserGroup 校验用户组并去除重复成员
+func validateUserGroup(group *model.UserGroup) error {
+	group.Name = strings.TrimSpace(group.Name)
+	if group.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	members := []uint{}
+	for _, id := range group.UserIDs {
+		if id != 0 && !containsUint(members, id) {
+			members = append(members, id)
+		}
+	}
+	group.UserIDs = members
+	return nil
+}
+
+// GetAllRules 获取所有访问规则，按优先级排序
+func (s *ACLService) GetAllRules() ([]model.ACLRule, error) {
+	var rules []model.ACLRule
+	err := s.db.Order("priority ASC, id ASC").Find(&rules).Error
+	return rules, err
+}
+
+// CreateRule 创建访问规则
+func (s *ACLService) CreateRule(rule *model.ACLRule) error {
+	if err := s.validateRule(rule); err != nil {
+		return err
+	}
+	if err := s.db.Create(rule).Error; err != nil {
+		return err
+	}
+	_, err := s.reload()
+	return err
+}
+
+// UpdateRule 更新访问规则
+func (s *ACLService) UpdateRule(rule *model.ACLRule) error {
+	if err := s.validateRule(rule); err != nil {
+		return err
+	}
+	if err := s.db.Select("subject_type", "subject", "repository", "actions", "effect", "priority", "enabled").Updates(rule).Error; err != nil {
+		return err
+	}
+	_, err := s.reload()
+	return err
+}
+
+// DeleteRule 删除访问规则
+func (s *ACLService) DeleteRule(id uint) error {
+	if err := s.db.Delete(&model.ACLRule{}, id).Error; err != nil {
+		return err
+	}
+	_, err := s.reload()
+	return err
+}
+
+// UserDeleted 删除用户时会从用户组中移除，重新加载成员关系，避免新用户复用ID后继承旧的组
+func (s *ACLService) UserDeleted(id uint) {
+	s.reload()
+}
+
+// validateRule 校验访问规则
+func (s *ACLService) validateRule(rule *model.ACLRule) error {
+	rule.Subject = strings.TrimSpace(rule.Subject)
+	switch rule.SubjectType {
+	case "user":
+		if rule.Subject == "" {
+			return fmt.Errorf("subject is required")
+		}
+	case "group":
+		if rule.Subject == "" {
+			return fmt.Errorf("subject is required")
+		}
+		var count int64
+		s.db.Model(&model.UserGroup{}).Where("name = ?", rule.Subject).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("user group %q not found", rule.Subject)
+		}
+	case "all":
+		rule.Subject = ""
+	default:
+		return fmt.Errorf("subject_type must be user, group or all")
+	}
+
+	if rule.Effect != "allow" && rule.Effect != "deny" {
+		return fmt.Errorf("effect must be allow or deny")
+	}
+
+	rule.Repository = strings.TrimSpace(rule.Repository)
+	if strings.ContainsAny(rule.Repository, "*?[") {
+		if _, err := path.Match(rule.Repository, ""); err != nil {
+			return fmt.Errorf("invalid repository pattern: %v", err)
+		}
+	}
+
+	if len(rule.Actions) == 0 {
+		return fmt.Errorf("at least one action is required")
+	}
+	actions := []string{}
+	for _, action := range rule.Actions {
+		if !containsString(ACLActions, action) {
+			return fmt.Errorf("unknown action %q", action)
+		}
+		if !containsString(actions, action) {
+			actions = append(actions, action)
+		}
+	}
+	rule.Actions = actions
+	return nil
+}
+
+// Check 判断用户能否对仓库执行操作。管理员不受访问规则限制；其他用户按优先级找到第一条
+// 主体、仓库和操作都匹配的启用规则，由它决定允许或拒绝，没有规则匹配时允许
+func (s *ACLService) Check(user *model.User, repository, action string) (ACLDecision, error) {
+	if user == nil || user.IsAdmin {
+		return ACLDecision{Allowed: true}, nil
+	}
+
+	snapshot := s.snapshot.Load()
+	if snapshot == nil {
+		var err error
+		if snapshot, err = s.reload(); err != nil {
+			return ACLDecision{}, err
+		}
+	}
+
+	// 机器人账号的ID为0，不属于任何组
+	groups := snapshot.groups[user.ID]
+	for i := range snapshot.rules {
+		rule := &snapshot.rules[i]
+		if !matchACLSubject(rule, user, groups) || !containsString(rule.Actions, action) {
+			continue
+		}
+		if rule.Repository != "" && !MatchRepository(rule.Repository, repository) {
+			continue
+		}
+		matched := *rule
+		return ACLDecision{Allowed: rule.Effect == "allow", Rule: &matched}, nil
+	}
+	return ACLDecision{Allowed: true}, nil
+}
+
+// reload 重新从数据库加载启用的规则和用户组成员关系。加锁保证并发加载时最后写入的是修改之后查询到的结果，
+// 加载失败时清空缓存，下次判定再重试
+func (s *ACLService) reload() (*aclSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rules []model.ACLRule
+	if err := s.db.Where("enabled = ?", true).Order("priority ASC, id ASC").Find(&rules).Error; err != nil {
+		s.snapshot.Store(nil)
+		return nil, err
+	}
+	var userGroups []model.UserGroup
+	if err := s.db.Find(&userGroups).Error; err != nil {
+		s.snapshot.Store(nil)
+		return nil, err
+	}
+
+	snapshot := &aclSnapshot{rules: rules, groups: make(map[uint][]string)}
+	for _, group := range userGroups {
+		for _, id := range group.UserIDs {
+			snapshot.groups[id] = append(snapshot.groups[id], group.Name)
+		}
+	}
+	s.snapshot.Store(snapshot)
+	return snapshot, nil
+}
+
+// matchACLSubject 判断规则的主体是否包含用户
+func matchACLSubject(rule *model.ACLRule, user *model.User, groups []string) bool {
+	switch rule.SubjectType {
+	case "all":
+		return true
+	case "user":
+		return rule.Subject == user.Username
+	case "group":
+		return containsString(groups, rule.Subject)
+	}
+	return false
+}
+
+// containsUint 判断整数切片是否包含指定值
+func containsUint(values []uint, value uint) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
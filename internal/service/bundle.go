@@ -0,0 +1,277 @@
+package service
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+)
+
+// bundleUpstream 从离线包导入的内容记录的来源，本地托管的blob都来自离线包，垃圾回收只回收这类blob
+const bundleUpstream = "bundle"
+
+// ErrCacheDisabled 本地缓存未启用
+var ErrCacheDisabled = errors.New("local cache is disabled")
+
+// ReadImageList 读取镜像列表文件，每行一个镜像引用，忽略空行和#开头的注释
+func ReadImageList(r io.Reader) ([]string, error) {
+	var refs []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		refs = append(refs, line)
+	}
+	return refs, scanner.Err()
+}
+
+// bundleImage 离线包中的一个镜像
+type bundleImage struct {
+	repository string
+	reference  string
+	manifest   *FetchedManifest
+}
+
+// imageName 镜像在离线包index.json中记录的名称
+func (b *bundleImage) imageName() string {
+	if IsDigest(b.reference) {
+		return b.repository + "@" + b.reference
+	}
+	return b.repository + ":" + b.reference
+}
+
+// Bundle 准备导出的离线包
+type Bundle struct {
+	images []bundleImage
+}
+
+// PrepareBundle 解析全部镜像引用并获取顶层manifest，避免写出一半后才发现引用错误
+func (s *ImageService) PrepareBundle(refs []string) (*Bundle, error) {
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("no images to export")
+	}
+
+	bundle := &Bundle{images: make([]bundleImage, 0, len(refs))}
+	for _, ref := range refs {
+		repository, reference, err := ParseImageReference(ref)
+		if err != nil {
+			return nil, err
+		}
+		fetched, err := s.FetchManifest(repository, reference)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", ref, err)
+		}
+		bundle.images = append(bundle.images, bundleImage{repository: repository, reference: reference, manifest: fetched})
+	}
+	return bundle, nil
+}
+
+// ExportBundle 将多个镜像（包含全部平台）写入一个OCI镜像布局tar流
+func (s *ImageService) ExportBundle(w io.Writer, refs []string) error {
+	bundle, err := s.PrepareBundle(refs)
+	if err != nil {
+		return err
+	}
+	return s.WriteBundle(w, bundle)
+}
+
+// WriteBundle 写出离线包，相同的blob只写入一次
+func (s *ImageService) WriteBundle(w io.Writer, bundle *Bundle) error {
+	layout := newLayoutWriter(s, w)
+	descriptors := make([]Descriptor, 0, len(bundle.images))
+	for i := range bundle.images {
+		image := &bundle.images[i]
+		if err := layout.writeManifestTree(image.repository, image.manifest); err != nil {
+			return fmt.Errorf("%s: %v", image.imageName(), err)
+		}
+
+		annotations := map[string]string{"io.containerd.image.name": image.imageName()}
+		if !IsDigest(image.reference) {
+			annotations["org.opencontainers.image.ref.name"] = image.reference
+		}
+		descriptors = append(descriptors, Descriptor{
+			MediaType:   image.manifest.MediaType,
+			Digest:      image.manifest.Digest,
+			Size:        int64(len(image.manifest.Content)),
+			Annotations: annotations,
+		})
+		log.Printf("Bundle: exported %s (%s)", image.imageName(), image.manifest.Digest)
+	}
+
+	return layout.close(descriptors)
+}
+
+// writeManifestTree 写入manifest，index会递归写入所有平台的manifest
+func (l *layoutWriter) writeManifestTree(repository string, m *FetchedManifest) error {
+	if !m.Manifest.IsIndex() {
+		return l.writeImage(repository, m)
+	}
+
+	if err := l.writeBlobContent(m.Digest, m.Content); err != nil {
+		return err
+	}
+	for _, child := range m.Manifest.Manifests {
+		if l.written[child.Digest] {
+			continue
+		}
+		fetched, err := l.images.FetchManifest(repository, child.Digest)
+		if err != nil {
+			return fmt.Errorf("failed to fetch manifest %s: %v", child.Digest, err)
+		}
+		if err := l.writeManifestTree(repository, fetched); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BundleImportResult 离线包导入结果
+type BundleImportResult struct {
+	Images        []string `json:"images"`
+	BlobsImported int      `json:"blobs_imported"`
+	BlobsSkipped  int      `json:"blobs_skipped"`
+	BytesImported int64    `json:"bytes_imported"`
+}
+
+// ImportBundle 从OCI镜像布局tar流导入镜像。每个blob都会校验digest，manifest作为本地托管内容保存，不会过期或被淘汰
+func (s *ImageService) ImportBundle(r io.Reader) (*BundleImportResult, error) {
+	if !s.cacheService.Enabled() {
+		return nil, ErrCacheDisabled
+	}
+
+	result := &BundleImportResult{Images: []string{}}
+	var index *Manifest
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		switch {
+		case name == "index.json":
+			content, err := io.ReadAll(io.LimitReader(tr, MaxManifestSize))
+			if err != nil {
+				return nil, err
+			}
+			if index, err = ParseManifest(content); err != nil {
+				return nil, fmt.Errorf("invalid index.json: %v", err)
+			}
+
+		case strings.HasPrefix(name, "blobs/sha256/"):
+			digest := "sha256:" + strings.TrimPrefix(name, "blobs/sha256/")
+			imported, err := s.importBlob(digest, tr)
+			if err != nil {
+				return nil, err
+			}
+			if imported {
+				result.BlobsImported++
+				result.BytesImported += header.Size
+			} else {
+				result.BlobsSkipped++
+			}
+		}
+	}
+	if index == nil {
+		return nil, fmt.Errorf("invalid bundle: index.json not found")
+	}
+
+	var repositories []string
+	seen := make(map[string]bool)
+	for _, desc := range index.Manifests {
+		name := desc.Annotations["io.containerd.image.name"]
+		if name == "" {
+			return nil, fmt.Errorf("index.json entry %s has no image name annotation", desc.Digest)
+		}
+		repository, reference, err := ParseImageReference(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.importManifestTree(repository, desc.Digest, desc.MediaType); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		if reference != desc.Digest {
+			content, err := s.cacheService.readBlob(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := s.cacheService.PutManifest(repository, reference, content, desc.MediaType, bundleUpstream, true); err != nil {
+				return nil, err
+			}
+		}
+		result.Images = append(result.Images, name)
+		if !seen[repository] {
+			seen[repository] = true
+			repositories = append(repositories, repository)
+		}
+		log.Printf("Bundle: imported %s (%s)", name, desc.Digest)
+	}
+
+	s.notifyHostedWrite(repositories)
+	return result, nil
+}
+
+// importBlob 写入一个blob并校验digest，已存在的blob跳过
+func (s *ImageService) importBlob(digest string, r io.Reader) (bool, error) {
+	if !IsDigest(digest) {
+		return false, fmt.Errorf("invalid blob in bundle: %s", digest)
+	}
+	if _, err := s.cacheService.StatBlob(digest); err == nil {
+		return false, nil
+	}
+
+	w, err := s.cacheService.CreateBlobWriter(digest, "", bundleUpstream)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Cancel()
+		return false, err
+	}
+	if err := w.Commit(); err != nil {
+		return false, fmt.Errorf("blob %s: %v", digest, err)
+	}
+	return true, nil
+}
+
+// importManifestTree 将已导入的manifest按digest记录为托管内容，并确认其引用的blob都已导入
+func (s *ImageService) importManifestTree(repository, digest, mediaType string) error {
+	content, err := s.cacheService.readBlob(digest)
+	if err != nil {
+		return fmt.Errorf("manifest %s is missing from bundle", digest)
+	}
+	parsed, err := ParseManifest(content)
+	if err != nil {
+		return fmt.Errorf("invalid manifest %s: %v", digest, err)
+	}
+	if mediaType == "" {
+		mediaType = parsed.MediaType
+	}
+
+	for _, child := range parsed.Manifests {
+		if err := s.importManifestTree(repository, child.Digest, child.MediaType); err != nil {
+			return err
+		}
+	}
+	for _, blob := range parsed.BlobReferences() {
+		if _, err := s.cacheService.StatBlob(blob); err != nil {
+			return fmt.Errorf("blob %s is missing from bundle", blob)
+		}
+	}
+
+	_, err = s.cacheService.PutManifest(repository, digest, content, mediaType, bundleUpstream, true)
+	return err
+}
@@ -0,0 +1,829 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zmirror/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	megabyte = 1024 * 1024
+
+	// accessTouchInterval 最近访问时间的最小更新间隔，避免每次请求都写数据库
+	accessTouchInterval = time.Minute
+
+	// gcGracePeriod 新写入的blob在该时间内不会被GC回收，避免与正在进行的拉取竞争
+	gcGracePeriod = time.Hour
+)
+
+// ErrCacheMiss 缓存中不存在
+var ErrCacheMiss = errors.New("cache miss")
+
+type CacheService struct {
+	db              *gorm.DB
+	registryService *RegistryService
+	policyService   *CachePolicyService
+	enabled         bool
+	root            string
+	maxSize         int64
+	manifestTTL     time.Duration
+	mu              sync.Mutex // 串行化淘汰和GC
+	fsck            fsckState
+	deleteEnabled   atomic.Bool // 允许通过Registry API删除本地托管内容
+}
+
+func NewCacheService(db *gorm.DB, registryService *RegistryService, policyService *CachePolicyService, enabled bool, root string, maxSizeMB int64, manifestTTL time.Duration) *CacheService {
+	return &CacheService{
+		db:              db,
+		registryService: registryService,
+		policyService:   policyService,
+		enabled:         enabled,
+		root:            root,
+		maxSize:         maxSizeMB * megabyte,
+		manifestTTL:     manifestTTL,
+	}
+}
+
+// SetDeleteEnabled 设置是否允许通过Registry API删除本地托管内容
+func (s *CacheService) SetDeleteEnabled(enabled bool) {
+	s.deleteEnabled.Store(enabled)
+}
+
+// DeleteEnabled 是否允许通过Registry API删除本地托管内容
+func (s *CacheService) DeleteEnabled() bool {
+	return s.enabled && s.deleteEnabled.Load()
+}
+
+// Enabled 是否启用缓存
+func (s *CacheService) Enabled() bool {
+	return s.enabled
+}
+
+// blobPath 返回blob在磁盘上的存储路径
+func (s *CacheService) blobPath(digest string) string {
+	hexPart := strings.TrimPrefix(digest, "sha256:")
+	return filepath.Join(s.root, "blobs", "sha256", hexPart[:2], hexPart)
+}
+
+// StatBlob 查询已缓存的blob
+func (s *CacheService) StatBlob(digest string) (*model.CachedBlob, error) {
+	if !IsDigest(digest) {
+		return nil, ErrCacheMiss
+	}
+
+	// 使用Find避免缓存未命中时输出record not found日志
+	var blob model.CachedBlob
+	if tx := s.db.Where("digest = ?", digest).Limit(1).Find(&blob); tx.Error != nil || tx.RowsAffected == 0 {
+		return nil, ErrCacheMiss
+	}
+
+	// 数据库记录存在但文件丢失时，清理记录
+	if _, err := os.Stat(s.blobPath(digest)); err != nil {
+		s.db.Delete(&blob)
+		return nil, ErrCacheMiss
+	}
+	return &blob, nil
+}
+
+// OpenBlob 打开已缓存的blob并更新最近访问时间
+func (s *CacheService) OpenBlob(digest string) (*os.File, *model.CachedBlob, error) {
+	blob, err := s.StatBlob(digest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(s.blobPath(digest))
+	if err != nil {
+		return nil, nil, ErrCacheMiss
+	}
+
+	s.touchBlob(blob)
+	return f, blob, nil
+}
+
+// readBlob 读取整个blob内容，仅用于manifest等小文件
+func (s *CacheService) readBlob(digest string) ([]byte, error) {
+	if !IsDigest(digest) {
+		return nil, ErrCacheMiss
+	}
+	return os.ReadFile(s.blobPath(digest))
+}
+
+// touchBlob 异步更新blob的最近访问时间
+func (s *CacheService) touchBlob(blob *model.CachedBlob) {
+	if time.Since(blob.LastAccessAt) < accessTouchInterval {
+		return
+	}
+	go func() {
+		s.db.Model(&model.CachedBlob{}).Where("id = ?", blob.ID).UpdateColumn("last_access_at", time.Now())
+	}()
+}
+
+// BlobWriter 边下载边写入缓存的blob写入器
+type BlobWriter struct {
+	service   *CacheService
+	digest    string
+	mediaType string
+	upstream  string
+	file      *os.File
+	hash      hash.Hash
+	size      int64
+	err       error
+}
+
+// CreateBlobWriter 创建blob写入器，内容先写入临时文件，校验digest后再提交
+func (s *CacheService) CreateBlobWriter(digest, mediaType, upstream string) (*BlobWriter, error) {
+	if !IsDigest(digest) {
+		return nil, fmt.Errorf("invalid digest: %s", digest)
+	}
+
+	uploadDir := filepath.Join(s.root, "uploads")
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(uploadDir, "blob-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlobWriter{
+		service:   s,
+		digest:    digest,
+		mediaType: mediaType,
+		upstream:  upstream,
+		file:      f,
+		hash:      sha256.New(),
+	}, nil
+}
+
+// Write 写入数据。写入失败只记录错误，不影响调用方继续向客户端传输
+func (w *BlobWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return len(p), nil
+	}
+	if _, err := w.file.Write(p); err != nil {
+		w.err = err
+		return len(p), nil
+	}
+	w.hash.Write(p)
+	w.size += int64(len(p))
+	return len(p), nil
+}
+
+// Commit 校验digest并将blob移入存储目录
+func (w *BlobWriter) Commit() error {
+	defer os.Remove(w.file.Name())
+
+	if err := w.file.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	actual := "sha256:" + hex.EncodeToString(w.hash.Sum(nil))
+	if actual != w.digest {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", w.digest, actual)
+	}
+
+	target := w.service.blobPath(w.digest)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(w.file.Name(), target); err != nil {
+		return err
+	}
+
+	return w.service.recordBlob(w.digest, w.size, w.mediaType, w.upstream)
+}
+
+// Cancel 放弃写入
+func (w *BlobWriter) Cancel() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// PutBlob 写入一段完整内容并返回其digest
+func (s *CacheService) PutBlob(content []byte, mediaType, upstream string) (string, error) {
+	sum := sha256.Sum256(content)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	w, err := s.CreateBlobWriter(digest, mediaType, upstream)
+	if err != nil {
+		return "", err
+	}
+	w.Write(content)
+	return digest, w.Commit()
+}
+
+// recordBlob 写入或刷新blob记录
+func (s *CacheService) recordBlob(digest string, size int64, mediaType, upstream string) error {
+	now := time.Now()
+	blob := model.CachedBlob{
+		Digest:       digest,
+		Size:         size,
+		MediaType:    mediaType,
+		Upstream:     upstream,
+		LastAccessAt: now,
+		CreatedAt:    now,
+	}
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "digest"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"last_access_at": now}),
+	}).Create(&blob).Error
+}
+
+// PutManifest 缓存manifest内容，并记录仓库引用到digest的映射
+func (s *CacheService) PutManifest(repository, reference string, content []byte, mediaType, upstream string, hosted bool) (string, error) {
+	digest, err := s.PutBlob(content, mediaType, upstream)
+	if err != nil {
+		return "", err
+	}
+	if IsDigest(reference) && reference != digest {
+		return "", fmt.Errorf("digest mismatch: expected %s, got %s", reference, digest)
+	}
+
+	references := []string{digest}
+	if reference != digest {
+		references = append(references, reference)
+	}
+
+	now := time.Now()
+	for _, ref := range references {
+		manifest := model.CachedManifest{
+			Repository:   repository,
+			Reference:    ref,
+			Digest:       digest,
+			MediaType:    mediaType,
+			Upstream:     upstream,
+			Hosted:       hosted,
+			LastAccessAt: now,
+		}
+		err := s.db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "repository"}, {Name: "reference"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"digest":         digest,
+				"media_type":     mediaType,
+				"upstream":       upstream,
+				"hosted":         hosted,
+				"last_access_at": now,
+				"updated_at":     now,
+			}),
+		}).Create(&manifest).Error
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// manifest来自该仓库，其引用的配置和镜像层之后可以通过该仓库从缓存读取
+	links := []string{digest}
+	if parsed, err := ParseManifest(content); err == nil {
+		links = append(links, parsed.BlobReferences()...)
+	}
+	if err := s.LinkBlobs(repository, links...); err != nil {
+		return "", err
+	}
+
+	return digest, nil
+}
+
+// LinkBlobs 将blob关联到仓库
+func (s *CacheService) LinkBlobs(repository string, digests ...string) error {
+	if len(digests) == 0 {
+		return nil
+	}
+	links := make([]model.CachedBlobLink, 0, len(digests))
+	for _, digest := range digests {
+		links = append(links, model.CachedBlobLink{Digest: digest, Repository: repository})
+	}
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&links).Error
+}
+
+// BlobLinked 判断blob是否关联到仓库。同一个blob可能从不同仓库缓存，未关联的仓库需要由上游确认，
+// 否则只能拉取一个仓库的用户可以按digest读取其他仓库缓存的镜像层
+func (s *CacheService) BlobLinked(repository, digest string) bool {
+	var count int64
+	if err := s.db.Model(&model.CachedBlobLink{}).Where("digest = ? AND repository = ?", digest, repository).Count(&count).Error; err != nil {
+		return false
+	}
+	return count > 0
+}
+
+// LinkCachedBlobs 为升级前缓存的内容建立blob与仓库的关联，已有关联记录时跳过
+func (s *CacheService) LinkCachedBlobs() error {
+	var count int64
+	if err := s.db.Model(&model.CachedBlobLink{}).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+
+	var manifests []model.CachedManifest
+	if err := s.db.Select("repository", "digest").Distinct().Find(&manifests).Error; err != nil {
+		return err
+	}
+	for _, m := range manifests {
+		links := []string{m.Digest}
+		if content, err := s.readBlob(m.Digest); err == nil {
+			if parsed, err := ParseManifest(content); err == nil {
+				links = append(links, parsed.BlobReferences()...)
+			}
+		}
+		if err := s.LinkBlobs(m.Repository, links...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetManifest 获取已缓存的manifest及其内容
+func (s *CacheService) GetManifest(repository, reference string) (*model.CachedManifest, []byte, error) {
+	var manifest model.CachedManifest
+	if tx := s.db.Where("repository = ? AND reference = ?", repository, reference).Limit(1).Find(&manifest); tx.Error != nil || tx.RowsAffected == 0 {
+		return nil, nil, ErrCacheMiss
+	}
+
+	content, err := s.readBlob(manifest.Digest)
+	if err != nil {
+		s.db.Delete(&manifest)
+		return nil, nil, ErrCacheMiss
+	}
+
+	if time.Since(manifest.LastAccessAt) >= accessTouchInterval {
+		go func() {
+			s.db.Model(&model.CachedManifest{}).Where("id = ?", manifest.ID).UpdateColumn("last_access_at", time.Now())
+		}()
+	}
+	if blob, err := s.StatBlob(manifest.Digest); err == nil {
+		s.touchBlob(blob)
+	}
+
+	return &manifest, content, nil
+}
+
+// IsFresh 判断缓存的manifest是否仍在有效期内。digest引用和本地托管的manifest不会过期
+func (s *CacheService) IsFresh(manifest *model.CachedManifest) bool {
+	if manifest.Hosted || IsDigest(manifest.Reference) {
+		return true
+	}
+	return time.Since(manifest.UpdatedAt) < s.manifestTTL
+}
+
+// ManifestTTL 返回tag类manifest的缓存有效期
+func (s *CacheService) ManifestTTL() time.Duration {
+	return s.manifestTTL
+}
+
+// RenewManifest 上游确认tag的digest未变化后，重新开始计算已缓存manifest的有效期
+func (s *CacheService) RenewManifest(repository, reference string) error {
+	return s.db.Model(&model.CachedManifest{}).
+		Where("repository = ? AND reference = ? AND hosted = ?", repository, reference, false).
+		UpdateColumn("updated_at", time.Now()).Error
+}
+
+// markReferences 从给定manifest出发，标记所有可达的digest
+func (s *CacheService) markReferences(manifests []model.CachedManifest) map[string]bool {
+	marked := make(map[string]bool)
+	queue := make([]string, 0, len(manifests))
+	for _, m := range manifests {
+		queue = append(queue, m.Digest)
+	}
+
+	for len(queue) > 0 {
+		digest := queue[0]
+		queue = queue[1:]
+		if marked[digest] {
+			continue
+		}
+		marked[digest] = true
+
+		content, err := s.readBlob(digest)
+		if err != nil {
+			continue
+		}
+		parsed, err := ParseManifest(content)
+		if err != nil {
+			continue
+		}
+		for _, ref := range parsed.BlobReferences() {
+			marked[ref] = true
+		}
+		for _, child := range parsed.Manifests {
+			queue = append(queue, child.Digest)
+		}
+	}
+
+	return marked
+}
+
+// protectedDigests 返回不允许被淘汰的digest集合（本地托管仓库和固定缓存仓库引用的内容）
+func (s *CacheService) protectedDigests() (map[string]bool, error) {
+	policies, err := s.policyService.GetEnabledPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []model.CachedManifest
+	if err := s.db.Find(&manifests).Error; err != nil {
+		return nil, err
+	}
+
+	var protected []model.CachedManifest
+	for _, m := range manifests {
+		if m.Hosted {
+			protected = append(protected, m)
+			continue
+		}
+		if policy := MatchPolicy(policies, m.Repository); policy != nil && policy.Pinned {
+			protected = append(protected, m)
+		}
+	}
+	return s.markReferences(protected), nil
+}
+
+// deleteBlob 删除blob文件及记录，同时移除指向它的manifest引用和仓库关联
+func (s *CacheService) deleteBlob(blob *model.CachedBlob) error {
+	if err := os.Remove(s.blobPath(blob.Digest)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := s.db.Where("digest = ?", blob.Digest).Delete(&model.CachedManifest{}).Error; err != nil {
+		return err
+	}
+	if err := s.db.Where("digest = ?", blob.Digest).Delete(&model.CachedBlobLink{}).Error; err != nil {
+		return err
+	}
+	return s.db.Delete(blob).Error
+}
+
+// EvictResult 缓存淘汰结果
+type EvictResult struct {
+	BlobsEvicted   int   `json:"blobs_evicted"`
+	BytesReclaimed int64 `json:"bytes_reclaimed"`
+}
+
+// Evict 先执行缓存保留规则，再按最近最少使用原则淘汰缓存，直到各镜像源及全局大小都不超过上限
+func (s *CacheService) Evict() (*EvictResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := &EvictResult{}
+	if err := s.expireByPolicy(result); err != nil {
+		return nil, err
+	}
+
+	protected, err := s.protectedDigests()
+	if err != nil {
+		return nil, err
+	}
+
+	registries, err := s.registryService.GetAllRegistries()
+	if err != nil {
+		return nil, err
+	}
+	for _, registry := range registries {
+		if registry.CacheQuota <= 0 {
+			continue
+		}
+		if err := s.evictUntil(result, protected, registry.CacheQuota*megabyte, registry.URL); err != nil {
+			return result, err
+		}
+	}
+
+	if s.maxSize > 0 {
+		if err := s.evictUntil(result, protected, s.maxSize, ""); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// evictUntil 淘汰指定镜像源（为空表示全部）的blob，直到总大小不超过limit。
+// 仍被缓存manifest引用的blob不单独淘汰，而是在manifest被淘汰时连同只被它引用的blob一起删除
+func (s *CacheService) evictUntil(result *EvictResult, protected map[string]bool, limit int64, upstream string) error {
+	query := s.db.Model(&model.CachedBlob{})
+	if upstream != "" {
+		query = query.Where("upstream = ?", upstream)
+	}
+
+	var total int64
+	if err := query.Select("COALESCE(SUM(size), 0)").Scan(&total).Error; err != nil {
+		return err
+	}
+	if total <= limit {
+		return nil
+	}
+
+	var blobs []model.CachedBlob
+	if err := s.db.Order("last_access_at ASC").Find(&blobs).Error; err != nil {
+		return err
+	}
+	byDigest := make(map[string]*model.CachedBlob, len(blobs))
+	for i := range blobs {
+		byDigest[blobs[i].Digest] = &blobs[i]
+	}
+
+	graph, err := s.buildEvictionGraph(protected)
+	if err != nil {
+		return err
+	}
+
+	evicted := make(map[string]bool)
+	for i := range blobs {
+		if total <= limit {
+			break
+		}
+		digest := blobs[i].Digest
+		if protected[digest] || evicted[digest] || (upstream != "" && blobs[i].Upstream != upstream) {
+			continue
+		}
+
+		var victims []string
+		switch {
+		case graph.roots[digest] && graph.refs[digest] == 1:
+			victims = graph.release(digest)
+		case graph.refs[digest] > 0:
+			// 仍被缓存的manifest引用，随manifest一起淘汰
+			continue
+		default:
+			victims = []string{digest}
+		}
+
+		for _, victim := range victims {
+			blob, ok := byDigest[victim]
+			if !ok || protected[victim] || evicted[victim] {
+				continue
+			}
+			if err := s.deleteBlob(blob); err != nil {
+				log.Printf("Failed to evict blob %s: %v", blob.Digest, err)
+				continue
+			}
+			evicted[victim] = true
+			if upstream == "" || blob.Upstream == upstream {
+				total -= blob.Size
+			}
+			result.BlobsEvicted++
+			result.BytesReclaimed += blob.Size
+		}
+	}
+
+	return nil
+}
+
+// evictionGraph 缓存manifest之间的引用关系，用于按manifest整体淘汰
+type evictionGraph struct {
+	roots    map[string]bool             // 有仓库引用（tag或digest）指向、可以被淘汰的manifest
+	refs     map[string]int              // 每个digest可以从多少个根manifest（包括自身）到达
+	children map[string]manifestChildren // 已解析的manifest直接引用的内容
+	cache    *CacheService
+}
+
+// manifestChildren manifest直接引用的layer、config和子manifest
+type manifestChildren struct {
+	blobs     []string
+	manifests []string
+}
+
+// buildEvictionGraph 从所有未受保护的缓存manifest出发，统计每个digest的引用数
+func (s *CacheService) buildEvictionGraph(protected map[string]bool) (*evictionGraph, error) {
+	var digests []string
+	if err := s.db.Model(&model.CachedManifest{}).Distinct("digest").Pluck("digest", &digests).Error; err != nil {
+		return nil, err
+	}
+
+	graph := &evictionGraph{
+		roots:    make(map[string]bool),
+		refs:     make(map[string]int),
+		children: make(map[string]manifestChildren),
+		cache:    s,
+	}
+	for _, digest := range digests {
+		if protected[digest] {
+			continue
+		}
+		graph.roots[digest] = true
+		for _, ref := range graph.reachable(digest) {
+			graph.refs[ref]++
+		}
+	}
+	return graph, nil
+}
+
+// reachable 返回从manifest出发可以到达的所有digest，包括它自身
+func (g *evictionGraph) reachable(root string) []string {
+	seen := map[string]bool{root: true}
+	result := []string{root}
+	queue := []string{root}
+	for len(queue) > 0 {
+		digest := queue[0]
+		queue = queue[1:]
+
+		children, ok := g.children[digest]
+		if !ok {
+			children = g.cache.manifestChildren(digest)
+			g.children[digest] = children
+		}
+		for _, blob := range children.blobs {
+			if !seen[blob] {
+				seen[blob] = true
+				result = append(result, blob)
+			}
+		}
+		for _, child := range children.manifests {
+			if !seen[child] {
+				seen[child] = true
+				result = append(result, child)
+				queue = append(queue, child)
+			}
+		}
+	}
+	return result
+}
+
+// manifestChildren 读取manifest直接引用的内容，无法读取或不是manifest时返回空
+func (s *CacheService) manifestChildren(digest string) manifestChildren {
+	var children manifestChildren
+	content, err := s.readBlob(digest)
+	if err != nil {
+		return children
+	}
+	parsed, err := ParseManifest(content)
+	if err != nil {
+		return children
+	}
+	children.blobs = parsed.BlobReferences()
+	for _, child := range parsed.Manifests {
+		children.manifests = append(children.manifests, child.Digest)
+	}
+	return children
+}
+
+// release 移除一个根manifest，返回因此不再被任何缓存manifest引用的digest，包括它自身
+func (g *evictionGraph) release(root string) []string {
+	delete(g.roots, root)
+	var released []string
+	for _, digest := range g.reachable(root) {
+		g.refs[digest]--
+		if g.refs[digest] <= 0 {
+			delete(g.refs, digest)
+			released = append(released, digest)
+		}
+	}
+	return released
+}
+
+// GCResult 垃圾回收结果
+type GCResult struct {
+	DryRun           bool     `json:"dry_run"`
+	ManifestsScanned int      `json:"manifests_scanned"`
+	BlobsDeleted     int      `json:"blobs_deleted"`
+	BytesReclaimed   int64    `json:"bytes_reclaimed"`
+	Digests          []string `json:"digests"`
+}
+
+// GarbageCollect 标记-清除垃圾回收：删除本地托管内容中已没有任何manifest引用的blob，以及磁盘上没有数据库记录的孤立文件。
+// 代理缓存的blob由LRU淘汰和缓存策略管理，不在这里回收
+func (s *CacheService) GarbageCollect(dryRun bool) (*GCResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var manifests []model.CachedManifest
+	if err := s.db.Find(&manifests).Error; err != nil {
+		return nil, err
+	}
+
+	// 标记阶段
+	marked := s.markReferences(manifests)
+	result := &GCResult{DryRun: dryRun, ManifestsScanned: len(manifests), Digests: []string{}}
+	cutoff := time.Now().Add(-gcGracePeriod)
+
+	// 清除阶段：数据库中有记录的托管blob。标记时包含代理缓存的manifest，仍被它们引用的托管blob会保留
+	var blobs []model.CachedBlob
+	if err := s.db.Find(&blobs).Error; err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(blobs))
+	for i := range blobs {
+		known[blobs[i].Digest] = true
+		if blobs[i].Upstream != bundleUpstream || marked[blobs[i].Digest] || blobs[i].CreatedAt.After(cutoff) {
+			continue
+		}
+		if !dryRun {
+			if err := s.deleteBlob(&blobs[i]); err != nil {
+				log.Printf("Failed to delete blob %s: %v", blobs[i].Digest, err)
+				continue
+			}
+		}
+		result.BlobsDeleted++
+		result.BytesReclaimed += blobs[i].Size
+		result.Digests = append(result.Digests, blobs[i].Digest)
+	}
+
+	// 清除阶段：磁盘上没有数据库记录的孤立文件
+	blobRoot := filepath.Join(s.root, "blobs", "sha256")
+	err := filepath.WalkDir(blobRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		digest := "sha256:" + d.Name()
+		if known[digest] || marked[digest] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if !dryRun {
+			if err := os.Remove(path); err != nil {
+				return nil
+			}
+		}
+		result.BlobsDeleted++
+		result.BytesReclaimed += info.Size()
+		result.Digests = append(result.Digests, digest)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return result, err
+	}
+
+	// 清理中断的上传临时文件
+	if !dryRun {
+		s.cleanUploads(cutoff)
+	}
+
+	return result, nil
+}
+
+// cleanUploads 删除过期的临时上传文件
+func (s *CacheService) cleanUploads(cutoff time.Time) {
+	entries, err := os.ReadDir(filepath.Join(s.root, "uploads"))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && info.ModTime().Before(cutoff) {
+			os.Remove(filepath.Join(s.root, "uploads", entry.Name()))
+		}
+	}
+}
+
+// StartEvictor 启动后台淘汰任务
+func (s *CacheService) StartEvictor(interval time.Duration) {
+	if !s.enabled || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			result, err := s.Evict()
+			if err != nil {
+				log.Printf("Cache eviction failed: %v", err)
+				continue
+			}
+			if result.BlobsEvicted > 0 {
+				log.Printf("Cache eviction removed %d blobs, reclaimed %d bytes", result.BlobsEvicted, result.BytesReclaimed)
+			}
+		}
+	}()
+}
+
+// CacheStats 缓存统计信息
+type CacheStats struct {
+	Enabled   bool  `json:"enabled"`
+	Blobs     int64 `json:"blobs"`
+	Manifests int64 `json:"manifests"`
+	TotalSize int64 `json:"total_size"`
+	MaxSize   int64 `json:"max_size"`
+}
+
+// GetStats 获取缓存统计信息
+func (s *CacheService) GetStats() (*CacheStats, error) {
+	stats := &CacheStats{Enabled: s.enabled, MaxSize: s.maxSize}
+	if err := s.db.Model(&model.CachedBlob{}).Count(&stats.Blobs).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(&model.CachedManifest{}).Count(&stats.Manifests).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(&model.CachedBlob{}).Select("COALESCE(SUM(size), 0)").Scan(&stats.TotalSize).Error; err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,440 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+
+	"zmirror/internal/model"
+)
+
+// removeManifests 删除给定的manifest引用，并删除因此不再被任何manifest引用的blob
+func (s *CacheService) removeManifests(rows []model.CachedManifest) (*EvictResult, error) {
+	result := &EvictResult{}
+	if len(rows) == 0 {
+		return result, nil
+	}
+
+	candidates := s.markReferences(rows)
+	ids := make([]uint, 0, len(rows))
+	for _, m := range rows {
+		ids = append(ids, m.ID)
+	}
+	if err := s.db.Delete(&model.CachedManifest{}, ids).Error; err != nil {
+		return nil, err
+	}
+
+	var remaining []model.CachedManifest
+	if err := s.db.Find(&remaining).Error; err != nil {
+		return nil, err
+	}
+	inUse := s.markReferences(remaining)
+	for digest := range candidates {
+		if inUse[digest] {
+			continue
+		}
+		blob, err := s.StatBlob(digest)
+		if err != nil {
+			continue
+		}
+		if err := s.deleteBlob(blob); err != nil {
+			continue
+		}
+		result.BlobsEvicted++
+		result.BytesReclaimed += blob.Size
+	}
+
+	return result, nil
+}
+
+// blobSizes 返回所有已缓存blob的大小
+func (s *CacheService) blobSizes() (map[string]int64, error) {
+	var blobs []model.CachedBlob
+	if err := s.db.Select("digest", "size").Find(&blobs).Error; err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int64, len(blobs))
+	for _, b := range blobs {
+		sizes[b.Digest] = b.Size
+	}
+	return sizes, nil
+}
+
+// sumSizes 计算一组digest中已缓存部分的总大小
+func sumSizes(digests map[string]bool, sizes map[string]int64) int64 {
+	var total int64
+	for digest := range digests {
+		total += sizes[digest]
+	}
+	return total
+}
+
+// CachedRepository 已缓存仓库概览
+type CachedRepository struct {
+	Repository   string             `json:"repository"`
+	Tags         int                `json:"tags"`
+	Manifests    int                `json:"manifests"`
+	Size         int64              `json:"size"`
+	Upstreams    []string           `json:"upstreams"`
+	Hosted       bool               `json:"hosted"`
+	LastAccessAt time.Time          `json:"last_access_at"`
+	Policy       *model.CachePolicy `json:"policy"`
+}
+
+// GetRepositories 列出已缓存的仓库、大小、来源及其适用的缓存规则
+func (s *CacheService) GetRepositories() ([]CachedRepository, error) {
+	var manifests []model.CachedManifest
+	if err := s.db.Order("repository ASC").Find(&manifests).Error; err != nil {
+		return nil, err
+	}
+	policies, err := s.policyService.GetEnabledPolicies()
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := s.blobSizes()
+	if err != nil {
+		return nil, err
+	}
+
+	repositories := []CachedRepository{}
+	rowsByRepository := make(map[string][]model.CachedManifest)
+	for _, m := range manifests {
+		rows, ok := rowsByRepository[m.Repository]
+		if !ok {
+			repositories = append(repositories, CachedRepository{
+				Repository: m.Repository,
+				Upstreams:  []string{},
+				Policy:     MatchPolicy(policies, m.Repository),
+			})
+		}
+		rowsByRepository[m.Repository] = append(rows, m)
+	}
+
+	for i := range repositories {
+		repo := &repositories[i]
+		rows := rowsByRepository[repo.Repository]
+		upstreams := make(map[string]bool)
+		for _, m := range rows {
+			repo.Manifests++
+			if !IsDigest(m.Reference) {
+				repo.Tags++
+			}
+			if m.Hosted {
+				repo.Hosted = true
+			}
+			if m.LastAccessAt.After(repo.LastAccessAt) {
+				repo.LastAccessAt = m.LastAccessAt
+			}
+			if m.Upstream != "" && !upstreams[m.Upstream] {
+				upstreams[m.Upstream] = true
+				repo.Upstreams = append(repo.Upstreams, m.Upstream)
+			}
+		}
+		repo.Size = sumSizes(s.markReferences(rows), sizes)
+	}
+
+	return repositories, nil
+}
+
+// CachedReference 已缓存的tag或digest引用
+type CachedReference struct {
+	model.CachedManifest
+	Size int64 `json:"size"`
+}
+
+// GetManifests 列出仓库中已缓存的tag和digest
+func (s *CacheService) GetManifests(repository string) ([]CachedReference, error) {
+	var manifests []model.CachedManifest
+	if err := s.db.Where("repository = ?", repository).Order("updated_at DESC").Find(&manifests).Error; err != nil {
+		return nil, err
+	}
+	sizes, err := s.blobSizes()
+	if err != nil {
+		return nil, err
+	}
+
+	references := make([]CachedReference, 0, len(manifests))
+	for _, m := range manifests {
+		references = append(references, CachedReference{
+			CachedManifest: m,
+			Size:           sumSizes(s.markReferences([]model.CachedManifest{m}), sizes),
+		})
+	}
+	return references, nil
+}
+
+// GetTags 获取仓库中已缓存的tag列表
+func (s *CacheService) GetTags(repository string) ([]string, error) {
+	var manifests []model.CachedManifest
+	if err := s.db.Where("repository = ?", repository).Order("reference ASC").Find(&manifests).Error; err != nil {
+		return nil, err
+	}
+
+	tags := []string{}
+	for _, m := range manifests {
+		if !IsDigest(m.Reference) {
+			tags = append(tags, m.Reference)
+		}
+	}
+	return tags, nil
+}
+
+// GetHostedTags 获取仓库中本地托管的tag列表
+func (s *CacheService) GetHostedTags(repository string) ([]string, error) {
+	var manifests []model.CachedManifest
+	if err := s.db.Where("repository = ? AND hosted = ?", repository, true).Order("reference ASC").Find(&manifests).Error; err != nil {
+		return nil, err
+	}
+
+	tags := []string{}
+	for _, m := range manifests {
+		if !IsDigest(m.Reference) {
+			tags = append(tags, m.Reference)
+		}
+	}
+	return tags, nil
+}
+
+// CachedLayer 镜像层信息
+type CachedLayer struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"media_type"`
+	Size      int64  `json:"size"`
+	Cached    bool   `json:"cached"`
+}
+
+// CachedImagePlatform 单个平台的镜像详情
+type CachedImagePlatform struct {
+	Platform  *Platform         `json:"platform,omitempty"`
+	Digest    string            `json:"digest"`
+	MediaType string            `json:"media_type"`
+	Cached    bool              `json:"cached"`
+	Config    *Descriptor       `json:"config,omitempty"`
+	Created   string            `json:"created,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	History   []ImageHistory    `json:"history,omitempty"`
+	Layers    []CachedLayer     `json:"layers"`
+	Size      int64             `json:"size"`
+}
+
+// CachedImage 已缓存镜像的详情
+type CachedImage struct {
+	Repository   string                `json:"repository"`
+	Reference    string                `json:"reference"`
+	Digest       string                `json:"digest"`
+	MediaType    string                `json:"media_type"`
+	Upstream     string                `json:"upstream"`
+	Hosted       bool                  `json:"hosted"`
+	LastAccessAt time.Time             `json:"last_access_at"`
+	CachedAt     time.Time             `json:"cached_at"`
+	Platforms    []CachedImagePlatform `json:"platforms"`
+}
+
+// GetImage 获取已缓存镜像的manifest、配置和镜像层详情
+func (s *CacheService) GetImage(repository, reference string) (*CachedImage, error) {
+	var manifest model.CachedManifest
+	if tx := s.db.Where("repository = ? AND reference = ?", repository, reference).Limit(1).Find(&manifest); tx.Error != nil || tx.RowsAffected == 0 {
+		return nil, ErrCacheMiss
+	}
+	content, err := s.readBlob(manifest.Digest)
+	if err != nil {
+		return nil, ErrCacheMiss
+	}
+	parsed, err := ParseManifest(content)
+	if err != nil {
+		return nil, err
+	}
+
+	image := &CachedImage{
+		Repository:   manifest.Repository,
+		Reference:    manifest.Reference,
+		Digest:       manifest.Digest,
+		MediaType:    manifest.MediaType,
+		Upstream:     manifest.Upstream,
+		Hosted:       manifest.Hosted,
+		LastAccessAt: manifest.LastAccessAt,
+		CachedAt:     manifest.UpdatedAt,
+		Platforms:    []CachedImagePlatform{},
+	}
+
+	if !parsed.IsIndex() {
+		image.Platforms = append(image.Platforms, s.describePlatform(manifest.Digest, manifest.MediaType, nil, parsed))
+		return image, nil
+	}
+
+	for _, child := range parsed.Manifests {
+		var childManifest *Manifest
+		if childContent, err := s.readBlob(child.Digest); err == nil {
+			childManifest, _ = ParseManifest(childContent)
+		}
+		image.Platforms = append(image.Platforms, s.describePlatform(child.Digest, child.MediaType, child.Platform, childManifest))
+	}
+	return image, nil
+}
+
+// describePlatform 汇总单个平台manifest的配置和镜像层信息，manifest为nil表示未缓存
+func (s *CacheService) describePlatform(digest, mediaType string, platform *Platform, manifest *Manifest) CachedImagePlatform {
+	result := CachedImagePlatform{
+		Platform:  platform,
+		Digest:    digest,
+		MediaType: mediaType,
+		Cached:    manifest != nil,
+		Layers:    []CachedLayer{},
+	}
+	if manifest == nil {
+		return result
+	}
+
+	result.Config = manifest.Config
+	for _, layer := range manifest.Layers {
+		_, err := s.StatBlob(layer.Digest)
+		result.Layers = append(result.Layers, CachedLayer{
+			Digest:    layer.Digest,
+			MediaType: layer.MediaType,
+			Size:      layer.Size,
+			Cached:    err == nil,
+		})
+		result.Size += layer.Size
+	}
+
+	if manifest.Config == nil {
+		return result
+	}
+	configContent, err := s.readBlob(manifest.Config.Digest)
+	if err != nil {
+		return result
+	}
+	var config ImageConfig
+	if err := json.Unmarshal(configContent, &config); err != nil {
+		return result
+	}
+	if result.Platform == nil {
+		result.Platform = &Platform{Architecture: config.Architecture, OS: config.OS, Variant: config.Variant}
+	}
+	result.Created = config.Created
+	result.Labels = config.Config.Labels
+	result.History = config.History
+	return result
+}
+
+// PurgeRepository 清除整个仓库的缓存
+func (s *CacheService) PurgeRepository(repository string) (*EvictResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []model.CachedManifest
+	if err := s.db.Where("repository = ?", repository).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrCacheMiss
+	}
+	return s.removeManifests(rows)
+}
+
+// PurgeManifest 清除仓库中的一个tag或digest引用，下次拉取时将重新从上游获取
+func (s *CacheService) PurgeManifest(repository, reference string) (*EvictResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []model.CachedManifest
+	if err := s.db.Where("repository = ?", repository).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	var removed, keptTags, digests []model.CachedManifest
+	for _, m := range rows {
+		switch {
+		case m.Reference == reference:
+			removed = append(removed, m)
+		case IsDigest(m.Reference):
+			digests = append(digests, m)
+		default:
+			keptTags = append(keptTags, m)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, ErrCacheMiss
+	}
+
+	// 同时清除只能从被删除引用到达的digest引用（如多平台镜像的子manifest）
+	reachable := s.markReferences(keptTags)
+	fromRemoved := s.markReferences(removed)
+	for _, m := range digests {
+		if fromRemoved[m.Digest] && !reachable[m.Digest] {
+			removed = append(removed, m)
+		}
+	}
+
+	return s.removeManifests(removed)
+}
+
+// DeleteHostedManifest 删除本地托管仓库中的引用，返回被删除引用指向的manifest digest。
+// reference为tag时只删除该tag；为digest时删除该manifest及指向它的所有tag，
+// 只能从它到达的平台manifest一并删除，不再被引用的blob随即释放
+func (s *CacheService) DeleteHostedManifest(repository, reference string) (string, *EvictResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []model.CachedManifest
+	if err := s.db.Where("repository = ? AND hosted = ?", repository, true).Find(&rows).Error; err != nil {
+		return "", nil, err
+	}
+
+	var target *model.CachedManifest
+	for i := range rows {
+		if rows[i].Reference == reference {
+			target = &rows[i]
+			break
+		}
+	}
+	if target == nil {
+		return "", nil, ErrCacheMiss
+	}
+	if !IsDigest(reference) {
+		result, err := s.removeManifests([]model.CachedManifest{*target})
+		return target.Digest, result, err
+	}
+
+	var removed, keptTags, digests []model.CachedManifest
+	for _, m := range rows {
+		switch {
+		case m.Digest == reference:
+			removed = append(removed, m)
+		case IsDigest(m.Reference):
+			digests = append(digests, m)
+		default:
+			keptTags = append(keptTags, m)
+		}
+	}
+
+	reachable := s.markReferences(keptTags)
+	fromRemoved := s.markReferences(removed)
+	for _, m := range digests {
+		if fromRemoved[m.Digest] && !reachable[m.Digest] {
+			removed = append(removed, m)
+		}
+	}
+
+	result, err := s.removeManifests(removed)
+	return reference, result, err
+}
+
+// InvalidateManifest 移除代理缓存中的一个tag引用，如推送新版本后，下次拉取时重新从上游获取
+func (s *CacheService) InvalidateManifest(repository, reference string) error {
+	return s.db.Where("repository = ? AND reference = ? AND hosted = ?", repository, reference, false).Delete(&model.CachedManifest{}).Error
+}
+
+// PurgeBlob 按digest清除一个blob，指向它的manifest引用也会被移除
+func (s *CacheService) PurgeBlob(digest string) (*EvictResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, err := s.StatBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.deleteBlob(blob); err != nil {
+		return nil, err
+	}
+	return &EvictResult{BlobsEvicted: 1, BytesReclaimed: blob.Size}, nil
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"sort"
+	"time"
+
+	"zmirror/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type CachePolicyService struct {
+	db *gorm.DB
+}
+
+func NewCachePolicyService(db *gorm.DB) *CachePolicyService {
+	return &CachePolicyService{db: db}
+}
+
+// GetAllPolicies 获取所有缓存规则，按优先级排序
+func (s *CachePolicyService) GetAllPolicies() ([]model.CachePolicy, error) {
+	var policies []model.CachePolicy
+	err := s.db.Order("priority ASC, id ASC").Find(&policies).Error
+	return policies, err
+}
+
+// GetEnabledPolicies 获取启用的缓存规则，按优先级排序
+func (s *CachePolicyService) GetEnabledPolicies() ([]model.CachePolicy, error) {
+	var policies []model.CachePolicy
+	err := s.db.Where("enabled = ?", true).Order("priority ASC, id ASC").Find(&policies).Error
+	return policies, err
+}
+
+// CreatePolicy 创建缓存规则
+func (s *CachePolicyService) CreatePolicy(policy *model.CachePolicy) error {
+	return s.db.Create(policy).Error
+}
+
+// UpdatePolicy 更新缓存规则
+func (s *CachePolicyService) UpdatePolicy(policy *model.CachePolicy) error {
+	return s.db.Save(policy).Error
+}
+
+// DeletePolicy 删除缓存规则
+func (s *CachePolicyService) DeletePolicy(id uint) error {
+	return s.db.Delete(&model.CachePolicy{}, id).Error
+}
+
+// MatchPolicy 返回第一个匹配仓库的规则，policies需已按优先级排序
+func MatchPolicy(policies []model.CachePolicy, repository string) *model.CachePolicy {
+	for i := range policies {
+		if MatchRepository(policies[i].Pattern, repository) {
+			return &policies[i]
+		}
+	}
+	return nil
+}
+
+// expireByPolicy 删除超过规则保留时间或版本数的manifest，以及因此不再被引用的blob
+func (s *CacheService) expireByPolicy(result *EvictResult) error {
+	policies, err := s.policyService.GetEnabledPolicies()
+	if err != nil || len(policies) == 0 {
+		return err
+	}
+
+	var manifests []model.CachedManifest
+	if err := s.db.Where("hosted = ?", false).Find(&manifests).Error; err != nil {
+		return err
+	}
+	byRepository := make(map[string][]model.CachedManifest)
+	for _, m := range manifests {
+		byRepository[m.Repository] = append(byRepository[m.Repository], m)
+	}
+
+	var expired []model.CachedManifest
+	for repository, rows := range byRepository {
+		policy := MatchPolicy(policies, repository)
+		if policy == nil || policy.Pinned {
+			continue
+		}
+		expired = append(expired, s.policyExpired(policy, rows)...)
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	removed, err := s.removeManifests(expired)
+	if err != nil {
+		return err
+	}
+	result.BlobsEvicted += removed.BlobsEvicted
+	result.BytesReclaimed += removed.BytesReclaimed
+	return nil
+}
+
+// policyExpired 计算一个仓库中按规则应过期的manifest引用
+func (s *CacheService) policyExpired(policy *model.CachePolicy, rows []model.CachedManifest) []model.CachedManifest {
+	var tags, digests []model.CachedManifest
+	for _, m := range rows {
+		if IsDigest(m.Reference) {
+			digests = append(digests, m)
+		} else {
+			tags = append(tags, m)
+		}
+	}
+
+	// 新拉取的tag排在前面
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].UpdatedAt.After(tags[j].UpdatedAt)
+	})
+
+	cutoff := time.Now().Add(-time.Duration(policy.MaxAge) * time.Hour)
+	tooOld := func(m model.CachedManifest) bool {
+		return policy.MaxAge > 0 && m.UpdatedAt.Before(cutoff)
+	}
+
+	var kept, expired []model.CachedManifest
+	for i, tag := range tags {
+		if (policy.MaxVersions > 0 && i >= policy.MaxVersions) || tooOld(tag) {
+			expired = append(expired, tag)
+		} else {
+			kept = append(kept, tag)
+		}
+	}
+
+	// digest引用只要仍能从保留的tag到达就保留
+	reachable := s.markReferences(kept)
+	fromExpired := s.markReferences(expired)
+	for _, m := range digests {
+		if reachable[m.Digest] {
+			continue
+		}
+		if fromExpired[m.Digest] || tooOld(m) {
+			expired = append(expired, m)
+		}
+	}
+
+	return expired
+}
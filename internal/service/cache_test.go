@@ -0,0 +1,63 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"zmirror/internal/model"
+)
+
+func TestGarbageCollect(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream string
+		// referencedBy 引用该blob的manifest来源，空表示没有manifest引用
+		referencedBy string
+		age          time.Duration
+		wantDeleted  bool
+	}{
+		{"unreferenced hosted blob", bundleUpstream, "", 2 * gcGracePeriod, true},
+		{"unreferenced hosted blob within grace period", bundleUpstream, "", 0, false},
+		{"hosted blob referenced by hosted manifest", bundleUpstream, bundleUpstream, 2 * gcGracePeriod, false},
+		{"hosted blob referenced by proxied manifest", bundleUpstream, "https://registry-1.docker.io", 2 * gcGracePeriod, false},
+		{"unreferenced proxied blob", "https://registry-1.docker.io", "", 2 * gcGracePeriod, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			cacheService := NewCacheService(db, NewRegistryService(db), NewCachePolicyService(db), true, t.TempDir(), 0, time.Minute)
+
+			digest, err := cacheService.PutBlob([]byte("layer"), "application/octet-stream", tt.upstream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.referencedBy != "" {
+				content := fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":%q,"size":5},"layers":[]}`, digest)
+				if _, err := cacheService.PutManifest("team/app", "1.0", []byte(content), "application/vnd.oci.image.manifest.v1+json", tt.referencedBy, tt.referencedBy == bundleUpstream); err != nil {
+					t.Fatal(err)
+				}
+			}
+			db.Model(&model.CachedBlob{}).Where("digest = ?", digest).UpdateColumn("created_at", time.Now().Add(-tt.age))
+
+			for _, dryRun := range []bool{true, false} {
+				result, err := cacheService.GarbageCollect(dryRun)
+				if err != nil {
+					t.Fatal(err)
+				}
+				deleted := false
+				for _, d := range result.Digests {
+					deleted = deleted || d == digest
+				}
+				if deleted != tt.wantDeleted {
+					t.Errorf("GarbageCollect(dryRun=%v) deleted = %v, want %v", dryRun, deleted, tt.wantDeleted)
+				}
+			}
+
+			_, err = cacheService.StatBlob(digest)
+			if cached := err == nil; cached == tt.wantDeleted {
+				t.Errorf("blob cached after GC = %v, want %v", cached, !tt.wantDeleted)
+			}
+		})
+	}
+}
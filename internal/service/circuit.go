@@ -0,0 +1,137 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// circuitFailureThreshold 连续失败多少次后断开镜像源
+	circuitFailureThreshold = 3
+
+	// circuitOpenDuration 断开后多久允许再次尝试
+	circuitOpenDuration = 30 * time.Second
+
+	// circuitProbeTimeout 试探请求超过这个时间仍未报告结果时，允许另一个请求重新试探
+	circuitProbeTimeout = circuitOpenDuration
+)
+
+// ErrOffline 离线模式下不访问上游
+var ErrOffline = errors.New("offline mode: upstream registries are not contacted")
+
+// circuitState 单个镜像源的熔断状态
+type circuitState struct {
+	failures   int
+	openUntil  time.Time
+	probeStart time.Time // 半开状态下正在进行的试探请求的开始时间，为零表示没有试探请求
+}
+
+// probing 判断是否有尚未报告结果的试探请求
+func (s *circuitState) probing(now time.Time) bool {
+	return !s.probeStart.IsZero() && now.Sub(s.probeStart) < circuitProbeTimeout
+}
+
+// CircuitStatus 镜像源熔断状态
+type CircuitStatus struct {
+	URL       string     `json:"url"`
+	State     string     `json:"state"` // closed、open、half-open
+	Failures  int        `json:"failures"`
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+}
+
+// circuitBreaker 按镜像源记录连续失败次数
+type circuitBreaker struct {
+	mu     sync.Mutex
+	states map[string]*circuitState
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{states: make(map[string]*circuitState)}
+}
+
+// allow 判断是否可以请求该镜像源。断开期满后进入半开状态，只放行一个试探请求，
+// 试探请求报告成功或失败之前其他请求直接跳过该镜像源
+func (b *circuitBreaker) allow(url string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.states[url]
+	if !ok || state.failures < circuitFailureThreshold {
+		return true
+	}
+	now := time.Now()
+	if now.Before(state.openUntil) || state.probing(now) {
+		return false
+	}
+	state.probeStart = now
+	return true
+}
+
+// success 记录一次成功请求，断开的镜像源恢复
+func (b *circuitBreaker) success(url string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if state, ok := b.states[url]; ok && state.failures >= circuitFailureThreshold {
+		log.Printf("Circuit for %s closed: registry recovered", url)
+	}
+	delete(b.states, url)
+}
+
+// failure 记录一次失败请求，达到阈值后断开
+func (b *circuitBreaker) failure(url string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.states[url]
+	if !ok {
+		state = &circuitState{}
+		b.states[url] = state
+	}
+	state.failures++
+	state.probeStart = time.Time{}
+	if state.failures >= circuitFailureThreshold {
+		// 首次达到阈值或试探请求失败时断开，断开期间的失败不重复记录
+		if state.failures == circuitFailureThreshold || time.Now().After(state.openUntil) {
+			log.Printf("Circuit for %s opened after %d consecutive failures", url, state.failures)
+		}
+		state.openUntil = time.Now().Add(circuitOpenDuration)
+	}
+}
+
+// status 返回镜像源的熔断状态
+func (b *circuitBreaker) status(url string) CircuitStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := CircuitStatus{URL: url, State: "closed"}
+	state, ok := b.states[url]
+	if !ok {
+		return status
+	}
+	status.Failures = state.failures
+	if state.failures >= circuitFailureThreshold {
+		openUntil := state.openUntil
+		status.OpenUntil = &openUntil
+		if time.Now().After(state.openUntil) {
+			status.State = "half-open"
+		} else {
+			status.State = "open"
+		}
+	}
+	return status
+}
+
+// isOpen 判断镜像源是否不接受新请求：处于断开状态，或处于半开状态且试探请求尚未返回
+func (b *circuitBreaker) isOpen(url string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.states[url]
+	if !ok || state.failures < circuitFailureThreshold {
+		return false
+	}
+	now := time.Now()
+	return now.Before(state.openUntil) || state.probing(now)
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerHalfOpenProbe(t *testing.T) {
+	const url = "https://registry.example.com"
+
+	tests := []struct {
+		name string
+		// report 报告试探请求的结果
+		report    func(b *circuitBreaker)
+		wantAllow bool
+		wantState string
+	}{
+		{"probe succeeds", func(b *circuitBreaker) { b.success(url) }, true, "closed"},
+		{"probe fails", func(b *circuitBreaker) { b.failure(url) }, false, "open"},
+		{"probe never reports", func(b *circuitBreaker) {
+			b.states[url].probeStart = time.Now().Add(-circuitProbeTimeout - time.Second)
+		}, true, "half-open"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newCircuitBreaker()
+			for i := 0; i < circuitFailureThreshold; i++ {
+				b.failure(url)
+			}
+			if b.allow(url) || !b.isOpen(url) {
+				t.Fatal("circuit should be open after consecutive failures")
+			}
+
+			// 断开期满后只放行一个试探请求
+			b.states[url].openUntil = time.Now().Add(-time.Second)
+			if !b.allow(url) {
+				t.Fatal("first request after the open period should be allowed as a probe")
+			}
+			for i := 0; i < 3; i++ {
+				if b.allow(url) {
+					t.Fatal("concurrent request allowed while the probe is in flight")
+				}
+			}
+			if !b.isOpen(url) {
+				t.Error("circuit should not accept requests while the probe is in flight")
+			}
+			if got := b.status(url).State; got != "half-open" {
+				t.Errorf("state during probe = %q, want half-open", got)
+			}
+
+			tt.report(b)
+			if got := b.status(url).State; got != tt.wantState {
+				t.Errorf("state after probe = %q, want %q", got, tt.wantState)
+			}
+			if got := b.allow(url); got != tt.wantAllow {
+				t.Errorf("allow() after probe = %v, want %v", got, tt.wantAllow)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"zmirror/internal/model"
+)
+
+// credentialCache 缓存最近验证成功的用户名和密码，拉取镜像时每一层都会带上Basic认证，
+// 缓存后一次拉取只需要做一次慢哈希校验。键是凭据的HMAC，HMAC密钥在启动时随机生成，
+// 内存中不保存明文密码，也不能离线比对
+type credentialCache struct {
+	key  []byte
+	ttl  time.Duration
+	size int
+
+	mu         sync.Mutex
+	entries    map[string]credentialEntry
+	generation uint64 // 每次失效加一，丢弃失效前开始的验证结果
+}
+
+type credentialEntry struct {
+	user      model.User
+	expiresAt time.Time
+}
+
+func newCredentialCache(ttl time.Duration, size int) (*credentialCache, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generate credential cache key: %w", err)
+	}
+	return &credentialCache{
+		key:     key,
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]credentialEntry),
+	}, nil
+}
+
+// cacheKey 计算凭据的HMAC，用户名和密码之间用NUL分隔避免拼接歧义
+func (c *credentialCache) cacheKey(username, password string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(username))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// get 返回缓存中未过期的用户副本，以及当前的失效代数，未命中时调用方验证成功后用它写入缓存。
+// 缓存未启用时总是未命中
+func (c *credentialCache) get(username, password string) (*model.User, uint64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+	key := c.cacheKey(username, password)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, c.generation, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, c.generation, false
+	}
+	user := entry.user
+	return &user, c.generation, true
+}
+
+// put 缓存一次验证成功的凭据。验证期间发生过失效（如密码被修改）时不写入，避免旧密码重新进入缓存；
+// 缓存已满时先清理过期条目，仍然满时淘汰最早过期的条目
+func (c *credentialCache) put(username, password string, user *model.User, generation uint64) {
+	if c == nil {
+		return
+	}
+	key := c.cacheKey(username, password)
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		var oldest string
+		for k, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, k)
+				continue
+			}
+			if oldest == "" || entry.expiresAt.Before(c.entries[oldest].expiresAt) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= c.size {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[key] = credentialEntry{user: *user, expiresAt: now.Add(c.ttl)}
+}
+
+// invalidateUser 移除某个用户的所有缓存凭据，在修改密码、权限或删除用户后调用
+func (c *credentialCache) invalidateUser(id uint) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for k, entry := range c.entries {
+		if entry.user.ID == id {
+			delete(c.entries, k)
+		}
+	}
+}
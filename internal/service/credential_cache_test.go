@@ -0,0 +1,217 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"zmirror/internal/model"
+)
+
+// newTestCredentialCache 创建凭据缓存
+func newTestCredentialCache(t *testing.T, ttl time.Duration, size int) *credentialCache {
+	t.Helper()
+	cache, err := newCredentialCache(ttl, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+func TestCredentialCacheGet(t *testing.T) {
+	cache := newTestCredentialCache(t, time.Minute, 10)
+	_, generation, _ := cache.get("alice", "secret")
+	cache.put("alice", "secret", &model.User{ID: 1, Username: "alice"}, generation)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		hit      bool
+	}{
+		{"same credentials", "alice", "secret", true},
+		{"wrong password", "alice", "wrong", false},
+		{"other user", "bob", "secret", false},
+		// 用户名和密码之间有分隔符，拼接结果相同也不会命中
+		{"shifted boundary", "alices", "ecret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, _, hit := cache.get(tt.username, tt.password)
+			if hit != tt.hit {
+				t.Fatalf("get() hit = %v, want %v", hit, tt.hit)
+			}
+			if hit && user.Username != "alice" {
+				t.Errorf("get() user = %q, want alice", user.Username)
+			}
+		})
+	}
+}
+
+func TestCredentialCacheExpiryAndCapacity(t *testing.T) {
+	t.Run("expired entry misses", func(t *testing.T) {
+		cache := newTestCredentialCache(t, time.Millisecond, 10)
+		cache.put("alice", "secret", &model.User{ID: 1}, 0)
+		time.Sleep(5 * time.Millisecond)
+		if _, _, hit := cache.get("alice", "secret"); hit {
+			t.Error("expired entry was returned")
+		}
+	})
+
+	t.Run("full cache evicts the oldest entry", func(t *testing.T) {
+		cache := newTestCredentialCache(t, time.Minute, 2)
+		cache.put("a", "p", &model.User{ID: 1}, 0)
+		time.Sleep(time.Millisecond)
+		cache.put("b", "p", &model.User{ID: 2}, 0)
+		cache.put("c", "p", &model.User{ID: 3}, 0)
+		if len(cache.entries) != 2 {
+			t.Fatalf("cache holds %d entries, want 2", len(cache.entries))
+		}
+		if _, _, hit := cache.get("a", "p"); hit {
+			t.Error("oldest entry was not evicted")
+		}
+	})
+
+	t.Run("result started before invalidation is dropped", func(t *testing.T) {
+		cache := newTestCredentialCache(t, time.Minute, 10)
+		_, generation, _ := cache.get("alice", "secret")
+		cache.invalidateUser(1)
+		cache.put("alice", "secret", &model.User{ID: 1}, generation)
+		if _, _, hit := cache.get("alice", "secret"); hit {
+			t.Error("stale verification result was cached")
+		}
+	})
+
+	t.Run("disabled cache never hits", func(t *testing.T) {
+		var cache *credentialCache
+		cache.put("alice", "secret", &model.User{ID: 1}, 0)
+		cache.invalidateUser(1)
+		if _, _, hit := cache.get("alice", "secret"); hit {
+			t.Error("nil cache returned an entry")
+		}
+	})
+}
+
+func TestCredentialCacheInvalidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(s *UserService, id uint) error
+		// 修改后用原密码登录的预期结果
+		wantErr error
+		check   func(t *testing.T, user *model.User)
+	}{
+		{
+			name:    "UpdatePassword",
+			change:  func(s *UserService, id uint) error { return s.UpdatePassword(id, "changed") },
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:    "DeleteUser",
+			change:  func(s *UserService, id uint) error { return s.DeleteUser(id) },
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:   "UpdatePermissions",
+			change: func(s *UserService, id uint) error { return s.UpdatePermissions(id, true, true) },
+			check: func(t *testing.T, user *model.User) {
+				if !user.CanPush || !user.CanDelete {
+					t.Errorf("cached user still has old permissions: push=%v delete=%v", user.CanPush, user.CanDelete)
+				}
+			},
+		},
+		{
+			name:   "UpdateRole",
+			change: func(s *UserService, id uint) error { return s.UpdateRole(id, RoleOperator) },
+			check: func(t *testing.T, user *model.User) {
+				if user.Role != RoleOperator || !user.IsAdmin {
+					t.Errorf("cached user still has old role %q", user.Role)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			userService := newTestUserService(t, db)
+			if err := userService.SetCredentialCache(time.Minute, 100); err != nil {
+				t.Fatal(err)
+			}
+
+			// 保留一个超级管理员，删除和降级时不受最后一个超级管理员的限制
+			if err := userService.CreateUser(&model.User{Username: "root", Password: "root", Role: RoleSuperAdmin}); err != nil {
+				t.Fatal(err)
+			}
+			alice := &model.User{Username: "alice", Password: "secret"}
+			if err := userService.CreateUser(alice); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := userService.AuthenticateUser("alice", "secret"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.change(userService, alice.ID); err != nil {
+				t.Fatal(err)
+			}
+			user, err := userService.AuthenticateUser("alice", "secret")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AuthenticateUser() after %s error = %v, want %v", tt.name, err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t, user)
+			}
+		})
+	}
+}
+
+func TestAuthenticateUserUsesCredentialCache(t *testing.T) {
+	db := newTestDB(t)
+	userService := newTestUserService(t, db)
+	if err := userService.SetCredentialCache(time.Minute, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &model.User{Username: "alice", Password: "secret"}
+	if err := userService.CreateUser(alice); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userService.AuthenticateUser("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 绕过UserService直接清空哈希，之后还能登录说明结果来自缓存
+	if err := db.Model(&model.User{}).Where("id = ?", alice.ID).UpdateColumn("password", "").Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userService.AuthenticateUser("alice", "secret"); err != nil {
+		t.Errorf("cached credentials were not used: %v", err)
+	}
+	if _, err := userService.AuthenticateUser("alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestUpdatePasswordAcceptsNewPassword(t *testing.T) {
+	db := newTestDB(t)
+	userService := newTestUserService(t, db)
+	if err := userService.SetCredentialCache(time.Minute, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &model.User{Username: "alice", Password: "secret"}
+	if err := userService.CreateUser(alice); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userService.AuthenticateUser("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := userService.UpdatePassword(alice.ID, "changed"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := userService.AuthenticateUser("alice", "secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("old password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if _, err := userService.AuthenticateUser("alice", "changed"); err != nil {
+		t.Errorf("new password rejected: %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"zmirror/internal/model"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testPasswordParams 测试使用的低开销argon2id参数
+var testPasswordParams = PasswordParams{Memory: 64, Iterations: 1, Parallelism: 1}
+
+// newTestDB 在临时目录中创建已迁移表结构的数据库
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := model.InitDatabase(db); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+	return db
+}
+
+// newTestUserService 创建使用低开销密码参数的用户服务
+func newTestUserService(t *testing.T, db *gorm.DB) *UserService {
+	t.Helper()
+	userService := NewUserService(db)
+	userService.SetPasswordParams(testPasswordParams)
+	return userService
+}
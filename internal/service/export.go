@@ -0,0 +1,267 @@
+package service
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// 导出格式
+const (
+	ExportFormatDocker = "docker" // docker load 兼容，包含manifest.json
+	ExportFormatOCI    = "oci"    // OCI镜像布局
+)
+
+// ociIndexMediaType OCI index媒体类型
+const ociIndexMediaType = "application/vnd.oci.image.index.v1+json"
+
+// ImageExport 准备导出的单平台镜像
+type ImageExport struct {
+	Repository string
+	Reference  string
+	RepoTag    string // docker load 后使用的镜像名，按digest导出时为空
+	Platform   *Platform
+	Manifest   *FetchedManifest
+}
+
+// FileName 导出文件名
+func (e *ImageExport) FileName() string {
+	name := e.RepoTag
+	if name == "" {
+		name = e.Repository + "@" + e.Manifest.Digest
+	}
+	replacer := strings.NewReplacer("/", "_", ":", "_", "@", "_")
+	return replacer.Replace(name) + ".tar"
+}
+
+// PrepareExport 解析镜像引用并获取指定平台的manifest，在开始写入tar流之前完成所有可能失败的检查
+func (s *ImageService) PrepareExport(ref, platform string) (*ImageExport, error) {
+	repository, reference, err := ParseImageReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	if platform == "" {
+		platform = "linux/amd64"
+	}
+	wanted, err := ParsePlatform(platform)
+	if err != nil {
+		return nil, err
+	}
+
+	fetched, err := s.FetchManifest(repository, reference)
+	if err != nil {
+		return nil, err
+	}
+	if fetched.Manifest.IsIndex() {
+		child, err := SelectPlatform(fetched.Manifest, wanted)
+		if err != nil {
+			return nil, err
+		}
+		fetched, err = s.FetchManifest(repository, child.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if fetched.MediaType == "" {
+			fetched.MediaType = child.MediaType
+		}
+	}
+	if fetched.Manifest.Config == nil {
+		return nil, fmt.Errorf("unsupported manifest type: %s", fetched.MediaType)
+	}
+
+	export := &ImageExport{
+		Repository: repository,
+		Reference:  reference,
+		Platform:   wanted,
+		Manifest:   fetched,
+	}
+	if !IsDigest(reference) {
+		name := strings.TrimSpace(ref)
+		name = strings.TrimSuffix(name, ":"+reference)
+		export.RepoTag = name + ":" + reference
+	}
+	return export, nil
+}
+
+// WriteExport 将镜像以tar流写出。两种格式都使用OCI镜像布局，docker格式额外包含manifest.json
+func (s *ImageService) WriteExport(w io.Writer, export *ImageExport, format string) error {
+	layout := newLayoutWriter(s, w)
+	if err := layout.writeImage(export.Repository, export.Manifest); err != nil {
+		return err
+	}
+
+	descriptor := Descriptor{
+		MediaType: export.Manifest.MediaType,
+		Digest:    export.Manifest.Digest,
+		Size:      int64(len(export.Manifest.Content)),
+		Platform:  export.Platform,
+	}
+	if export.RepoTag != "" {
+		descriptor.Annotations = map[string]string{
+			"io.containerd.image.name":          export.RepoTag,
+			"org.opencontainers.image.ref.name": export.Reference,
+		}
+	}
+
+	if format == ExportFormatDocker {
+		entry := dockerManifestEntry{
+			Config:   blobFileName(export.Manifest.Manifest.Config.Digest),
+			RepoTags: []string{},
+			Layers:   []string{},
+		}
+		if export.RepoTag != "" {
+			entry.RepoTags = append(entry.RepoTags, export.RepoTag)
+		}
+		for _, layer := range export.Manifest.Manifest.Layers {
+			entry.Layers = append(entry.Layers, blobFileName(layer.Digest))
+		}
+		if err := layout.writeJSON("manifest.json", []dockerManifestEntry{entry}); err != nil {
+			return err
+		}
+	}
+
+	return layout.close([]Descriptor{descriptor})
+}
+
+// dockerManifestEntry docker load 使用的manifest.json条目
+type dockerManifestEntry struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// blobFileName blob在镜像布局中的路径
+func blobFileName(digest string) string {
+	return "blobs/sha256/" + strings.TrimPrefix(digest, "sha256:")
+}
+
+// layoutWriter 以OCI镜像布局写入tar流，blob按digest去重
+type layoutWriter struct {
+	images  *ImageService
+	tw      *tar.Writer
+	written map[string]bool
+	modTime time.Time
+}
+
+func newLayoutWriter(images *ImageService, w io.Writer) *layoutWriter {
+	return &layoutWriter{
+		images:  images,
+		tw:      tar.NewWriter(w),
+		written: make(map[string]bool),
+		modTime: time.Now(),
+	}
+}
+
+// writeHeader 写入一个普通文件的tar头
+func (l *layoutWriter) writeHeader(name string, size int64) error {
+	return l.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  l.modTime,
+	})
+}
+
+// writeFile 写入一个文件
+func (l *layoutWriter) writeFile(name string, content []byte) error {
+	if err := l.writeHeader(name, int64(len(content))); err != nil {
+		return err
+	}
+	_, err := l.tw.Write(content)
+	return err
+}
+
+// writeJSON 写入一个JSON文件
+func (l *layoutWriter) writeJSON(name string, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return l.writeFile(name, content)
+}
+
+// writeBlobContent 写入已在内存中的blob，如manifest
+func (l *layoutWriter) writeBlobContent(digest string, content []byte) error {
+	if l.written[digest] {
+		return nil
+	}
+	if err := l.writeFile(blobFileName(digest), content); err != nil {
+		return err
+	}
+	l.written[digest] = true
+	return nil
+}
+
+// writeBlob 从缓存或上游读取blob写入tar流，同时校验大小和digest
+func (l *layoutWriter) writeBlob(repository string, desc Descriptor) error {
+	if l.written[desc.Digest] {
+		return nil
+	}
+	if !IsDigest(desc.Digest) {
+		return fmt.Errorf("unsupported digest: %s", desc.Digest)
+	}
+
+	rc, err := l.images.OpenBlob(repository, desc.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to fetch blob %s: %v", desc.Digest, err)
+	}
+	defer rc.Close()
+
+	if err := l.writeHeader(blobFileName(desc.Digest), desc.Size); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(l.tw, io.TeeReader(rc, h), desc.Size); err != nil {
+		return fmt.Errorf("failed to copy blob %s: %v", desc.Digest, err)
+	}
+	// 读到EOF，确认大小一致，同时让上游blob完整写入缓存
+	if n, _ := io.Copy(io.Discard, rc); n > 0 {
+		return fmt.Errorf("blob %s is larger than %d bytes", desc.Digest, desc.Size)
+	}
+	if actual := "sha256:" + hex.EncodeToString(h.Sum(nil)); actual != desc.Digest {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", desc.Digest, actual)
+	}
+
+	l.written[desc.Digest] = true
+	return nil
+}
+
+// writeImage 写入单平台manifest及其配置和镜像层
+func (l *layoutWriter) writeImage(repository string, m *FetchedManifest) error {
+	if err := l.writeBlobContent(m.Digest, m.Content); err != nil {
+		return err
+	}
+	if m.Manifest.Config != nil {
+		if err := l.writeBlob(repository, *m.Manifest.Config); err != nil {
+			return err
+		}
+	}
+	for _, layer := range m.Manifest.Layers {
+		if err := l.writeBlob(repository, layer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close 写入oci-layout和index.json并结束tar流
+func (l *layoutWriter) close(manifests []Descriptor) error {
+	if err := l.writeJSON("oci-layout", map[string]string{"imageLayoutVersion": "1.0.0"}); err != nil {
+		return err
+	}
+	index := Manifest{
+		SchemaVersion: 2,
+		MediaType:     ociIndexMediaType,
+		Manifests:     manifests,
+	}
+	if err := l.writeJSON("index.json", index); err != nil {
+		return err
+	}
+	return l.tw.Close()
+}
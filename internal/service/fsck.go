@@ -0,0 +1,220 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"zmirror/internal/model"
+)
+
+// ErrFsckRunning 已有完整性检查正在运行
+var ErrFsckRunning = errors.New("fsck is already running")
+
+// FsckIssue 完整性检查发现的问题
+type FsckIssue struct {
+	Problem    string `json:"problem"` // digest_mismatch、missing_file、missing_reference
+	Digest     string `json:"digest"`
+	Repository string `json:"repository,omitempty"`
+	Reference  string `json:"reference,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+// FsckReport 完整性检查报告
+type FsckReport struct {
+	StartedAt         time.Time   `json:"started_at"`
+	FinishedAt        time.Time   `json:"finished_at"`
+	BlobsChecked      int         `json:"blobs_checked"`
+	BytesChecked      int64       `json:"bytes_checked"`
+	ManifestsChecked  int         `json:"manifests_checked"`
+	Corrupted         int         `json:"corrupted"`
+	MissingFiles      int         `json:"missing_files"`
+	MissingReferences int         `json:"missing_references"`
+	Issues            []FsckIssue `json:"issues"`
+}
+
+// FsckStatus 完整性检查状态
+type FsckStatus struct {
+	Running    bool        `json:"running"`
+	LastReport *FsckReport `json:"last_report"`
+}
+
+// fsckState 记录完整性检查的运行状态和最近一次报告
+type fsckState struct {
+	mu         sync.Mutex
+	running    bool
+	lastReport *FsckReport
+}
+
+// GetFsckStatus 获取完整性检查状态和最近一次报告
+func (s *CacheService) GetFsckStatus() FsckStatus {
+	s.fsck.mu.Lock()
+	defer s.fsck.mu.Unlock()
+	return FsckStatus{Running: s.fsck.running, LastReport: s.fsck.lastReport}
+}
+
+// Fsck 重新计算所有已缓存blob的digest，隔离损坏的内容，并检查manifest引用的blob是否存在
+func (s *CacheService) Fsck() (*FsckReport, error) {
+	s.fsck.mu.Lock()
+	if s.fsck.running {
+		s.fsck.mu.Unlock()
+		return nil, ErrFsckRunning
+	}
+	s.fsck.running = true
+	s.fsck.mu.Unlock()
+
+	report, err := s.runFsck()
+
+	s.fsck.mu.Lock()
+	s.fsck.running = false
+	if err == nil {
+		s.fsck.lastReport = report
+	}
+	s.fsck.mu.Unlock()
+
+	if err == nil {
+		log.Printf("Cache fsck finished: %d blobs checked, %d corrupted, %d missing files, %d missing references",
+			report.BlobsChecked, report.Corrupted, report.MissingFiles, report.MissingReferences)
+	}
+	return report, err
+}
+
+// runFsck 执行一次完整性检查
+func (s *CacheService) runFsck() (*FsckReport, error) {
+	report := &FsckReport{StartedAt: time.Now(), Issues: []FsckIssue{}}
+
+	var blobs []model.CachedBlob
+	if err := s.db.Find(&blobs).Error; err != nil {
+		return nil, err
+	}
+
+	for i := range blobs {
+		blob := &blobs[i]
+		actual, size, err := s.hashBlobFile(blob.Digest)
+		if os.IsNotExist(err) {
+			report.MissingFiles++
+			report.Issues = append(report.Issues, FsckIssue{Problem: "missing_file", Digest: blob.Digest})
+			log.Printf("Cache fsck: blob %s is missing on disk", blob.Digest)
+			s.mu.Lock()
+			s.deleteBlob(blob)
+			s.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			log.Printf("Cache fsck: failed to read blob %s: %v", blob.Digest, err)
+			continue
+		}
+
+		report.BlobsChecked++
+		report.BytesChecked += size
+		if actual == blob.Digest {
+			continue
+		}
+
+		report.Corrupted++
+		report.Issues = append(report.Issues, FsckIssue{Problem: "digest_mismatch", Digest: blob.Digest, Detail: "actual " + actual})
+		log.Printf("Cache fsck: blob %s is corrupted (actual %s), moving to quarantine", blob.Digest, actual)
+		if err := s.quarantineBlob(blob); err != nil {
+			log.Printf("Cache fsck: failed to quarantine blob %s: %v", blob.Digest, err)
+		}
+	}
+
+	// 检查本地托管manifest引用的blob是否存在。代理缓存的内容按需拉取，缺失的blob会在下次拉取时从上游补齐
+	var manifests []model.CachedManifest
+	if err := s.db.Where("hosted = ?", true).Find(&manifests).Error; err != nil {
+		return nil, err
+	}
+	for _, m := range manifests {
+		report.ManifestsChecked++
+		content, err := s.readBlob(m.Digest)
+		if err != nil {
+			report.MissingReferences++
+			report.Issues = append(report.Issues, FsckIssue{
+				Problem:    "missing_reference",
+				Digest:     m.Digest,
+				Repository: m.Repository,
+				Reference:  m.Reference,
+			})
+			continue
+		}
+
+		parsed, err := ParseManifest(content)
+		if err != nil {
+			continue
+		}
+		references := parsed.BlobReferences()
+		for _, child := range parsed.Manifests {
+			references = append(references, child.Digest)
+		}
+		for _, digest := range references {
+			if _, err := os.Stat(s.blobPath(digest)); err == nil {
+				continue
+			}
+			report.MissingReferences++
+			report.Issues = append(report.Issues, FsckIssue{
+				Problem:    "missing_reference",
+				Digest:     digest,
+				Repository: m.Repository,
+				Reference:  m.Reference,
+			})
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// hashBlobFile 重新计算磁盘上blob文件的digest
+func (s *CacheService) hashBlobFile(digest string) (string, int64, error) {
+	f, err := os.Open(s.blobPath(digest))
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", n, err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// quarantineBlob 将损坏的blob移入隔离目录，并移除其记录和指向它的manifest引用
+func (s *CacheService) quarantineBlob(blob *model.CachedBlob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	quarantineDir := filepath.Join(s.root, "quarantine")
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		return err
+	}
+	name := blob.Digest[len("sha256:"):] + "-" + time.Now().Format("20060102150405")
+	if err := os.Rename(s.blobPath(blob.Digest), filepath.Join(quarantineDir, name)); err != nil {
+		return err
+	}
+	return s.deleteBlob(blob)
+}
+
+// StartFsck 启动后台完整性检查任务
+func (s *CacheService) StartFsck(interval time.Duration) {
+	if !s.enabled || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := s.Fsck(); err != nil {
+				log.Printf("Cache fsck failed: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"zmirror/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// groupNamePattern 仓库组名只能是单级路径
+var groupNamePattern = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+// reservedGroupNames 不能用作仓库组名的命名空间，Docker Hub官方镜像都在library下
+var reservedGroupNames = []string{"library"}
+
+type GroupService struct {
+	db              *gorm.DB
+	registryService *RegistryService
+
+	// 启用的仓库组按名称缓存在内存中，每个Registry请求都要按第一级路径查找；通过管理API修改后整体替换
+	mu      sync.Mutex
+	enabled atomic.Pointer[map[string]model.RepositoryGroup]
+}
+
+func NewGroupService(db *gorm.DB, registryService *RegistryService) *GroupService {
+	return &GroupService{db: db, registryService: registryService}
+}
+
+// GetAllGroups 获取所有仓库组
+func (s *GroupService) GetAllGroups() ([]model.RepositoryGroup, error) {
+	var groups []model.RepositoryGroup
+	err := s.db.Order("name ASC").Find(&groups).Error
+	return groups, err
+}
+
+// CreateGroup 创建仓库组
+func (s *GroupService) CreateGroup(group *model.RepositoryGroup) error {
+	if err := s.validateGroup(group); err != nil {
+		return err
+	}
+	if err := s.db.Create(group).Error; err != nil {
+		return err
+	}
+	_, err := s.reload()
+	return err
+}
+
+// UpdateGroup 更新仓库组
+func (s *GroupService) UpdateGroup(group *model.RepositoryGroup) error {
+	if err := s.validateGroup(group); err != nil {
+		return err
+	}
+	if err := s.db.Save(group).Error; err != nil {
+		return err
+	}
+	_, err := s.reload()
+	return err
+}
+
+// DeleteGroup 删除仓库组
+func (s *GroupService) DeleteGroup(id uint) error {
+	if err := s.db.Delete(&model.RepositoryGroup{}, id).Error; err != nil {
+		return err
+	}
+	_, err := s.reload()
+	return err
+}
+
+// validateGroup 校验仓库组名。组名会覆盖同名的第一级命名空间，因此不能使用保留的命名空间，
+// 也不能与已缓存或本地托管的仓库的命名空间相同
+func (s *GroupService) validateGroup(group *model.RepositoryGroup) error {
+	if !groupNamePattern.MatchString(group.Name) {
+		return fmt.Errorf("invalid group name: %s", group.Name)
+	}
+	if slices.Contains(reservedGroupNames, group.Name) {
+		return fmt.Errorf("group name %s is a reserved namespace", group.Name)
+	}
+	var count int64
+	// 按范围查询以 <组名>/ 开头的仓库，'0' 是 '/' 之后的下一个字符
+	if err := s.db.Model(&model.CachedManifest{}).Where("repository >= ? AND repository < ?", group.Name+"/", group.Name+"0").Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("group name %s conflicts with existing repositories in that namespace", group.Name)
+	}
+	return nil
+}
+
+// Resolve 根据镜像名的第一级路径查找启用的仓库组，返回仓库组和去掉组名后的镜像名；不属于任何仓库组时返回nil
+func (s *GroupService) Resolve(name string) (*model.RepositoryGroup, string) {
+	prefix, rest, ok := strings.Cut(name, "/")
+	if !ok || rest == "" {
+		return nil, name
+	}
+
+	groups := s.enabled.Load()
+	if groups == nil {
+		var err error
+		if groups, err = s.reload(); err != nil {
+			return nil, name
+		}
+	}
+	group, ok := (*groups)[prefix]
+	if !ok {
+		return nil, name
+	}
+	return &group, rest
+}
+
+// ResolveName 返回去掉仓库组名后的镜像名，访问策略和访问规则都按这个名称检查
+func (s *GroupService) ResolveName(name string) string {
+	_, resolved := s.Resolve(name)
+	return resolved
+}
+
+// reload 重新从数据库加载启用的仓库组。加锁保证并发加载时最后写入的是修改之后查询到的结果，
+// 加载失败时清空缓存，下次读取再重试
+func (s *GroupService) reload() (*map[string]model.RepositoryGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var groups []model.RepositoryGroup
+	if err := s.db.Where("enabled = ?", true).Find(&groups).Error; err != nil {
+		s.enabled.Store(nil)
+		return nil, err
+	}
+	byName := make(map[string]model.RepositoryGroup, len(groups))
+	for _, group := range groups {
+		byName[group.Name] = group
+	}
+	s.enabled.Store(&byName)
+	return &byName, nil
+}
+
+// GroupRegistries 按仓库组配置的顺序返回启用的镜像源
+func (s *GroupService) GroupRegistries(group *model.RepositoryGroup) ([]model.Registry, error) {
+	registries, err := s.registryService.GetEnabledRegistries()
+	if err != nil || len(group.RegistryIDs) == 0 {
+		return registries, err
+	}
+
+	byID := make(map[uint]model.Registry, len(registries))
+	for _, registry := range registries {
+		byID[registry.ID] = registry
+	}
+	ordered := make([]model.Registry, 0, len(group.RegistryIDs))
+	for _, id := range group.RegistryIDs {
+		if registry, ok := byID[id]; ok {
+			ordered = append(ordered, registry)
+		}
+	}
+	return ordered, nil
+}
@@ -0,0 +1,339 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// MaxManifestSize 可缓存的manifest最大字节数
+const MaxManifestSize = 4 * 1024 * 1024
+
+// manifestAccept 请求manifest时接受的媒体类型
+var manifestAccept = strings.Join([]string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}, ", ")
+
+var (
+	// ErrPlatformNotFound 镜像不包含指定平台
+	ErrPlatformNotFound = errors.New("platform not found in image index")
+
+	// ErrInvalidReference 镜像引用格式错误
+	ErrInvalidReference = errors.New("invalid image reference")
+
+	// ErrInvalidPlatform 平台格式错误
+	ErrInvalidPlatform = errors.New("invalid platform")
+)
+
+// ImageService 以镜像为单位读取manifest和blob：优先使用本地缓存，未命中时通过代理获取并写入缓存
+type ImageService struct {
+	proxyService *ProxyService
+	cacheService *CacheService
+
+	hostedWriteHooks []func(repositories []string)
+}
+
+func NewImageService(proxyService *ProxyService, cacheService *CacheService) *ImageService {
+	return &ImageService{
+		proxyService: proxyService,
+		cacheService: cacheService,
+	}
+}
+
+// OnHostedWrite 注册本地托管内容写入后的回调，参数为写入的仓库列表
+func (s *ImageService) OnHostedWrite(hook func(repositories []string)) {
+	s.hostedWriteHooks = append(s.hostedWriteHooks, hook)
+}
+
+// notifyHostedWrite 通知本地托管内容已写入
+func (s *ImageService) notifyHostedWrite(repositories []string) {
+	if len(repositories) == 0 {
+		return
+	}
+	for _, hook := range s.hostedWriteHooks {
+		hook(repositories)
+	}
+}
+
+// FetchedManifest 获取到的manifest
+type FetchedManifest struct {
+	Content   []byte
+	MediaType string
+	Digest    string
+	Upstream  string
+	Manifest  *Manifest
+}
+
+// ParseImageReference 解析镜像引用，如 nginx:1.25、ghcr.io/org/app@sha256:...，返回仓库名和tag或digest
+func ParseImageReference(ref string) (repository, reference string, err error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return "", "", fmt.Errorf("%w: empty", ErrInvalidReference)
+	}
+
+	name := ref
+	reference = "latest"
+	if i := strings.Index(ref, "@"); i >= 0 {
+		name, reference = ref[:i], ref[i+1:]
+		if !IsDigest(reference) {
+			return "", "", fmt.Errorf("%w: invalid digest %s", ErrInvalidReference, reference)
+		}
+	} else if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name, reference = ref[:i], ref[i+1:]
+	}
+
+	// 去掉镜像源域名，由镜像源配置决定实际的上游
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		name = parts[1]
+	}
+	// Docker Hub官方镜像
+	if !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if name == "" || reference == "" {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidReference, ref)
+	}
+
+	return name, reference, nil
+}
+
+// ParsePlatform 解析平台字符串，如 linux/amd64、linux/arm64/v8
+func ParsePlatform(platform string) (*Platform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPlatform, platform)
+	}
+	p := &Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// String 返回平台字符串
+func (p *Platform) String() string {
+	if p == nil {
+		return ""
+	}
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// SelectPlatform 从index中选择指定平台的manifest描述符
+func SelectPlatform(index *Manifest, platform *Platform) (*Descriptor, error) {
+	for i := range index.Manifests {
+		candidate := index.Manifests[i].Platform
+		if candidate == nil || candidate.OS != platform.OS || candidate.Architecture != platform.Architecture {
+			continue
+		}
+		if platform.Variant != "" && candidate.Variant != platform.Variant {
+			continue
+		}
+		return &index.Manifests[i], nil
+	}
+	return nil, ErrPlatformNotFound
+}
+
+// FetchManifest 获取manifest：优先使用缓存，未命中或已过期时通过代理获取并写入缓存
+func (s *ImageService) FetchManifest(repository, reference string) (*FetchedManifest, error) {
+	if s.cacheService.Enabled() {
+		cached, content, err := s.cacheService.GetManifest(repository, reference)
+		if err == nil && (s.cacheService.IsFresh(cached) || s.proxyService.IsOffline()) {
+			return newFetchedManifest(content, cached.MediaType, cached.Upstream)
+		}
+	}
+
+	return s.fetchManifestFromUpstream(repository, reference)
+}
+
+// fetchManifestFromUpstream 通过代理从上游获取manifest并写入缓存
+func (s *ImageService) fetchManifestFromUpstream(repository, reference string) (*FetchedManifest, error) {
+	headers := http.Header{}
+	headers.Set("Accept", manifestAccept)
+	resp, upstream, err := s.proxyService.ProxyRequest("GET", "/v2/"+repository+"/manifests/"+reference, headers)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, MaxManifestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > MaxManifestSize {
+		return nil, fmt.Errorf("manifest too large")
+	}
+
+	fetched, err := newFetchedManifest(content, resp.Header.Get("Content-Type"), upstream)
+	if err != nil {
+		return nil, err
+	}
+	if IsDigest(reference) && fetched.Digest != reference {
+		return nil, fmt.Errorf("digest mismatch: expected %s, got %s", reference, fetched.Digest)
+	}
+
+	if s.cacheService.Enabled() {
+		if _, err := s.cacheService.PutManifest(repository, reference, content, fetched.MediaType, upstream, false); err != nil {
+			log.Printf("Failed to cache manifest %s:%s: %v", repository, reference, err)
+		}
+	}
+	return fetched, nil
+}
+
+// newFetchedManifest 计算digest并解析manifest内容
+func newFetchedManifest(content []byte, mediaType, upstream string) (*FetchedManifest, error) {
+	parsed, err := ParseManifest(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if mediaType == "" {
+		mediaType = parsed.MediaType
+	}
+
+	sum := sha256.Sum256(content)
+	return &FetchedManifest{
+		Content:   content,
+		MediaType: mediaType,
+		Digest:    "sha256:" + hex.EncodeToString(sum[:]),
+		Upstream:  upstream,
+		Manifest:  parsed,
+	}, nil
+}
+
+// PrefetchResult 预取结果
+type PrefetchResult struct {
+	Digest    string `json:"digest"`
+	Manifests int    `json:"manifests"`
+	Blobs     int    `json:"blobs"` // 新下载的blob数，已缓存的不计入
+	Bytes     int64  `json:"bytes"`
+}
+
+// Prefetch 从上游获取最新的manifest，并把指定平台的manifest和全部blob拉取到缓存。
+// platforms为nil时预取所有平台，镜像中不存在的平台跳过
+func (s *ImageService) Prefetch(repository, reference string, platforms []string) (*PrefetchResult, error) {
+	if !s.cacheService.Enabled() {
+		return nil, ErrCacheDisabled
+	}
+
+	fetched, err := s.fetchManifestFromUpstream(repository, reference)
+	if err != nil {
+		return nil, err
+	}
+	result := &PrefetchResult{Digest: fetched.Digest}
+
+	manifests := []*FetchedManifest{fetched}
+	if fetched.Manifest.IsIndex() {
+		children := fetched.Manifest.Manifests
+		if platforms != nil {
+			children = nil
+			for _, platform := range platforms {
+				wanted, err := ParsePlatform(platform)
+				if err != nil {
+					return nil, err
+				}
+				if child, err := SelectPlatform(fetched.Manifest, wanted); err == nil {
+					children = append(children, *child)
+				}
+			}
+		}
+
+		manifests = nil
+		for _, child := range children {
+			m, err := s.FetchManifest(repository, child.Digest)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, m)
+		}
+	}
+	result.Manifests = len(manifests)
+
+	for _, m := range manifests {
+		var descriptors []Descriptor
+		if m.Manifest.Config != nil {
+			descriptors = append(descriptors, *m.Manifest.Config)
+		}
+		descriptors = append(descriptors, m.Manifest.Layers...)
+		for _, d := range descriptors {
+			if _, err := s.cacheService.StatBlob(d.Digest); err == nil {
+				continue
+			}
+			r, err := s.OpenBlob(repository, d.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("blob %s: %v", d.Digest, err)
+			}
+			n, err := io.Copy(io.Discard, r)
+			r.Close()
+			if err != nil {
+				return nil, fmt.Errorf("blob %s: %v", d.Digest, err)
+			}
+			result.Blobs++
+			result.Bytes += n
+		}
+	}
+	return result, nil
+}
+
+// OpenBlob 打开blob：优先使用缓存，未命中时通过代理获取，读取完毕后写入缓存
+func (s *ImageService) OpenBlob(repository, digest string) (io.ReadCloser, error) {
+	if s.cacheService.Enabled() {
+		if f, _, err := s.cacheService.OpenBlob(digest); err == nil {
+			return f, nil
+		}
+	}
+
+	resp, upstream, err := s.proxyService.ProxyRequest("GET", "/v2/"+repository+"/blobs/"+digest, http.Header{})
+	if err != nil {
+		return nil, err
+	}
+	if !s.cacheService.Enabled() {
+		return resp.Body, nil
+	}
+
+	w, err := s.cacheService.CreateBlobWriter(digest, resp.Header.Get("Content-Type"), upstream)
+	if err != nil {
+		return resp.Body, nil
+	}
+	return &cachingReader{body: resp.Body, writer: w}, nil
+}
+
+// cachingReader 读取上游blob的同时写入缓存，完整读取后提交
+type cachingReader struct {
+	body   io.ReadCloser
+	writer *BlobWriter
+	done   bool
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if n > 0 {
+		r.writer.Write(p[:n])
+	}
+	if err == io.EOF {
+		r.done = true
+	}
+	return n, err
+}
+
+func (r *cachingReader) Close() error {
+	err := r.body.Close()
+	if !r.done {
+		r.writer.Cancel()
+		return err
+	}
+	if commitErr := r.writer.Commit(); commitErr != nil {
+		log.Printf("Failed to cache blob %s: %v", r.writer.digest, commitErr)
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// digestPattern sha256 digest格式
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// Platform 镜像平台信息
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Descriptor OCI内容描述符
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *Platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest 镜像manifest或index（manifest list）
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        *Descriptor  `json:"config,omitempty"`
+	Layers        []Descriptor `json:"layers,omitempty"`
+	Manifests     []Descriptor `json:"manifests,omitempty"`
+}
+
+// ImageConfig 镜像配置（config blob）中常用的字段
+type ImageConfig struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+	Created      string `json:"created,omitempty"`
+	Config       struct {
+		Labels     map[string]string `json:"Labels,omitempty"`
+		Env        []string          `json:"Env,omitempty"`
+		Entrypoint []string          `json:"Entrypoint,omitempty"`
+		Cmd        []string          `json:"Cmd,omitempty"`
+	} `json:"config"`
+	History []ImageHistory `json:"history,omitempty"`
+}
+
+// ImageHistory 镜像构建历史
+type ImageHistory struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+}
+
+// ParseManifest 解析manifest内容
+func ParseManifest(content []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// IsIndex 判断是否为多平台index
+func (m *Manifest) IsIndex() bool {
+	return len(m.Manifests) > 0
+}
+
+// BlobReferences 返回manifest直接引用的配置和镜像层digest
+func (m *Manifest) BlobReferences() []string {
+	var digests []string
+	if m.Config != nil && m.Config.Digest != "" {
+		digests = append(digests, m.Config.Digest)
+	}
+	for _, layer := range m.Layers {
+		digests = append(digests, layer.Digest)
+	}
+	return digests
+}
+
+// IsDigest 判断引用是否为digest
+func IsDigest(reference string) bool {
+	return digestPattern.MatchString(reference)
+}
+
+// ParseRegistryPath 解析Registry API路径，返回镜像名、请求类型（manifests/blobs/tags）和引用
+func ParseRegistryPath(path string) (name, kind, reference string) {
+	path = strings.TrimPrefix(path, "/v2/")
+
+	// 镜像名本身可能包含多级路径，取最后一个类型分隔符
+	idx := -1
+	for _, k := range []string{"manifests", "blobs", "tags"} {
+		if i := strings.LastIndex(path, "/"+k+"/"); i > idx {
+			idx = i
+			kind = k
+		}
+	}
+	if idx <= 0 {
+		return "", "", ""
+	}
+
+	return path[:idx], kind, path[idx+len(kind)+2:]
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// passwordSaltLength 每个密码随机生成的盐长度
+	passwordSaltLength = 16
+
+	// passwordKeyLength argon2id输出的哈希长度
+	passwordKeyLength = 32
+)
+
+// PasswordParams argon2id的计算开销参数
+type PasswordParams struct {
+	Memory      uint32 // 内存开销（KiB）
+	Iterations  uint32 // 迭代次数
+	Parallelism uint8  // 并行度
+}
+
+// DefaultPasswordParams 默认参数，取OWASP推荐的argon2id配置
+var DefaultPasswordParams = PasswordParams{Memory: 19456, Iterations: 2, Parallelism: 1}
+
+// hashPassword 使用argon2id和随机盐计算密码哈希，
+// 编码为 $argon2id$v=19$m=<内存>,t=<迭代>,p=<并行度>$<盐>$<哈希>
+func hashPassword(password string, params PasswordParams) (string, error) {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, passwordKeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// verifyPassword 以恒定时间比较密码和已存储的哈希。旧版本保存的无盐MD5哈希仍可验证，
+// 验证成功且哈希是MD5或参数与当前配置不一致时返回needsRehash
+func verifyPassword(password, encoded string, params PasswordParams) (ok, needsRehash bool) {
+	if !strings.HasPrefix(encoded, "$argon2id$") {
+		if len(encoded) != md5.Size*2 {
+			return false, false
+		}
+		sum := md5.Sum([]byte(password))
+		ok = subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(encoded))) == 1
+		return ok, ok
+	}
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, false
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false
+	}
+	var stored PasswordParams
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &stored.Memory, &stored.Iterations, &stored.Parallelism); err != nil {
+		return false, false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(expected) == 0 {
+		return false, false
+	}
+
+	key := argon2.IDKey([]byte(password), salt, stored.Iterations, stored.Memory, stored.Parallelism, uint32(len(expected)))
+	ok = subtle.ConstantTimeCompare(key, expected) == 1
+	return ok, ok && stored != params
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"zmirror/internal/model"
+)
+
+func md5Hex(password string) string {
+	sum := md5.Sum([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestVerifyPassword(t *testing.T) {
+	current, err := hashPassword("secret", testPasswordParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	older, err := hashPassword("secret", PasswordParams{Memory: 32, Iterations: 1, Parallelism: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		password    string
+		encoded     string
+		ok          bool
+		needsRehash bool
+	}{
+		{"argon2id", "secret", current, true, false},
+		{"argon2id wrong password", "wrong", current, false, false},
+		{"argon2id older params", "secret", older, true, true},
+		{"argon2id older params wrong password", "wrong", older, false, false},
+		{"md5", "secret", md5Hex("secret"), true, true},
+		{"md5 uppercase", "secret", strings.ToUpper(md5Hex("secret")), true, true},
+		{"md5 wrong password", "wrong", md5Hex("secret"), false, false},
+		{"empty hash", "secret", "", false, false},
+		{"plaintext", "secret", "secret", false, false},
+		{"truncated argon2id", "secret", strings.Join(strings.Split(current, "$")[:5], "$"), false, false},
+		{"unknown version", "secret", strings.Replace(current, "v=19", "v=16", 1), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash := verifyPassword(tt.password, tt.encoded, testPasswordParams)
+			if ok != tt.ok || needsRehash != tt.needsRehash {
+				t.Errorf("verifyPassword() = %v, %v, want %v, %v", ok, needsRehash, tt.ok, tt.needsRehash)
+			}
+		})
+	}
+}
+
+func TestHashPasswordUsesRandomSalt(t *testing.T) {
+	first, err := hashPassword("secret", testPasswordParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := hashPassword("secret", testPasswordParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Error("hashing the same password twice produced the same hash")
+	}
+}
+
+func TestAuthenticateUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		stored   string // 为空时用当前参数哈希 "secret"
+		username string
+		password string
+		wantErr  error
+		upgraded bool // 登录后保存的哈希应为当前参数的argon2id
+	}{
+		{"argon2id", "", "alice", "secret", nil, false},
+		{"argon2id wrong password", "", "alice", "wrong", ErrInvalidCredentials, false},
+		{"unknown user", "", "bob", "secret", ErrInvalidCredentials, false},
+		{"md5 rehashed on login", md5Hex("secret"), "alice", "secret", nil, true},
+		{"md5 wrong password keeps hash", md5Hex("secret"), "alice", "wrong", ErrInvalidCredentials, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			userService := newTestUserService(t, db)
+
+			stored := tt.stored
+			if stored == "" {
+				var err error
+				if stored, err = userService.HashPassword("secret"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := db.Create(&model.User{Username: "alice", Password: stored}).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			user, err := userService.AuthenticateUser(tt.username, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AuthenticateUser() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && user.Username != tt.username {
+				t.Errorf("AuthenticateUser() user = %q, want %q", user.Username, tt.username)
+			}
+
+			var saved model.User
+			if err := db.Where("username = ?", "alice").First(&saved).Error; err != nil {
+				t.Fatal(err)
+			}
+			if tt.upgraded {
+				if ok, needsRehash := verifyPassword("secret", saved.Password, testPasswordParams); !ok || needsRehash {
+					t.Errorf("stored hash %q was not upgraded to argon2id", saved.Password)
+				}
+				// 升级后仍然可以用原密码登录
+				if _, err := userService.AuthenticateUser("alice", "secret"); err != nil {
+					t.Errorf("login after rehash failed: %v", err)
+				}
+			} else if saved.Password != stored {
+				t.Errorf("stored hash changed to %q", saved.Password)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package service
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchRepository 判断仓库名是否匹配规则。包含通配符时按glob匹配，否则按前缀匹配
+func MatchRepository(pattern, repository string) bool {
+	if pattern == "" {
+		return false
+	}
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, err := path.Match(pattern, repository)
+		return err == nil && matched
+	}
+	return strings.HasPrefix(repository, pattern)
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"zmirror/internal/model"
+)
+
+// PolicyRequest 策略模拟请求，描述一次假设的仓库访问
+type PolicyRequest struct {
+	User      string `json:"user"`      // 用户名，机器人账号为 robot$名称，为空表示匿名
+	Image     string `json:"image"`     // 镜像名，如 library/nginx
+	Reference string `json:"reference"` // 标签或digest，为空表示仓库级别的访问（如blob）
+	Action    string `json:"action"`    // pull, push, delete，默认pull
+	ClientIP  string `json:"client_ip"` // 客户端地址，用于按地址判断的阶段，为空表示不指定
+
+	AccessTokenID uint `json:"access_token_id"` // 模拟使用该访问令牌作为密码，为0表示使用账号密码
+}
+
+// PolicyTraceStep 判定过程中的一步
+type PolicyTraceStep struct {
+	Stage   string      `json:"stage"`          // rate_limit, repository_group, whitelist, authentication, access_token, permission, acl
+	Matched bool        `json:"matched"`        // 是否有规则匹配
+	Rule    interface{} `json:"rule,omitempty"` // 匹配的规则
+	Result  string      `json:"result"`         // allow, deny, continue
+	Reason  string      `json:"reason"`
+}
+
+// PolicyDecision 策略模拟的最终结果，Status 为实际请求会得到的HTTP状态码
+type PolicyDecision struct {
+	Allowed bool              `json:"allowed"`
+	Status  int               `json:"status"`
+	Reason  string            `json:"reason"`
+	Request PolicyRequest     `json:"request"`
+	Trace   []PolicyTraceStep `json:"trace"`
+}
+
+// PolicyService 按认证中间件的顺序模拟访问判定，用于排查某个用户为什么不能访问某个镜像
+type PolicyService struct {
+	userService        *UserService
+	whitelistService   *WhitelistService
+	accessTokenService *AccessTokenService
+	aclService         *ACLService
+	groupService       *GroupService
+}
+
+func NewPolicyService(userService *UserService, whitelistService *WhitelistService, accessTokenService *AccessTokenService, aclService *ACLService, groupService *GroupService) *PolicyService {
+	return &PolicyService{
+		userService:        userService,
+		whitelistService:   whitelistService,
+		accessTokenService: accessTokenService,
+		aclService:         aclService,
+		groupService:       groupService,
+	}
+}
+
+// Evaluate 先按客户端地址检查限流并解析仓库组，再依次经过白名单、认证、访问令牌、用户权限和访问规则判定请求。
+// 模拟时不校验密码或令牌，假定用户提供了有效的凭据
+func (s *PolicyService) Evaluate(req PolicyRequest) (*PolicyDecision, error) {
+	req.User = strings.TrimSpace(req.User)
+	req.Image = strings.Trim(strings.TrimSpace(req.Image), "/")
+	req.Reference = strings.TrimSpace(req.Reference)
+	req.ClientIP = strings.TrimSpace(req.ClientIP)
+	if req.Image == "" {
+		return nil, fmt.Errorf("image is required")
+	}
+	if req.ClientIP != "" && net.ParseIP(req.ClientIP) == nil {
+		return nil, fmt.Errorf("invalid client_ip: %s", req.ClientIP)
+	}
+	if req.Action == "" {
+		req.Action = "pull"
+	}
+	if !containsString(ACLActions, req.Action) {
+		return nil, fmt.Errorf("action must be pull, push or delete")
+	}
+
+	decision := &PolicyDecision{Request: req, Trace: []PolicyTraceStep{}}
+	finish := func(allowed bool, status int, reason string) (*PolicyDecision, error) {
+		decision.Allowed = allowed
+		decision.Status = status
+		decision.Reason = reason
+		return decision, nil
+	}
+
+	// 限流：实际请求最先按客户端地址限流，本服务目前没有配置限流或按地址的访问规则，任何地址都会继续
+	step := PolicyTraceStep{Stage: "rate_limit", Result: "continue", Reason: "rate limiting is not configured on this server"}
+	if req.ClientIP != "" {
+		step.Reason = fmt.Sprintf("rate limiting and address-based rules are not configured on this server, %s is not restricted", req.ClientIP)
+	}
+	decision.Trace = append(decision.Trace, step)
+
+	// 仓库组：白名单、访问令牌和访问规则都按去掉组名后的仓库判定
+	group, repository := s.groupService.Resolve(req.Image)
+	if group != nil {
+		decision.Trace = append(decision.Trace, PolicyTraceStep{
+			Stage:   "repository_group",
+			Matched: true,
+			Rule:    group,
+			Result:  "continue",
+			Reason:  fmt.Sprintf("%s resolves to %s through repository group %s", req.Image, repository, group.Name),
+		})
+	}
+
+	// 推送请求同时需要pull和push
+	actions := []string{req.Action}
+	if req.Action == "push" {
+		actions = []string{"pull", "push"}
+	}
+
+	// 白名单：禁止访问的镜像直接拒绝（删除除外），允许匿名的镜像拉取时不需要认证
+	rule, err := s.whitelistService.Evaluate(repository, req.Reference)
+	if err != nil {
+		return nil, err
+	}
+	step = PolicyTraceStep{Stage: "whitelist", Matched: rule != nil, Result: "continue"}
+	if rule != nil {
+		step.Rule = rule
+	}
+	switch effect := WhitelistEffect(rule); {
+	case effect == WhitelistDeny && req.Action != "delete":
+		step.Result = "deny"
+		step.Reason = fmt.Sprintf("whitelist rule #%d forbids this image", rule.ID)
+		decision.Trace = append(decision.Trace, step)
+		return finish(false, 403, step.Reason)
+	case effect == WhitelistAnonymous && req.Action == "pull":
+		step.Result = "allow"
+		step.Reason = fmt.Sprintf("whitelist rule #%d allows anonymous pull", rule.ID)
+		decision.Trace = append(decision.Trace, step)
+		return finish(true, 200, step.Reason)
+	case rule == nil:
+		step.Reason = "no whitelist rule matched, authentication required"
+	case effect == WhitelistDeny:
+		step.Reason = fmt.Sprintf("whitelist rule #%d forbids this image but deletion is still allowed", rule.ID)
+	case effect == WhitelistAnonymous:
+		step.Reason = fmt.Sprintf("whitelist rule #%d only allows anonymous pull, %s requires authentication", rule.ID, req.Action)
+	default:
+		step.Reason = fmt.Sprintf("whitelist rule #%d requires authentication", rule.ID)
+	}
+	decision.Trace = append(decision.Trace, step)
+
+	// 认证：模拟时只检查账号是否存在
+	if req.User == "" {
+		decision.Trace = append(decision.Trace, PolicyTraceStep{Stage: "authentication", Result: "deny", Reason: "anonymous request"})
+		return finish(false, 401, "authentication required")
+	}
+	var user *model.User
+	var token *model.AccessToken
+	robot := strings.HasPrefix(req.User, RobotUserPrefix)
+	if req.AccessTokenID != 0 {
+		if token, err = s.accessTokenService.GetToken(req.AccessTokenID); err != nil {
+			decision.Trace = append(decision.Trace, PolicyTraceStep{Stage: "authentication", Result: "deny", Reason: "access token not found"})
+			return finish(false, 401, "access token not found")
+		}
+		if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
+			decision.Trace = append(decision.Trace, PolicyTraceStep{Stage: "authentication", Result: "deny", Reason: "access token has expired"})
+			return finish(false, 401, "access token has expired")
+		}
+		// 令牌代表的用户，其权限是所属账号权限与令牌操作的交集
+		if user, err = s.accessTokenService.tokenUser(token); err != nil || user.Username != req.User {
+			decision.Trace = append(decision.Trace, PolicyTraceStep{Stage: "authentication", Result: "deny", Reason: "access token does not belong to this account"})
+			return finish(false, 401, "access token does not belong to this account")
+		}
+	} else if robot {
+		if _, err := s.accessTokenService.GetRobot(strings.TrimPrefix(req.User, RobotUserPrefix)); err != nil {
+			decision.Trace = append(decision.Trace, PolicyTraceStep{Stage: "authentication", Result: "deny", Reason: "robot account not found"})
+			return finish(false, 401, "robot account not found")
+		}
+		// 机器人账号的权限由所用令牌决定，这里按令牌允许所有操作模拟
+		user = &model.User{Username: req.User, CanPush: true, CanDelete: true}
+	} else {
+		if user, err = s.userService.GetUser(req.User); err != nil {
+			decision.Trace = append(decision.Trace, PolicyTraceStep{Stage: "authentication", Result: "deny", Reason: "user not found"})
+			return finish(false, 401, "user not found")
+		}
+	}
+	decision.Trace = append(decision.Trace, PolicyTraceStep{Stage: "authentication", Result: "continue", Reason: "account exists, credentials are assumed valid"})
+
+	// 访问令牌：只能用于其允许的仓库和操作
+	if token != nil {
+		step := PolicyTraceStep{Stage: "access_token", Matched: true, Rule: token, Result: "continue"}
+		step.Reason = fmt.Sprintf("access token #%d permits %s on %s", token.ID, strings.Join(actions, ", "), repository)
+		for _, action := range actions {
+			if !AccessTokenAllows(token, repository, action) {
+				step.Result = "deny"
+				step.Reason = fmt.Sprintf("access token #%d does not permit %s on %s", token.ID, action, repository)
+				break
+			}
+		}
+		decision.Trace = append(decision.Trace, step)
+		if step.Result == "deny" {
+			return finish(false, 403, step.Reason)
+		}
+	}
+
+	// 用户权限：推送和删除需要对应权限
+	step = PolicyTraceStep{Stage: "permission", Result: "continue"}
+	switch {
+	case robot && token == nil:
+		step.Reason = "robot account permissions depend on the access token used"
+	case user.IsAdmin:
+		step.Reason = "administrator has all permissions"
+	case req.Action == "push" && !user.CanPush:
+		step.Result = "deny"
+		step.Reason = "user does not have push permission"
+	case req.Action == "delete" && !user.CanDelete:
+		step.Result = "deny"
+		step.Reason = "user does not have delete permission"
+	default:
+		step.Reason = fmt.Sprintf("user is allowed to %s", req.Action)
+	}
+	decision.Trace = append(decision.Trace, step)
+	if step.Result == "deny" {
+		return finish(false, 403, step.Reason)
+	}
+
+	// 仓库访问规则
+	for _, action := range actions {
+		result, err := s.aclService.Check(user, repository, action)
+		if err != nil {
+			return nil, err
+		}
+		step := PolicyTraceStep{Stage: "acl", Matched: result.Rule != nil, Result: "continue"}
+		switch {
+		case result.Rule != nil:
+			step.Rule = result.Rule
+			step.Reason = fmt.Sprintf("access rule #%d allows %s", result.Rule.ID, action)
+			if !result.Allowed {
+				step.Result = "deny"
+				step.Reason = fmt.Sprintf("access rule #%d denies %s", result.Rule.ID, action)
+			}
+		case user.IsAdmin:
+			step.Reason = "administrators are not subject to access rules"
+		default:
+			step.Reason = fmt.Sprintf("no access rule matched %s", action)
+		}
+		decision.Trace = append(decision.Trace, step)
+		if step.Result == "deny" {
+			return finish(false, 403, step.Reason)
+		}
+	}
+
+	return finish(true, 200, "request would be allowed")
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"zmirror/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// PushRouteService 推送路由：将匹配的仓库的推送请求透传到指定镜像源
+type PushRouteService struct {
+	db              *gorm.DB
+	registryService *RegistryService
+
+	// 启用的路由及其目标镜像源缓存在内存中，每个Registry请求都可能要匹配；通过管理API修改路由后整体替换，
+	// 镜像源修改后按镜像源缓存的版本号重新加载
+	mu       sync.Mutex
+	snapshot atomic.Pointer[pushRouteSnapshot]
+}
+
+// pushRouteSnapshot 启用的推送路由及其目标镜像源
+type pushRouteSnapshot struct {
+	routes     []model.PushRoute
+	registries map[uint]model.Registry
+	proxyPulls bool   // 存在开启了 ProxyPulls 的路由
+	generation uint64 // 加载时镜像源缓存的版本号
+}
+
+func NewPushRouteService(db *gorm.DB, registryService *RegistryService) *PushRouteService {
+	return &PushRouteService{db: db, registryService: registryService}
+}
+
+// GetAllRoutes 获取所有推送路由
+func (s *PushRouteService) GetAllRoutes() ([]model.PushRoute, error) {
+	var routes []model.PushRoute
+	err := s.db.Order("id ASC").Find(&routes).Error
+	return routes, err
+}
+
+// CreateRoute 创建推送路由
+func (s *PushRouteService) CreateRoute(route *model.PushRoute) error {
+	if err := s.validateRoute(route); err != nil {
+		return err
+	}
+	if err := s.db.Create(route).Error; err != nil {
+		return err
+	}
+	_, err := s.reload()
+	return err
+}
+
+// UpdateRoute 更新推送路由
+func (s *PushRouteService) UpdateRoute(route *model.PushRoute) error {
+	if err := s.validateRoute(route); err != nil {
+		return err
+	}
+	if err := s.db.Save(route).Error; err != nil {
+		return err
+	}
+	_, err := s.reload()
+	return err
+}
+
+// DeleteRoute 删除推送路由
+func (s *PushRouteService) DeleteRoute(id uint) error {
+	if err := s.db.Delete(&model.PushRoute{}, id).Error; err != nil {
+		return err
+	}
+	_, err := s.reload()
+	return err
+}
+
+// validateRoute 校验推送路由，目标镜像源必须存在
+func (s *PushRouteService) validateRoute(route *model.PushRoute) error {
+	if route.Pattern == "" {
+		return fmt.Errorf("pattern is required")
+	}
+	if _, err := s.registryService.GetRegistry(route.RegistryID); err != nil {
+		return fmt.Errorf("registry %d not found", route.RegistryID)
+	}
+	return nil
+}
+
+// Match 返回仓库匹配的推送路由及其目标镜像源，多条路由匹配时取规则最长的一条。push 为 false 时只返回开启了
+// ProxyPulls 的路由，没有这样的路由时不做匹配。目标镜像源即使未启用也会被使用，便于配置只接收推送、不参与拉取的镜像源
+func (s *PushRouteService) Match(repository string, push bool) (*model.PushRoute, *model.Registry, error) {
+	snapshot := s.snapshot.Load()
+	if snapshot == nil || snapshot.generation != s.registryService.Generation() {
+		var err error
+		if snapshot, err = s.reload(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if !push && !snapshot.proxyPulls {
+		return nil, nil, nil
+	}
+
+	var matched *model.PushRoute
+	for i := range snapshot.routes {
+		route := &snapshot.routes[i]
+		if MatchRepository(route.Pattern, repository) && (matched == nil || len(route.Pattern) > len(matched.Pattern)) {
+			matched = route
+		}
+	}
+	if matched == nil || (!push && !matched.ProxyPulls) {
+		return nil, nil, nil
+	}
+
+	registry, ok := snapshot.registries[matched.RegistryID]
+	if !ok {
+		return nil, nil, fmt.Errorf("registry %d of push route %d not found", matched.RegistryID, matched.ID)
+	}
+	route := *matched
+	return &route, &registry, nil
+}
+
+// reload 重新从数据库加载启用的推送路由及其目标镜像源。加锁保证并发加载时最后写入的是修改之后查询到的结果，
+// 加载失败时清空缓存，下次读取再重试
+func (s *PushRouteService) reload() (*pushRouteSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 先读取版本号，加载期间镜像源再次修改时下次匹配会重新加载
+	snapshot := &pushRouteSnapshot{generation: s.registryService.Generation()}
+	if err := s.db.Where("enabled = ?", true).Find(&snapshot.routes).Error; err != nil {
+		s.snapshot.Store(nil)
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(snapshot.routes))
+	for _, route := range snapshot.routes {
+		ids = append(ids, route.RegistryID)
+		snapshot.proxyPulls = snapshot.proxyPulls || route.ProxyPulls
+	}
+	var registries []model.Registry
+	if len(ids) > 0 {
+		if err := s.db.Where("id IN ?", ids).Find(&registries).Error; err != nil {
+			s.snapshot.Store(nil)
+			return nil, err
+		}
+	}
+	snapshot.registries = make(map[uint]model.Registry, len(registries))
+	for _, registry := range registries {
+		snapshot.registries[registry.ID] = registry
+	}
+
+	s.snapshot.Store(snapshot)
+	return snapshot, nil
+}
@@ -0,0 +1,324 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"zmirror/internal/model"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// refreshStatsWindow 统计拉取次数使用的访问日志时间范围
+	refreshStatsWindow = 24 * time.Hour
+
+	// refreshMargin 距离过期不足下一次刷新间隔加上该余量时提前刷新
+	refreshMargin = 30 * time.Second
+)
+
+// ErrRefreshRunning 已有热门tag刷新正在运行
+var ErrRefreshRunning = errors.New("refresh is already running")
+
+// RefreshItem 一个热门tag的刷新结果
+type RefreshItem struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Pulls      int    `json:"pulls"`
+	Action     string `json:"action"` // fresh、revalidated、updated、fetched、failed
+	Digest     string `json:"digest,omitempty"`
+	Previous   string `json:"previous_digest,omitempty"`
+	Blobs      int    `json:"blobs,omitempty"` // 预取的blob数
+	Error      string `json:"error,omitempty"`
+}
+
+// RefreshReport 热门tag刷新报告
+type RefreshReport struct {
+	StartedAt   time.Time     `json:"started_at"`
+	FinishedAt  time.Time     `json:"finished_at"`
+	Top         int           `json:"top"`
+	Fresh       int           `json:"fresh"`
+	Revalidated int           `json:"revalidated"`
+	Updated     int           `json:"updated"`
+	Failed      int           `json:"failed"`
+	Items       []RefreshItem `json:"items"`
+}
+
+// RefreshStatus 热门tag刷新状态
+type RefreshStatus struct {
+	Running    bool           `json:"running"`
+	Interval   int            `json:"interval"`
+	Top        int            `json:"top"`
+	LastReport *RefreshReport `json:"last_report"`
+}
+
+// RefreshService 根据访问日志找出拉取最多的tag，在manifest缓存过期前重新验证，
+// 使热门镜像的拉取始终命中缓存
+type RefreshService struct {
+	db           *gorm.DB
+	proxyService *ProxyService
+	cacheService *CacheService
+	imageService *ImageService
+	groupService *GroupService
+	pushService  *PushRouteService
+	interval     time.Duration
+	top          int
+
+	mu         sync.Mutex
+	running    bool
+	lastReport *RefreshReport
+}
+
+func NewRefreshService(db *gorm.DB, proxyService *ProxyService, cacheService *CacheService, imageService *ImageService, groupService *GroupService, pushService *PushRouteService, interval time.Duration, top int) *RefreshService {
+	return &RefreshService{
+		db:           db,
+		proxyService: proxyService,
+		cacheService: cacheService,
+		imageService: imageService,
+		groupService: groupService,
+		pushService:  pushService,
+		interval:     interval,
+		top:          top,
+	}
+}
+
+// GetStatus 获取刷新配置和最近一次报告
+func (s *RefreshService) GetStatus() RefreshStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return RefreshStatus{
+		Running:    s.running,
+		Interval:   int(s.interval / time.Second),
+		Top:        s.top,
+		LastReport: s.lastReport,
+	}
+}
+
+// Refresh 执行一次热门tag刷新
+func (s *RefreshService) Refresh() (*RefreshReport, error) {
+	if !s.cacheService.Enabled() {
+		return nil, ErrCacheDisabled
+	}
+	if s.proxyService.IsOffline() {
+		return nil, ErrOffline
+	}
+
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return nil, ErrRefreshRunning
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	report, err := s.runRefresh()
+
+	s.mu.Lock()
+	s.running = false
+	if err == nil {
+		s.lastReport = report
+	}
+	s.mu.Unlock()
+
+	if err == nil && report.Revalidated+report.Updated+report.Failed > 0 {
+		log.Printf("Refresh: %d popular tags checked, %d revalidated, %d updated, %d failed",
+			len(report.Items), report.Revalidated, report.Updated, report.Failed)
+	}
+	return report, err
+}
+
+// StartScheduler 启动定时刷新任务
+func (s *RefreshService) StartScheduler() {
+	if !s.cacheService.Enabled() || s.interval <= 0 || s.top <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := s.Refresh(); err != nil && !errors.Is(err, ErrOffline) && !errors.Is(err, ErrRefreshRunning) {
+				log.Printf("Refresh failed: %v", err)
+			}
+		}
+	}()
+}
+
+// runRefresh 刷新拉取次数最多的前N个代理缓存的tag
+func (s *RefreshService) runRefresh() (*RefreshReport, error) {
+	report := &RefreshReport{StartedAt: time.Now(), Top: s.top, Items: []RefreshItem{}}
+
+	items, err := s.popularTags()
+	if err != nil {
+		return nil, err
+	}
+
+	// 距离过期不足该时长的manifest在本次刷新，避免在下一次刷新前过期
+	threshold := s.interval + refreshMargin
+	for i := range items {
+		item := &items[i]
+		s.refreshTag(item, threshold)
+		switch item.Action {
+		case "fresh":
+			report.Fresh++
+		case "revalidated":
+			report.Revalidated++
+		case "updated", "fetched":
+			report.Updated++
+		case "failed":
+			report.Failed++
+		}
+		report.Items = append(report.Items, *item)
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// popularTags 统计访问日志中拉取次数最多的tag。仓库组路径按实际仓库合并，
+// 本地托管的tag和配置了推送路由的仓库不需要刷新
+func (s *RefreshService) popularTags() ([]RefreshItem, error) {
+	if s.top <= 0 {
+		return nil, nil
+	}
+
+	// 认证中间件为每次拉取记录一条带镜像名的日志，代理记录的日志不带镜像名，只统计前者避免重复计数
+	var rows []struct {
+		Path  string
+		Pulls int
+	}
+	err := s.db.Model(&model.AccessLog{}).
+		Select("path, COUNT(*) AS pulls").
+		Where("method = ? AND status_code = ? AND image_name <> '' AND path LIKE ? AND created_at > ?",
+			"GET", 200, "%/manifests/%", time.Now().Add(-refreshStatsWindow)).
+		Group("path").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	pulls := make(map[[2]string]int)
+	for _, row := range rows {
+		name, kind, reference := ParseRegistryPath(row.Path)
+		if kind != "manifests" || reference == "" || IsDigest(reference) {
+			continue
+		}
+		_, name = s.groupService.Resolve(name)
+		route, _, err := s.pushService.Match(name, false)
+		if err != nil {
+			return nil, err
+		}
+		if route != nil {
+			continue
+		}
+		pulls[[2]string{name, reference}] += row.Pulls
+	}
+
+	items := make([]RefreshItem, 0, len(pulls))
+	for key, count := range pulls {
+		items = append(items, RefreshItem{Repository: key[0], Tag: key[1], Pulls: count})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Pulls != items[j].Pulls {
+			return items[i].Pulls > items[j].Pulls
+		}
+		if items[i].Repository != items[j].Repository {
+			return items[i].Repository < items[j].Repository
+		}
+		return items[i].Tag < items[j].Tag
+	})
+
+	var popular []RefreshItem
+	for _, item := range items {
+		if len(popular) >= s.top {
+			break
+		}
+		var cached model.CachedManifest
+		tx := s.db.Where("repository = ? AND reference = ?", item.Repository, item.Tag).Limit(1).Find(&cached)
+		if tx.Error == nil && tx.RowsAffected > 0 && cached.Hosted {
+			continue
+		}
+		popular = append(popular, item)
+	}
+	return popular, nil
+}
+
+// refreshTag 刷新一个tag：未临近过期的跳过；上游digest未变化时只延长有效期，变化时获取新的manifest，
+// 并预取之前已缓存过的平台的内容
+func (s *RefreshService) refreshTag(item *RefreshItem, threshold time.Duration) {
+	var cached model.CachedManifest
+	tx := s.db.Where("repository = ? AND reference = ?", item.Repository, item.Tag).Limit(1).Find(&cached)
+	if tx.Error != nil || tx.RowsAffected == 0 {
+		// 已被淘汰的tag只重新获取manifest
+		fetched, err := s.imageService.fetchManifestFromUpstream(item.Repository, item.Tag)
+		if err != nil {
+			item.Action = "failed"
+			item.Error = err.Error()
+			return
+		}
+		item.Action = "fetched"
+		item.Digest = fetched.Digest
+		return
+	}
+
+	item.Digest = cached.Digest
+	if time.Until(cached.UpdatedAt.Add(s.cacheService.ManifestTTL())) > threshold {
+		item.Action = "fresh"
+		return
+	}
+
+	digest, _, err := s.proxyService.ManifestDigest(item.Repository, item.Tag)
+	if err != nil {
+		item.Action = "failed"
+		item.Error = err.Error()
+		return
+	}
+	if digest == cached.Digest {
+		if err := s.cacheService.RenewManifest(item.Repository, item.Tag); err != nil {
+			item.Action = "failed"
+			item.Error = err.Error()
+			return
+		}
+		item.Action = "revalidated"
+		return
+	}
+
+	result, err := s.imageService.Prefetch(item.Repository, item.Tag, s.cachedPlatforms(item.Repository, cached.Digest))
+	if err != nil {
+		item.Action = "failed"
+		item.Error = err.Error()
+		return
+	}
+	item.Action = "updated"
+	item.Previous = cached.Digest
+	item.Digest = result.Digest
+	item.Blobs = result.Blobs
+}
+
+// cachedPlatforms 返回旧的index中已缓存过的平台。单平台镜像或旧manifest无法读取时返回nil，表示预取全部内容
+func (s *RefreshService) cachedPlatforms(repository, digest string) []string {
+	content, err := s.cacheService.readBlob(digest)
+	if err != nil {
+		return nil
+	}
+	parsed, err := ParseManifest(content)
+	if err != nil || !parsed.IsIndex() {
+		return nil
+	}
+
+	platforms := []string{}
+	for _, child := range parsed.Manifests {
+		if child.Platform == nil {
+			continue
+		}
+		var count int64
+		s.db.Model(&model.CachedManifest{}).Where("repository = ? AND reference = ?", repository, child.Digest).Count(&count)
+		if count > 0 {
+			platforms = append(platforms, child.Platform.String())
+		}
+	}
+	return platforms
+}
@@ -0,0 +1,357 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RemoteRegistry 访问远程镜像仓库的客户端，支持Basic认证和Bearer token认证
+type RemoteRegistry struct {
+	baseURL  string
+	username string
+	password string
+	client   *http.Client
+
+	mu             sync.Mutex
+	authorizations map[string]remoteAuthorization // scope -> 认证信息
+}
+
+// remoteAuthorization 为某个scope获取的认证头，Bearer token有过期时间
+type remoteAuthorization struct {
+	header    string
+	expiresAt time.Time // 零值表示不过期
+}
+
+// tokenExpiryMargin 在token过期前提前重新申请，避免长时间上传中途失效
+const tokenExpiryMargin = 30 * time.Second
+
+// errBodyConsumed 请求体只能发送一次，收到401后无法重试
+var errBodyConsumed = errors.New("request body already sent")
+
+func NewRemoteRegistry(baseURL, username, password string) *RemoteRegistry {
+	return &RemoteRegistry{
+		baseURL:        strings.TrimRight(baseURL, "/"),
+		username:       username,
+		password:       password,
+		client:         &http.Client{Timeout: 10 * time.Minute},
+		authorizations: make(map[string]remoteAuthorization),
+	}
+}
+
+// bodyFunc 每次调用返回新的请求体，401后重试时需要重新读取
+type bodyFunc func() (io.ReadCloser, error)
+
+// resolveURL 将相对路径（如上传返回的Location）转换为完整URL
+func (r *RemoteRegistry) resolveURL(location string) string {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return location
+	}
+	if !strings.HasPrefix(location, "/") {
+		location = "/" + location
+	}
+	return r.baseURL + location
+}
+
+// do 发送请求，收到401时按WWW-Authenticate获取凭证后重试一次
+func (r *RemoteRegistry) do(method, location string, header http.Header, body bodyFunc, size int64, scope string) (*http.Response, error) {
+	targetURL := r.resolveURL(location)
+
+	send := func(authorization string) (*http.Response, error) {
+		var reader io.ReadCloser
+		if body != nil {
+			var err error
+			if reader, err = body(); err != nil {
+				return nil, err
+			}
+		}
+		req, err := http.NewRequest(method, targetURL, reader)
+		if err != nil {
+			if reader != nil {
+				reader.Close()
+			}
+			return nil, err
+		}
+		for name, values := range header {
+			for _, value := range values {
+				req.Header.Add(name, value)
+			}
+		}
+		if body != nil {
+			req.ContentLength = size
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		return r.client.Do(req)
+	}
+
+	resp, err := send(r.cachedAuthorization(scope))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	authorization, err := r.authorize(challenge, scope)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.authorizations[scope] = authorization
+	r.mu.Unlock()
+	return send(authorization.header)
+}
+
+// cachedAuthorization 返回之前为该scope获取且未过期的认证头
+func (r *RemoteRegistry) cachedAuthorization(scope string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	authorization, ok := r.authorizations[scope]
+	if !ok {
+		return ""
+	}
+	if !authorization.expiresAt.IsZero() && time.Now().After(authorization.expiresAt.Add(-tokenExpiryMargin)) {
+		delete(r.authorizations, scope)
+		return ""
+	}
+	return authorization.header
+}
+
+// authorize 根据认证质询生成认证头，Bearer认证时向token服务申请指定scope的token
+func (r *RemoteRegistry) authorize(challenge, scope string) (remoteAuthorization, error) {
+	scheme, params := parseChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if r.username == "" {
+			return remoteAuthorization{}, fmt.Errorf("registry requires credentials")
+		}
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(r.username, r.password)
+		return remoteAuthorization{header: req.Header.Get("Authorization")}, nil
+
+	case "bearer":
+		realm := params["realm"]
+		if realm == "" {
+			return remoteAuthorization{}, fmt.Errorf("no realm found in auth header")
+		}
+		query := url.Values{}
+		if params["service"] != "" {
+			query.Set("service", params["service"])
+		}
+		if scope == "" {
+			scope = params["scope"]
+		}
+		if scope != "" {
+			query.Set("scope", scope)
+		}
+		tokenURL := realm
+		if len(query) > 0 {
+			tokenURL += "?" + query.Encode()
+		}
+
+		req, err := http.NewRequest("GET", tokenURL, nil)
+		if err != nil {
+			return remoteAuthorization{}, err
+		}
+		if r.username != "" {
+			req.SetBasicAuth(r.username, r.password)
+		}
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return remoteAuthorization{}, fmt.Errorf("token request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return remoteAuthorization{}, fmt.Errorf("token request failed with status: %d", resp.StatusCode)
+		}
+
+		var tokenResp struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+			ExpiresIn   int    `json:"expires_in"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+			return remoteAuthorization{}, fmt.Errorf("failed to decode token response: %v", err)
+		}
+		token := tokenResp.Token
+		if token == "" {
+			token = tokenResp.AccessToken
+		}
+		if token == "" {
+			return remoteAuthorization{}, fmt.Errorf("no token found in response")
+		}
+		// 未返回有效期时按规范默认60秒
+		expiresIn := tokenResp.ExpiresIn
+		if expiresIn <= 0 {
+			expiresIn = 60
+		}
+		return remoteAuthorization{
+			header:    "Bearer " + token,
+			expiresAt: time.Now().Add(time.Duration(expiresIn) * time.Second),
+		}, nil
+	}
+
+	return remoteAuthorization{}, fmt.Errorf("unsupported auth challenge: %s", challenge)
+}
+
+// parseChallenge 解析WWW-Authenticate头，返回小写的认证方式和参数
+func parseChallenge(header string) (string, map[string]string) {
+	params := make(map[string]string)
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	for rest != "" {
+		var part string
+		// 参数值可能包含逗号（如scope），按引号边界切分
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.TrimSpace(strings.TrimLeft(key, ", "))
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[strings.ToLower(key)] = value[1:]
+				break
+			}
+			part, rest = value[1:end+1], value[end+2:]
+		} else {
+			part, rest, _ = strings.Cut(value, ",")
+		}
+		params[strings.ToLower(key)] = part
+	}
+	return strings.ToLower(scheme), params
+}
+
+// repositoryScope 仓库的pull和push权限scope
+func repositoryScope(repository string) string {
+	return "repository:" + repository + ":pull,push"
+}
+
+// BlobExists 检查远程仓库中是否已存在blob
+func (r *RemoteRegistry) BlobExists(repository, digest string) (bool, error) {
+	resp, err := r.do("HEAD", "/v2/"+repository+"/blobs/"+digest, nil, nil, 0, repositoryScope(repository))
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("HEAD blob %s: unexpected status %d", digest, resp.StatusCode)
+}
+
+// UploadBlob 以单次PUT上传blob
+func (r *RemoteRegistry) UploadBlob(repository, digest string, size int64, body bodyFunc) error {
+	scope := repositoryScope(repository)
+	resp, err := r.do("POST", "/v2/"+repository+"/blobs/uploads/", nil, nil, 0, scope)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("start upload %s: unexpected status %d", digest, resp.StatusCode)
+	}
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return fmt.Errorf("start upload %s: missing Location header", digest)
+	}
+
+	separator := "?"
+	if strings.Contains(location, "?") {
+		separator = "&"
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	resp, err = r.do("PUT", location+separator+"digest="+url.QueryEscape(digest), header, body, size, scope)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("upload %s: unexpected status %d", digest, resp.StatusCode)
+	}
+	return nil
+}
+
+// ManifestDigest 查询远程仓库中manifest的digest，不存在时返回空字符串
+func (r *RemoteRegistry) ManifestDigest(repository, reference string) (string, error) {
+	header := http.Header{}
+	header.Set("Accept", manifestAccept)
+	resp, err := r.do("HEAD", "/v2/"+repository+"/manifests/"+reference, header, nil, 0, repositoryScope(repository))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Header.Get("Docker-Content-Digest"), nil
+	case http.StatusNotFound:
+		return "", nil
+	}
+	return "", fmt.Errorf("HEAD manifest %s: unexpected status %d", reference, resp.StatusCode)
+}
+
+// PutManifest 上传manifest
+func (r *RemoteRegistry) PutManifest(repository, reference string, content []byte, mediaType string) error {
+	header := http.Header{}
+	header.Set("Content-Type", mediaType)
+	body := func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(content)), nil
+	}
+	resp, err := r.do("PUT", "/v2/"+repository+"/manifests/"+reference, header, body, int64(len(content)), repositoryScope(repository))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("put manifest %s: unexpected status %d: %s", reference, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// Forward 转发一个请求，请求体只读取一次。发送请求体之前先确保已取得该仓库pull和push权限的凭证
+func (r *RemoteRegistry) Forward(method, location string, header http.Header, body io.Reader, size int64, repository string) (*http.Response, error) {
+	scope := repositoryScope(repository)
+	if body == nil {
+		return r.do(method, location, header, nil, 0, scope)
+	}
+
+	if r.cachedAuthorization(scope) == "" {
+		resp, err := r.do("GET", "/v2/", nil, nil, 0, scope)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+	}
+
+	sent := false
+	once := func() (io.ReadCloser, error) {
+		if sent {
+			return nil, errBodyConsumed
+		}
+		sent = true
+		return io.NopCloser(body), nil
+	}
+	return r.do(method, location, header, once, size, scope)
+}
+
+// RelativeLocation 将指向远程仓库的Location转换为相对路径，使客户端后续请求仍经过本服务
+func (r *RemoteRegistry) RelativeLocation(location string) string {
+	if rest, ok := strings.CutPrefix(location, r.baseURL); ok && strings.HasPrefix(rest, "/") {
+		return rest
+	}
+	return location
+}
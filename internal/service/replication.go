@@ -0,0 +1,381 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"zmirror/internal/model"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// replicationRetryDelay 失败后重试的基础等待时间，按尝试次数递增
+	replicationRetryDelay = 30 * time.Second
+
+	// replicationCheckInterval 定时复制任务的检查间隔
+	replicationCheckInterval = time.Minute
+)
+
+// ErrReplicationRunning 该规则已有复制任务正在运行
+var ErrReplicationRunning = errors.New("replication is already running for this rule")
+
+type ReplicationService struct {
+	db           *gorm.DB
+	cacheService *CacheService
+
+	mu      sync.Mutex
+	running map[uint]bool
+	pending map[uint][]string // 规则运行期间推送的仓库，当前任务结束后再复制
+}
+
+func NewReplicationService(db *gorm.DB, cacheService *CacheService) *ReplicationService {
+	return &ReplicationService{
+		db:           db,
+		cacheService: cacheService,
+		running:      make(map[uint]bool),
+		pending:      make(map[uint][]string),
+	}
+}
+
+// GetAllRules 获取所有复制规则，不返回密码
+func (s *ReplicationService) GetAllRules() ([]model.ReplicationRule, error) {
+	var rules []model.ReplicationRule
+	if err := s.db.Order("id ASC").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		rules[i].Password = ""
+	}
+	return rules, nil
+}
+
+// CreateRule 创建复制规则
+func (s *ReplicationService) CreateRule(rule *model.ReplicationRule) error {
+	if err := validateReplicationRule(rule); err != nil {
+		return err
+	}
+	return s.db.Create(rule).Error
+}
+
+// UpdateRule 更新复制规则，密码为空时保留原密码
+func (s *ReplicationService) UpdateRule(rule *model.ReplicationRule) error {
+	if err := validateReplicationRule(rule); err != nil {
+		return err
+	}
+
+	var existing model.ReplicationRule
+	if err := s.db.First(&existing, rule.ID).Error; err != nil {
+		return err
+	}
+	if rule.Password == "" {
+		rule.Password = existing.Password
+	}
+	rule.LastRunAt = existing.LastRunAt
+	return s.db.Save(rule).Error
+}
+
+// DeleteRule 删除复制规则及其执行记录
+func (s *ReplicationService) DeleteRule(id uint) error {
+	if err := s.db.Where("rule_id = ?", id).Delete(&model.ReplicationRun{}).Error; err != nil {
+		return err
+	}
+	return s.db.Delete(&model.ReplicationRule{}, id).Error
+}
+
+// validateReplicationRule 校验复制规则
+func validateReplicationRule(rule *model.ReplicationRule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !strings.HasPrefix(rule.TargetURL, "http://") && !strings.HasPrefix(rule.TargetURL, "https://") {
+		return fmt.Errorf("invalid target url: %s", rule.TargetURL)
+	}
+	rule.TargetNamespace = strings.Trim(rule.TargetNamespace, "/")
+	return nil
+}
+
+// GetRuns 获取执行记录，ruleID为0时返回所有规则的记录
+func (s *ReplicationService) GetRuns(ruleID uint, limit int) ([]model.ReplicationRun, error) {
+	var runs []model.ReplicationRun
+	query := s.db.Order("id DESC").Limit(limit)
+	if ruleID > 0 {
+		query = query.Where("rule_id = ?", ruleID)
+	}
+	err := query.Find(&runs).Error
+	return runs, err
+}
+
+// Trigger 手动触发复制，后台执行
+func (s *ReplicationService) Trigger(ruleID uint) (*model.ReplicationRun, error) {
+	var rule model.ReplicationRule
+	if err := s.db.First(&rule, ruleID).Error; err != nil {
+		return nil, err
+	}
+	return s.start(&rule, "manual", nil)
+}
+
+// TriggerPush 本地托管内容写入后，触发匹配这些仓库的复制规则
+func (s *ReplicationService) TriggerPush(repositories []string) {
+	var rules []model.ReplicationRule
+	if err := s.db.Where("enabled = ? AND trigger_on_push = ?", true, true).Find(&rules).Error; err != nil {
+		log.Printf("Replication: failed to load rules: %v", err)
+		return
+	}
+
+	for i := range rules {
+		var matched []string
+		for _, repository := range repositories {
+			if matchReplicationFilter(rules[i].Filter, repository) {
+				matched = append(matched, repository)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		s.startPush(&rules[i], matched)
+	}
+}
+
+// startPush 启动推送触发的复制。规则正在运行时记录这些仓库，当前任务结束后再复制
+func (s *ReplicationService) startPush(rule *model.ReplicationRule, repositories []string) {
+	for {
+		_, err := s.start(rule, "push", repositories)
+		if !errors.Is(err, ErrReplicationRunning) {
+			if err != nil {
+				log.Printf("Replication: rule %s not started: %v", rule.Name, err)
+			}
+			return
+		}
+
+		s.mu.Lock()
+		if s.running[rule.ID] {
+			for _, repository := range repositories {
+				if !containsString(s.pending[rule.ID], repository) {
+					s.pending[rule.ID] = append(s.pending[rule.ID], repository)
+				}
+			}
+			s.mu.Unlock()
+			return
+		}
+		// 正在运行的任务刚好结束，重新尝试启动
+		s.mu.Unlock()
+	}
+}
+
+// StartScheduler 启动定时复制任务
+func (s *ReplicationService) StartScheduler() {
+	go func() {
+		ticker := time.NewTicker(replicationCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			var rules []model.ReplicationRule
+			if err := s.db.Where("enabled = ? AND schedule > ?", true, 0).Find(&rules).Error; err != nil {
+				log.Printf("Replication: failed to load rules: %v", err)
+				continue
+			}
+			for i := range rules {
+				rule := &rules[i]
+				if rule.LastRunAt != nil && time.Since(*rule.LastRunAt) < time.Duration(rule.Schedule)*time.Minute {
+					continue
+				}
+				if _, err := s.start(rule, "schedule", nil); err != nil && !errors.Is(err, ErrReplicationRunning) {
+					log.Printf("Replication: rule %s not started: %v", rule.Name, err)
+				}
+			}
+		}
+	}()
+}
+
+// start 创建执行记录并在后台执行复制，repositories为nil时复制规则匹配的全部仓库
+func (s *ReplicationService) start(rule *model.ReplicationRule, trigger string, repositories []string) (*model.ReplicationRun, error) {
+	s.mu.Lock()
+	if s.running[rule.ID] {
+		s.mu.Unlock()
+		return nil, ErrReplicationRunning
+	}
+	s.running[rule.ID] = true
+	s.mu.Unlock()
+
+	now := time.Now()
+	run := &model.ReplicationRun{
+		RuleID:    rule.ID,
+		Trigger:   trigger,
+		Status:    "running",
+		StartedAt: now,
+	}
+	if err := s.db.Create(run).Error; err != nil {
+		s.finish(rule.ID)
+		return nil, err
+	}
+	s.db.Model(&model.ReplicationRule{}).Where("id = ?", rule.ID).UpdateColumn("last_run_at", now)
+
+	go func() {
+		defer s.finish(rule.ID)
+		s.execute(rule, run, repositories)
+	}()
+	return run, nil
+}
+
+// finish 标记规则的复制任务已结束，运行期间有推送时再复制这些仓库
+func (s *ReplicationService) finish(ruleID uint) {
+	s.mu.Lock()
+	delete(s.running, ruleID)
+	pending := s.pending[ruleID]
+	delete(s.pending, ruleID)
+	s.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+	var rule model.ReplicationRule
+	if err := s.db.Where("enabled = ? AND trigger_on_push = ?", true, true).First(&rule, ruleID).Error; err != nil {
+		log.Printf("Replication: pending repositories of rule %d dropped: %v", ruleID, err)
+		return
+	}
+	s.startPush(&rule, pending)
+}
+
+// execute 执行复制，失败后按规则配置重试
+func (s *ReplicationService) execute(rule *model.ReplicationRule, run *model.ReplicationRun, repositories []string) {
+	log.Printf("Replication: rule %s started (%s)", rule.Name, run.Trigger)
+
+	var err error
+	for attempt := 1; attempt <= rule.MaxRetries+1; attempt++ {
+		run.Attempts = attempt
+		s.db.Save(run)
+
+		if err = s.replicate(rule, run, repositories); err == nil {
+			break
+		}
+		log.Printf("Replication: rule %s attempt %d failed: %v", rule.Name, attempt, err)
+		if attempt <= rule.MaxRetries {
+			time.Sleep(time.Duration(attempt) * replicationRetryDelay)
+		}
+	}
+
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	run.Status = "success"
+	run.Error = ""
+	if err != nil {
+		run.Status = "failed"
+		run.Error = err.Error()
+	}
+	s.db.Save(run)
+
+	log.Printf("Replication: rule %s %s: %d images copied, %d up to date, %d blobs (%d bytes) copied, %d blobs skipped",
+		rule.Name, run.Status, run.Images, run.ImagesSkipped, run.BlobsCopied, run.BytesCopied, run.BlobsSkipped)
+}
+
+// matchReplicationFilter 判断仓库是否匹配规则的源仓库过滤条件
+func matchReplicationFilter(filter, repository string) bool {
+	return filter == "" || MatchRepository(filter, repository)
+}
+
+// replicate 复制一次规则匹配的所有托管镜像，单个镜像失败不影响其他镜像，最后汇总返回错误
+func (s *ReplicationService) replicate(rule *model.ReplicationRule, run *model.ReplicationRun, repositories []string) error {
+	if !s.cacheService.Enabled() {
+		return ErrCacheDisabled
+	}
+
+	var manifests []model.CachedManifest
+	query := s.db.Where("hosted = ?", true).Order("repository ASC, reference ASC")
+	if repositories != nil {
+		query = query.Where("repository IN ?", repositories)
+	}
+	if err := query.Find(&manifests).Error; err != nil {
+		return err
+	}
+
+	remote := NewRemoteRegistry(rule.TargetURL, rule.Username, rule.Password)
+	var failures []string
+	for _, m := range manifests {
+		if IsDigest(m.Reference) || !matchReplicationFilter(rule.Filter, m.Repository) {
+			continue
+		}
+
+		target := m.Repository
+		if rule.TargetNamespace != "" {
+			target = path.Join(rule.TargetNamespace, m.Repository)
+		}
+
+		current, err := remote.ManifestDigest(target, m.Reference)
+		if err == nil && current == m.Digest {
+			run.ImagesSkipped++
+			continue
+		}
+		if err := s.copyManifest(remote, run, target, m.Reference, m.Digest, m.MediaType); err != nil {
+			failures = append(failures, fmt.Sprintf("%s:%s: %v", m.Repository, m.Reference, err))
+			continue
+		}
+		run.Images++
+		s.db.Save(run)
+	}
+
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// copyManifest 复制manifest引用的所有内容，最后上传manifest本身。index会先复制各平台的manifest
+func (s *ReplicationService) copyManifest(remote *RemoteRegistry, run *model.ReplicationRun, repository, reference, digest, mediaType string) error {
+	content, err := s.cacheService.readBlob(digest)
+	if err != nil {
+		return fmt.Errorf("manifest %s is missing from local storage", digest)
+	}
+	parsed, err := ParseManifest(content)
+	if err != nil {
+		return err
+	}
+	if mediaType == "" {
+		mediaType = parsed.MediaType
+	}
+
+	for _, child := range parsed.Manifests {
+		if err := s.copyManifest(remote, run, repository, child.Digest, child.Digest, child.MediaType); err != nil {
+			return err
+		}
+	}
+	for _, blob := range parsed.BlobReferences() {
+		if err := s.copyBlob(remote, run, repository, blob); err != nil {
+			return err
+		}
+	}
+
+	return remote.PutManifest(repository, reference, content, mediaType)
+}
+
+// copyBlob 目标仓库中不存在时上传blob
+func (s *ReplicationService) copyBlob(remote *RemoteRegistry, run *model.ReplicationRun, repository, digest string) error {
+	exists, err := remote.BlobExists(repository, digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		run.BlobsSkipped++
+		return nil
+	}
+
+	blob, err := s.cacheService.StatBlob(digest)
+	if err != nil {
+		return fmt.Errorf("blob %s is missing from local storage", digest)
+	}
+	open := func() (io.ReadCloser, error) {
+		return os.Open(s.cacheService.blobPath(digest))
+	}
+	if err := remote.UploadBlob(repository, digest, blob.Size, open); err != nil {
+		return err
+	}
+	run.BlobsCopied++
+	run.BytesCopied += blob.Size
+	return nil
+}
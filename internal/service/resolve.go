@@ -0,0 +1,127 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// ResolvedPlatform 单个平台的manifest摘要
+type ResolvedPlatform struct {
+	Platform     *Platform `json:"platform,omitempty"`
+	Digest       string    `json:"digest"`
+	MediaType    string    `json:"media_type"`
+	ConfigDigest string    `json:"config_digest"`
+	Layers       int       `json:"layers"`
+	Size         int64     `json:"size"` // 镜像层压缩后的总大小
+}
+
+// ResolvedImage 镜像引用解析结果，Digest为tag当前指向的index或manifest的digest
+type ResolvedImage struct {
+	Repository string             `json:"repository"`
+	Reference  string             `json:"reference"`
+	Digest     string             `json:"digest"`
+	MediaType  string             `json:"media_type"`
+	Upstream   string             `json:"upstream"`
+	Platforms  []ResolvedPlatform `json:"platforms"`
+}
+
+// Resolve 解析镜像引用，沿index获取各平台的manifest digest、配置digest和镜像层大小。
+// platform不为空时只返回该平台
+func (s *ImageService) Resolve(ref, platform string) (*ResolvedImage, error) {
+	repository, reference, err := ParseImageReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	var wanted *Platform
+	if platform != "" {
+		if wanted, err = ParsePlatform(platform); err != nil {
+			return nil, err
+		}
+	}
+
+	fetched, err := s.FetchManifest(repository, reference)
+	if err != nil {
+		return nil, err
+	}
+	resolved := &ResolvedImage{
+		Repository: repository,
+		Reference:  reference,
+		Digest:     fetched.Digest,
+		MediaType:  fetched.MediaType,
+		Upstream:   fetched.Upstream,
+		Platforms:  []ResolvedPlatform{},
+	}
+
+	if !fetched.Manifest.IsIndex() {
+		p, err := s.resolvePlatform(repository, fetched.Digest, fetched.MediaType, nil, fetched.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		if wanted != nil && (p.Platform == nil || p.Platform.OS != wanted.OS || p.Platform.Architecture != wanted.Architecture ||
+			(wanted.Variant != "" && p.Platform.Variant != wanted.Variant)) {
+			return nil, ErrPlatformNotFound
+		}
+		resolved.Platforms = append(resolved.Platforms, *p)
+		return resolved, nil
+	}
+
+	children := fetched.Manifest.Manifests
+	if wanted != nil {
+		child, err := SelectPlatform(fetched.Manifest, wanted)
+		if err != nil {
+			return nil, err
+		}
+		children = []Descriptor{*child}
+	}
+	for _, child := range children {
+		childManifest, err := s.FetchManifest(repository, child.Digest)
+		if err != nil {
+			return nil, err
+		}
+		mediaType := child.MediaType
+		if mediaType == "" {
+			mediaType = childManifest.MediaType
+		}
+		p, err := s.resolvePlatform(repository, child.Digest, mediaType, child.Platform, childManifest.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		resolved.Platforms = append(resolved.Platforms, *p)
+	}
+	return resolved, nil
+}
+
+// resolvePlatform 汇总单个平台manifest的配置digest和镜像层大小，index中没有平台信息时从配置中读取
+func (s *ImageService) resolvePlatform(repository, digest, mediaType string, platform *Platform, manifest *Manifest) (*ResolvedPlatform, error) {
+	result := &ResolvedPlatform{
+		Platform:  platform,
+		Digest:    digest,
+		MediaType: mediaType,
+		Layers:    len(manifest.Layers),
+	}
+	for _, layer := range manifest.Layers {
+		result.Size += layer.Size
+	}
+	if manifest.Config == nil {
+		return result, nil
+	}
+	result.ConfigDigest = manifest.Config.Digest
+	if platform != nil {
+		return result, nil
+	}
+
+	r, err := s.OpenBlob(repository, manifest.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	content, err := io.ReadAll(io.LimitReader(r, MaxManifestSize))
+	if err != nil {
+		return nil, err
+	}
+	var config ImageConfig
+	if err := json.Unmarshal(content, &config); err == nil && config.OS != "" {
+		result.Platform = &Platform{Architecture: config.Architecture, OS: config.OS, Variant: config.Variant}
+	}
+	return result, nil
+}
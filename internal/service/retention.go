@@ -0,0 +1,239 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"time"
+
+	"zmirror/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// RetentionService 托管仓库的tag保留策略
+type RetentionService struct {
+	db           *gorm.DB
+	cacheService *CacheService
+}
+
+func NewRetentionService(db *gorm.DB, cacheService *CacheService) *RetentionService {
+	return &RetentionService{db: db, cacheService: cacheService}
+}
+
+// GetAllPolicies 获取所有保留策略，按优先级排序
+func (s *RetentionService) GetAllPolicies() ([]model.RetentionPolicy, error) {
+	var policies []model.RetentionPolicy
+	err := s.db.Order("priority ASC, id ASC").Find(&policies).Error
+	return policies, err
+}
+
+// CreatePolicy 创建保留策略
+func (s *RetentionService) CreatePolicy(policy *model.RetentionPolicy) error {
+	if err := validateRetentionPolicy(policy); err != nil {
+		return err
+	}
+	return s.db.Create(policy).Error
+}
+
+// UpdatePolicy 更新保留策略
+func (s *RetentionService) UpdatePolicy(policy *model.RetentionPolicy) error {
+	if err := validateRetentionPolicy(policy); err != nil {
+		return err
+	}
+	return s.db.Save(policy).Error
+}
+
+// DeletePolicy 删除保留策略
+func (s *RetentionService) DeletePolicy(id uint) error {
+	return s.db.Delete(&model.RetentionPolicy{}, id).Error
+}
+
+// validateRetentionPolicy 校验保留策略
+func validateRetentionPolicy(policy *model.RetentionPolicy) error {
+	if policy.Pattern == "" {
+		return fmt.Errorf("pattern is required")
+	}
+	if policy.KeepLast < 0 || policy.UntaggedDays < 0 {
+		return fmt.Errorf("keep_last and untagged_days must not be negative")
+	}
+	if policy.KeepPattern != "" {
+		if _, err := regexp.Compile(policy.KeepPattern); err != nil {
+			return fmt.Errorf("invalid keep pattern: %v", err)
+		}
+	}
+	return nil
+}
+
+// RetentionDeletion 保留策略删除的一个manifest引用
+type RetentionDeletion struct {
+	Repository string `json:"repository"`
+	Reference  string `json:"reference"`
+	Digest     string `json:"digest"`
+	Reason     string `json:"reason"`
+}
+
+// RetentionReport 保留策略执行结果
+type RetentionReport struct {
+	DryRun          bool                `json:"dry_run"`
+	Repositories    int                 `json:"repositories"`
+	TagsDeleted     int                 `json:"tags_deleted"`
+	UntaggedDeleted int                 `json:"untagged_deleted"`
+	Deleted         []RetentionDeletion `json:"deleted"`
+	GC              *GCResult           `json:"gc,omitempty"`
+}
+
+// Apply 对所有托管仓库执行保留策略。只删除manifest引用，不再被引用的blob交给垃圾回收处理
+func (s *RetentionService) Apply(dryRun bool) (*RetentionReport, error) {
+	if !s.cacheService.Enabled() {
+		return nil, ErrCacheDisabled
+	}
+
+	var policies []model.RetentionPolicy
+	if err := s.db.Where("enabled = ?", true).Order("priority ASC, id ASC").Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	report := &RetentionReport{DryRun: dryRun, Deleted: []RetentionDeletion{}}
+	if len(policies) == 0 {
+		return report, nil
+	}
+
+	var manifests []model.CachedManifest
+	if err := s.db.Where("hosted = ?", true).Find(&manifests).Error; err != nil {
+		return nil, err
+	}
+	byRepository := make(map[string][]model.CachedManifest)
+	for _, m := range manifests {
+		byRepository[m.Repository] = append(byRepository[m.Repository], m)
+	}
+
+	var ids []uint
+	for repository, rows := range byRepository {
+		policy := matchRetentionPolicy(policies, repository)
+		if policy == nil {
+			continue
+		}
+		report.Repositories++
+		for _, m := range s.retentionExpired(policy, rows) {
+			ids = append(ids, m.ID)
+			reason := "untagged"
+			if IsDigest(m.Reference) {
+				report.UntaggedDeleted++
+			} else {
+				reason = "tag"
+				report.TagsDeleted++
+			}
+			report.Deleted = append(report.Deleted, RetentionDeletion{
+				Repository: m.Repository,
+				Reference:  m.Reference,
+				Digest:     m.Digest,
+				Reason:     reason,
+			})
+		}
+	}
+
+	sort.Slice(report.Deleted, func(i, j int) bool {
+		if report.Deleted[i].Repository != report.Deleted[j].Repository {
+			return report.Deleted[i].Repository < report.Deleted[j].Repository
+		}
+		return report.Deleted[i].Reference < report.Deleted[j].Reference
+	})
+	if dryRun || len(ids) == 0 {
+		return report, nil
+	}
+
+	if err := s.db.Delete(&model.CachedManifest{}, ids).Error; err != nil {
+		return nil, err
+	}
+	gc, err := s.cacheService.GarbageCollect(false)
+	if err != nil {
+		return report, err
+	}
+	report.GC = gc
+	return report, nil
+}
+
+// matchRetentionPolicy 返回第一个匹配仓库的保留策略，policies需已按优先级排序
+func matchRetentionPolicy(policies []model.RetentionPolicy, repository string) *model.RetentionPolicy {
+	for i := range policies {
+		if MatchRepository(policies[i].Pattern, repository) {
+			return &policies[i]
+		}
+	}
+	return nil
+}
+
+// retentionExpired 计算一个托管仓库中按策略应删除的tag和未被tag引用的manifest
+func (s *RetentionService) retentionExpired(policy *model.RetentionPolicy, rows []model.CachedManifest) []model.CachedManifest {
+	var tags, digests []model.CachedManifest
+	for _, m := range rows {
+		if IsDigest(m.Reference) {
+			digests = append(digests, m)
+		} else {
+			tags = append(tags, m)
+		}
+	}
+
+	// 最近写入的tag排在前面
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].UpdatedAt.After(tags[j].UpdatedAt)
+	})
+
+	var keepPattern *regexp.Regexp
+	if policy.KeepPattern != "" {
+		keepPattern, _ = regexp.Compile(policy.KeepPattern)
+	}
+
+	// 匹配保留正则的tag不计入保留数量
+	var kept, expired []model.CachedManifest
+	count := 0
+	for _, tag := range tags {
+		if keepPattern != nil && keepPattern.MatchString(tag.Reference) {
+			kept = append(kept, tag)
+			continue
+		}
+		count++
+		if policy.KeepLast > 0 && count > policy.KeepLast {
+			expired = append(expired, tag)
+		} else {
+			kept = append(kept, tag)
+		}
+	}
+
+	if policy.UntaggedDays <= 0 {
+		return expired
+	}
+
+	// digest引用只要仍能从保留的tag到达就保留，否则超过期限后删除
+	reachable := s.cacheService.markReferences(kept)
+	cutoff := time.Now().Add(-time.Duration(policy.UntaggedDays) * 24 * time.Hour)
+	for _, m := range digests {
+		if !reachable[m.Digest] && m.UpdatedAt.Before(cutoff) {
+			expired = append(expired, m)
+		}
+	}
+	return expired
+}
+
+// StartScheduler 启动定时执行保留策略的任务
+func (s *RetentionService) StartScheduler(interval time.Duration) {
+	if !s.cacheService.Enabled() || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			report, err := s.Apply(false)
+			if err != nil {
+				log.Printf("Retention failed: %v", err)
+				continue
+			}
+			if len(report.Deleted) > 0 {
+				log.Printf("Retention deleted %d tags and %d untagged manifests", report.TagsDeleted, report.UntaggedDeleted)
+			}
+		}
+	}()
+}
@@ -0,0 +1,39 @@
+package service
+
+import "fmt"
+
+// 管理员角色，普通用户的角色为空
+const (
+	RoleSuperAdmin = "superadmin" // 全部权限，包括用户、令牌、访问规则和白名单
+	RoleOperator   = "operator"   // 管理镜像源、缓存、复制等运维配置，镜像仓库中具有管理员权限
+	RoleAuditor    = "auditor"    // 只读访问管理API
+)
+
+// roleRanks 角色的权限等级，高等级包含低等级的全部权限
+var roleRanks = map[string]int{
+	RoleAuditor:    1,
+	RoleOperator:   2,
+	RoleSuperAdmin: 3,
+}
+
+// validateRole 校验角色，空字符串表示普通用户
+func validateRole(role string) error {
+	if role == "" {
+		return nil
+	}
+	if _, ok := roleRanks[role]; !ok {
+		return fmt.Errorf("role must be superadmin, operator or auditor")
+	}
+	return nil
+}
+
+// RoleAtLeast 判断角色是否具有required角色的权限
+func RoleAtLeast(role, required string) bool {
+	rank, ok := roleRanks[role]
+	return ok && rank >= roleRanks[required]
+}
+
+// isRegistryAdmin 判断角色在镜像仓库中是否具有管理员权限：可以推送、删除，不受访问规则限制
+func isRegistryAdmin(role string) bool {
+	return role == RoleSuperAdmin || role == RoleOperator
+}
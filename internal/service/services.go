@@ -1,12 +1,19 @@
 package service
 
 import (
-	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"zmirror/internal/model"
@@ -16,17 +23,47 @@ import (
 
 type RegistryService struct {
 	db *gorm.DB
+
+	// 启用的镜像源缓存在内存中，避免每个请求都查询数据库；通过管理API修改后整体替换
+	mu         sync.Mutex
+	enabled    atomic.Pointer[[]model.Registry]
+	generation atomic.Uint64 // 每次重新加载时递增，依赖镜像源配置的其他缓存据此判断是否过期
 }
 
 func NewRegistryService(db *gorm.DB) *RegistryService {
 	return &RegistryService{db: db}
 }
 
-// GetEnabledRegistries 获取启用的镜像源，按优先级排序
+// GetEnabledRegistries 获取启用的镜像源，按优先级排序。返回的切片是缓存的副本，调用方可以修改
 func (s *RegistryService) GetEnabledRegistries() ([]model.Registry, error) {
+	if cached := s.enabled.Load(); cached != nil {
+		return slices.Clone(*cached), nil
+	}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return slices.Clone(*s.enabled.Load()), nil
+}
+
+// reload 重新从数据库加载启用的镜像源。加锁保证并发加载时最后写入的是修改之后查询到的结果，
+// 加载失败时清空缓存，下次读取再重试
+func (s *RegistryService) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generation.Add(1)
+
 	var registries []model.Registry
-	err := s.db.Where("enabled = ?", true).Order("priority ASC").Find(&registries).Error
-	return registries, err
+	if err := s.db.Where("enabled = ?", true).Order("priority ASC").Find(&registries).Error; err != nil {
+		s.enabled.Store(nil)
+		return err
+	}
+	s.enabled.Store(&registries)
+	return nil
+}
+
+// Generation 返回镜像源配置的版本号，创建、修改或删除镜像源后变化
+func (s *RegistryService) Generation() uint64 {
+	return s.generation.Load()
 }
 
 // GetAllRegistries 获取所有镜像源
@@ -38,124 +75,344 @@ func (s *RegistryService) GetAllRegistries() ([]model.Registry, error) {
 
 // CreateRegistry 创建镜像源
 func (s *RegistryService) CreateRegistry(registry *model.Registry) error {
-	return s.db.Create(registry).Error
+	if err := s.db.Create(registry).Error; err != nil {
+		return err
+	}
+	return s.reload()
+}
+
+// GetRegistry 获取镜像源
+func (s *RegistryService) GetRegistry(id uint) (*model.Registry, error) {
+	var registry model.Registry
+	if err := s.db.First(&registry, id).Error; err != nil {
+		return nil, err
+	}
+	return &registry, nil
 }
 
-// UpdateRegistry 更新镜像源
+// UpdateRegistry 更新镜像源，密码为空时保留原密码
 func (s *RegistryService) UpdateRegistry(registry *model.Registry) error {
-	return s.db.Save(registry).Error
+	if registry.Password == "" {
+		var existing model.Registry
+		if err := s.db.First(&existing, registry.ID).Error; err == nil {
+			registry.Password = existing.Password
+		}
+	}
+	if err := s.db.Save(registry).Error; err != nil {
+		return err
+	}
+	return s.reload()
 }
 
 // DeleteRegistry 删除镜像源
 func (s *RegistryService) DeleteRegistry(id uint) error {
-	return s.db.Delete(&model.Registry{}, id).Error
+	if err := s.db.Delete(&model.Registry{}, id).Error; err != nil {
+		return err
+	}
+	return s.reload()
 }
 
-type WhitelistService struct {
-	db *gorm.DB
+// ErrInvalidCredentials 用户名或密码错误
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrLastSuperAdmin 不能删除或降级最后一个超级管理员
+var ErrLastSuperAdmin = errors.New("cannot remove the last superadmin")
+
+type UserService struct {
+	db             *gorm.DB
+	passwordParams PasswordParams
+	credentials    *credentialCache // 为nil时不缓存
+	deleteHooks    []func(id uint)
 }
 
-func NewWhitelistService(db *gorm.DB) *WhitelistService {
-	return &WhitelistService{db: db}
+func NewUserService(db *gorm.DB) *UserService {
+	return &UserService{
+		db:             db,
+		passwordParams: DefaultPasswordParams,
+	}
 }
 
-// IsImageWhitelisted 检查镜像是否在白名单中
-func (s *WhitelistService) IsImageWhitelisted(imageName string) (bool, error) {
-	var whitelists []model.Whitelist
-	err := s.db.Where("enabled = ?", true).Find(&whitelists).Error
+// BootstrapAdmin 数据库中还没有任何管理员时，用配置文件中的账号创建第一个超级管理员。
+// 之后管理员只保存在数据库中，配置文件中的密码不再使用。同名的普通用户会被提升为超级管理员，
+// 旧版本中配置文件管理员本来就占用这个用户名
+func (s *UserService) BootstrapAdmin(username, password string) error {
+	var count int64
+	if err := s.db.Model(&model.User{}).Where("role <> ''").Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	if username == "" || password == "" {
+		return fmt.Errorf("no admin account in database and [admin] is not configured")
+	}
+
+	hashed, err := s.HashPassword(password)
 	if err != nil {
-		return false, err
+		return err
 	}
 
-	for _, wl := range whitelists {
-		if strings.HasPrefix(imageName, wl.Prefix) {
-			return true, nil
-		}
+	var user model.User
+	err = s.db.Where("username = ?", username).First(&user).Error
+	switch {
+	case err == nil:
+		log.Printf("User: promoting existing user %s to superadmin from [admin] in config", username)
+		err = s.db.Model(&user).Updates(map[string]interface{}{
+			"password": hashed,
+			"role":     RoleSuperAdmin,
+			"is_admin": true,
+		}).Error
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		log.Printf("User: created superadmin %s from [admin] in config", username)
+		err = s.db.Create(&model.User{Username: username, Password: hashed, Role: RoleSuperAdmin, IsAdmin: true}).Error
 	}
-	return false, nil
+	return err
 }
 
-// GetAllWhitelists 获取所有白名单
-func (s *WhitelistService) GetAllWhitelists() ([]model.Whitelist, error) {
-	var whitelists []model.Whitelist
-	err := s.db.Find(&whitelists).Error
-	return whitelists, err
+// SetPasswordParams 设置密码哈希的计算开销，未设置的项使用默认值。
+// 已有用户在下次登录成功时按新参数重新计算哈希
+func (s *UserService) SetPasswordParams(params PasswordParams) {
+	if params.Memory == 0 {
+		params.Memory = DefaultPasswordParams.Memory
+	}
+	if params.Iterations == 0 {
+		params.Iterations = DefaultPasswordParams.Iterations
+	}
+	if params.Parallelism == 0 {
+		params.Parallelism = DefaultPasswordParams.Parallelism
+	}
+	s.passwordParams = params
 }
 
-// CreateWhitelist 创建白名单
-func (s *WhitelistService) CreateWhitelist(whitelist *model.Whitelist) error {
-	return s.db.Create(whitelist).Error
+// SetCredentialCache 设置验证成功的凭据缓存时间和最大条目数，ttl或size不大于0时不缓存
+func (s *UserService) SetCredentialCache(ttl time.Duration, size int) error {
+	if ttl <= 0 || size <= 0 {
+		s.credentials = nil
+		return nil
+	}
+	credentials, err := newCredentialCache(ttl, size)
+	if err != nil {
+		return err
+	}
+	s.credentials = credentials
+	return nil
 }
 
-// DeleteWhitelist 删除白名单
-func (s *WhitelistService) DeleteWhitelist(id uint) error {
-	return s.db.Delete(&model.Whitelist{}, id).Error
+// HashPassword 使用argon2id和随机盐哈希密码
+func (s *UserService) HashPassword(password string) (string, error) {
+	return hashPassword(password, s.passwordParams)
 }
 
-type UserService struct {
-	db        *gorm.DB
-	adminUser string
-	adminPass string
-}
+// AuthenticateUser 验证用户，最近验证成功的凭据直接从缓存返回
+func (s *UserService) AuthenticateUser(username, password string) (*model.User, error) {
+	cached, generation, ok := s.credentials.get(username, password)
+	if ok {
+		return cached, nil
+	}
 
-func NewUserService(db *gorm.DB, adminUser, adminPass string) *UserService {
-	return &UserService{
-		db:        db,
-		adminUser: adminUser,
-		adminPass: adminPass,
+	// 按用户名取出哈希后再比较密码，管理员和普通用户都保存在数据库中
+	var user model.User
+	if err := s.db.Where("username = ?", username).First(&user).Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+		// 用户不存在时同样计算一次哈希，避免通过响应时间判断用户名是否存在
+		hashPassword(password, s.passwordParams)
+		return nil, ErrInvalidCredentials
 	}
-}
 
-// HashPassword 哈希密码
-func (s *UserService) HashPassword(password string) string {
-	hash := md5.Sum([]byte(password))
-	return fmt.Sprintf("%x", hash)
-}
+	ok, needsRehash := verifyPassword(password, user.Password, s.passwordParams)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
 
-// AuthenticateUser 验证用户
-func (s *UserService) AuthenticateUser(username, password string) (*model.User, error) {
-	// 首先检查是否是管理员用户（从配置文件）
-	if username == s.adminUser && password == s.adminPass {
-		return &model.User{
-			Username: username,
-			IsAdmin:  true,
-		}, nil
+	// 旧的MD5哈希或参数已变化的哈希在登录成功时透明升级
+	if needsRehash {
+		if hashed, err := s.HashPassword(password); err == nil {
+			if err := s.db.Model(&model.User{}).Where("id = ?", user.ID).UpdateColumn("password", hashed).Error; err != nil {
+				log.Printf("User: failed to upgrade password hash of %s: %v", user.Username, err)
+			} else {
+				user.Password = hashed
+			}
+		}
 	}
+	s.credentials.put(username, password, &user, generation)
+	return &user, nil
+}
 
-	// 从数据库查找普通用户（只查找非管理员用户）
+// GetUser 按用户名获取用户，用于token认证后恢复用户信息和权限
+func (s *UserService) GetUser(username string) (*model.User, error) {
 	var user model.User
-	hashedPassword := s.HashPassword(password)
-	err := s.db.Where("username = ? AND password = ?", username, hashedPassword).First(&user).Error
-	if err != nil {
+	if err := s.db.Where("username = ?", username).First(&user).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-// GetAllUsers 获取所有普通用户（不包括管理员）
+// GetAllUsers 获取所有用户，包括管理员
 func (s *UserService) GetAllUsers() ([]model.User, error) {
 	var users []model.User
-	// 普通用户在数据库中，管理员在配置文件中，所以只查询数据库
 	err := s.db.Find(&users).Error
 	return users, err
 }
 
-// CreateUser 创建普通用户
+// CreateUser 创建用户，角色为空时创建普通用户
 func (s *UserService) CreateUser(user *model.User) error {
-	user.Password = s.HashPassword(user.Password)
-	user.IsAdmin = false // 确保创建的是普通用户
+	if err := validateRole(user.Role); err != nil {
+		return err
+	}
+	hashed, err := s.HashPassword(user.Password)
+	if err != nil {
+		return err
+	}
+	user.Password = hashed
+	user.IsAdmin = isRegistryAdmin(user.Role) // 镜像仓库管理员权限只由角色决定
 	return s.db.Create(user).Error
 }
 
-// DeleteUser 删除普通用户
+// UpdateRole 修改用户的管理员角色，空字符串表示降为普通用户
+func (s *UserService) UpdateRole(id uint, role string) error {
+	if err := validateRole(role); err != nil {
+		return err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var user model.User
+		if err := tx.First(&user, id).Error; err != nil {
+			return err
+		}
+		if user.Role == RoleSuperAdmin && role != RoleSuperAdmin {
+			if err := ensureOtherSuperAdmin(tx, id); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"role":     role,
+			"is_admin": isRegistryAdmin(role),
+		}).Error; err != nil {
+			return err
+		}
+		// 降为普通用户后退出管理界面的所有会话
+		if role == "" {
+			return tx.Where("user_id = ?", id).Delete(&model.AdminSession{}).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.credentials.invalidateUser(id)
+	return nil
+}
+
+// ensureOtherSuperAdmin 确认除指定用户外还有其他超级管理员，避免所有人都无法管理系统
+func ensureOtherSuperAdmin(tx *gorm.DB, id uint) error {
+	var count int64
+	if err := tx.Model(&model.User{}).Where("role = ? AND id <> ?", RoleSuperAdmin, id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrLastSuperAdmin
+	}
+	return nil
+}
+
+// UpdatePassword 修改用户的密码
+func (s *UserService) UpdatePassword(id uint, password string) error {
+	hashed, err := s.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	result := s.db.Model(&model.User{}).Where("id = ?", id).UpdateColumn("password", hashed)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	// 旧密码立即失效，已登录的管理界面会话也需要重新登录
+	s.credentials.invalidateUser(id)
+	s.db.Where("user_id = ?", id).Delete(&model.AdminSession{})
+	return nil
+}
+
+// UpdatePermissions 更新用户的推送和删除权限
+func (s *UserService) UpdatePermissions(id uint, canDelete, canPush bool) error {
+	err := s.db.Model(&model.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"can_delete": canDelete,
+		"can_push":   canPush,
+	}).Error
+	if err != nil {
+		return err
+	}
+	// 缓存中保存的是验证时的用户信息，权限变化后需要重新读取
+	s.credentials.invalidateUser(id)
+	return nil
+}
+
+// OnUserDeleted 注册删除用户后的回调
+func (s *UserService) OnUserDeleted(hook func(id uint)) {
+	s.deleteHooks = append(s.deleteHooks, hook)
+}
+
+// DeleteUser 删除用户
 func (s *UserService) DeleteUser(id uint) error {
-	// 只删除普通用户，不能删除管理员（管理员在配置文件中）
-	return s.db.Delete(&model.User{}, id).Error
+	// 用户的访问令牌、登录会话和用户组成员关系一并删除，不能删除最后一个超级管理员
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var user model.User
+		if err := tx.First(&user, id).Error; err != nil {
+			return err
+		}
+		if user.Role == RoleSuperAdmin {
+			if err := ensureOtherSuperAdmin(tx, id); err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&model.AccessToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&model.AdminSession{}).Error; err != nil {
+			return err
+		}
+		// 从所属的用户组中移除
+		var groups []model.UserGroup
+		if err := tx.Find(&groups).Error; err != nil {
+			return err
+		}
+		for _, group := range groups {
+			members := make([]uint, 0, len(group.UserIDs))
+			for _, member := range group.UserIDs {
+				if member != id {
+					members = append(members, member)
+				}
+			}
+			if len(members) != len(group.UserIDs) {
+				if err := tx.Model(&group).Select("user_ids").Updates(model.UserGroup{UserIDs: members}).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Delete(&model.User{}, id).Error
+	})
+	if err != nil {
+		return err
+	}
+	s.credentials.invalidateUser(id)
+	for _, hook := range s.deleteHooks {
+		hook(id)
+	}
+	return nil
 }
 
 type ProxyService struct {
 	registryService *RegistryService
 	client          *http.Client
+	circuits        *circuitBreaker
+	offline         atomic.Bool // 手动离线模式
+	autoOffline     atomic.Bool // 所有镜像源熔断时自动进入离线模式
+
+	remotesMu sync.Mutex
+	remotes   map[string]*RemoteRegistry // 推送透传使用的客户端，按镜像源地址和凭证复用以缓存token
 }
 
 func NewProxyService(registryService *RegistryService) *ProxyService {
@@ -164,60 +421,125 @@ func NewProxyService(registryService *RegistryService) *ProxyService {
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		circuits: newCircuitBreaker(),
+		remotes:  make(map[string]*RemoteRegistry),
+	}
+}
+
+// SetOfflineMode 设置离线模式
+func (s *ProxyService) SetOfflineMode(offline, auto bool) {
+	s.offline.Store(offline)
+	s.autoOffline.Store(auto)
+}
+
+// OfflineStatus 离线模式状态
+type OfflineStatus struct {
+	Offline     bool            `json:"offline"`      // 手动离线
+	AutoOffline bool            `json:"auto_offline"` // 自动离线开关
+	Effective   bool            `json:"effective"`    // 当前是否处于离线状态
+	Circuits    []CircuitStatus `json:"circuits"`
+}
+
+// IsOffline 判断当前是否处于离线模式
+func (s *ProxyService) IsOffline() bool {
+	if s.offline.Load() {
+		return true
+	}
+	if !s.autoOffline.Load() {
+		return false
+	}
+
+	registries, err := s.registryService.GetEnabledRegistries()
+	if err != nil || len(registries) == 0 {
+		return false
+	}
+	for _, registry := range registries {
+		if !s.circuits.isOpen(registry.URL) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetOfflineStatus 获取离线模式及各镜像源熔断状态
+func (s *ProxyService) GetOfflineStatus() (*OfflineStatus, error) {
+	registries, err := s.registryService.GetEnabledRegistries()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &OfflineStatus{
+		Offline:     s.offline.Load(),
+		AutoOffline: s.autoOffline.Load(),
+		Effective:   s.IsOffline(),
+		Circuits:    []CircuitStatus{},
 	}
+	for _, registry := range registries {
+		status.Circuits = append(status.Circuits, s.circuits.status(registry.URL))
+	}
+	return status, nil
 }
 
 // ProxyRequest 代理请求到上游镜像源
 func (s *ProxyService) ProxyRequest(method, path string, headers http.Header) (*http.Response, string, error) {
-	fmt.Printf("PROXY DEBUG: Starting proxy request %s %s\n", method, path)
+	if s.IsOffline() {
+		return nil, "", ErrOffline
+	}
+
 	registries, err := s.registryService.GetEnabledRegistries()
 	if err != nil {
 		return nil, "", err
 	}
+	return s.ProxyRequestTo(registries, method, path, headers)
+}
+
+// ProxyRequestTo 按给定顺序依次尝试镜像源，返回第一个成功的响应
+func (s *ProxyService) ProxyRequestTo(registries []model.Registry, method, path string, headers http.Header) (*http.Response, string, error) {
+	if s.IsOffline() {
+		return nil, "", ErrOffline
+	}
 
 	for _, registry := range registries {
-		fmt.Printf("PROXY DEBUG: Trying registry %s\n", registry.URL)
+		if !s.circuits.allow(registry.URL) {
+			continue
+		}
 		targetURL, err := url.JoinPath(registry.URL, path)
 		if err != nil {
 			continue
 		}
 
 		// 首次请求
-		fmt.Printf("PROXY DEBUG: Making first request to %s\n", targetURL)
 		resp, err := s.makeRequest(method, targetURL, headers, "")
 		if err != nil {
-			fmt.Printf("PROXY DEBUG: First request failed: %v\n", err)
+			s.circuits.failure(registry.URL)
 			continue
 		}
 
-		fmt.Printf("PROXY DEBUG: First request status: %d\n", resp.StatusCode)
+		// 5xx视为镜像源故障，其他响应说明镜像源可达
+		if resp.StatusCode >= 500 {
+			s.circuits.failure(registry.URL)
+		} else {
+			s.circuits.success(registry.URL)
+		}
 
 		// 如果是401且响应头包含WWW-Authenticate，尝试获取token
 		if resp.StatusCode == 401 {
 			authHeader := resp.Header.Get("WWW-Authenticate")
-			fmt.Printf("PROXY DEBUG: Got 401, WWW-Authenticate: %s\n", authHeader)
 			if authHeader != "" && strings.Contains(strings.ToLower(authHeader), "bearer") {
 				resp.Body.Close()
 
 				// 尝试获取匿名token
-				fmt.Printf("PROXY DEBUG: Trying to get anonymous token\n")
 				token, err := s.getAnonymousToken(authHeader, path)
 				if err == nil && token != "" {
-					fmt.Printf("PROXY DEBUG: Got token, making second request\n")
 					// 用token重新请求
 					newResp, err := s.makeRequest(method, targetURL, headers, token)
 					if err == nil {
-						fmt.Printf("PROXY DEBUG: Second request successful: %d\n", newResp.StatusCode)
 						// 只有成功才返回，否则继续尝试下一个镜像源
 						if newResp.StatusCode >= 200 && newResp.StatusCode < 300 {
 							return newResp, registry.URL, nil
 						}
 						newResp.Body.Close()
-					} else {
-						fmt.Printf("PROXY DEBUG: Second request failed: %v\n", err)
 					}
-				} else {
-					fmt.Printf("PROXY DEBUG: Failed to get token: %v\n", err)
 				}
 			} else {
 				resp.Body.Close()
@@ -232,12 +554,71 @@ func (s *ProxyService) ProxyRequest(method, path string, headers http.Header) (*
 
 		// 其他状态码（包括404），关闭响应体并尝试下一个镜像源
 		resp.Body.Close()
-		fmt.Printf("PROXY DEBUG: Registry %s returned %d, trying next registry\n", registry.URL, resp.StatusCode)
 	}
 
 	return nil, "", fmt.Errorf("all registries failed")
 }
 
+// ManifestDigest 通过HEAD请求获取tag在上游的digest，上游未返回digest时读取manifest计算
+func (s *ProxyService) ManifestDigest(repository, reference string) (string, string, error) {
+	headers := http.Header{}
+	headers.Set("Accept", manifestAccept)
+	manifestPath := "/v2/" + repository + "/manifests/" + reference
+
+	resp, upstream, err := s.ProxyRequest("HEAD", manifestPath, headers)
+	if err != nil {
+		return "", "", err
+	}
+	resp.Body.Close()
+	if digest := resp.Header.Get("Docker-Content-Digest"); IsDigest(digest) {
+		return digest, upstream, nil
+	}
+
+	resp, upstream, err = s.ProxyRequest("GET", manifestPath, headers)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(io.LimitReader(resp.Body, MaxManifestSize))
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:]), upstream, nil
+}
+
+// Forward 将请求连同请求体透传到指定镜像源，使用镜像源配置的凭证，响应原样返回
+func (s *ProxyService) Forward(registry *model.Registry, repository, method, path string, headers http.Header, body io.Reader, size int64) (*http.Response, error) {
+	header := http.Header{}
+	for name, values := range headers {
+		if shouldSkipHeader(name) || strings.EqualFold(name, "Content-Length") {
+			continue
+		}
+		for _, value := range values {
+			header.Add(name, value)
+		}
+	}
+	return s.remote(registry).Forward(method, path, header, body, size, repository)
+}
+
+// RelativeLocation 将镜像源返回的Location转换为相对路径
+func (s *ProxyService) RelativeLocation(registry *model.Registry, location string) string {
+	return s.remote(registry).RelativeLocation(location)
+}
+
+// remote 获取镜像源对应的客户端，凭证变更后使用新的客户端
+func (s *ProxyService) remote(registry *model.Registry) *RemoteRegistry {
+	key := registry.URL + "\x00" + registry.Username + "\x00" + registry.Password
+	s.remotesMu.Lock()
+	defer s.remotesMu.Unlock()
+	if r, ok := s.remotes[key]; ok {
+		return r
+	}
+	r := NewRemoteRegistry(registry.URL, registry.Username, registry.Password)
+	s.remotes[key] = r
+	return r
+}
+
 // makeRequest 发送HTTP请求
 func (s *ProxyService) makeRequest(method, targetURL string, headers http.Header, token string) (*http.Response, error) {
 	req, err := http.NewRequest(method, targetURL, nil)
@@ -428,3 +809,15 @@ func (s *LogService) GetAccessLogs(limit int) ([]model.AccessLog, error) {
 func (s *LogService) ClearAccessLogs() error {
 	return s.db.Where("1 = 1").Delete(&model.AccessLog{}).Error
 }
+
+// LogAudit 记录审计日志，同步写入
+func (s *LogService) LogAudit(entry *model.AuditLog) error {
+	return s.db.Create(entry).Error
+}
+
+// GetAuditLogs 获取审计日志
+func (s *LogService) GetAuditLogs(limit int) ([]model.AuditLog, error) {
+	var logs []model.AuditLog
+	err := s.db.Order("created_at DESC").Limit(limit).Find(&logs).Error
+	return logs, err
+}
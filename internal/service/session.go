@@ -0,0 +1,253 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"zmirror/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	// SessionCookie 保存会话token的cookie，设置为HttpOnly，前端脚本无法读取
+	SessionCookie = "zmirror_session"
+
+	// CSRFCookie 保存CSRF token的cookie，前端读取后放在 CSRFHeader 请求头中提交（axios默认的名称）
+	CSRFCookie = "XSRF-TOKEN"
+	CSRFHeader = "X-XSRF-TOKEN"
+
+	// sessionKeyFile 会话token的HMAC签名密钥，保存在签名密钥目录中，重启后已登录的会话仍然有效
+	sessionKeyFile = "session.key"
+
+	// sessionTouchInterval 会话最近活动时间的更新间隔，避免每个请求都写数据库
+	sessionTouchInterval = time.Minute
+)
+
+var (
+	// ErrAdminRequired 登录的用户没有管理员角色
+	ErrAdminRequired = errors.New("admin access required")
+
+	// ErrInvalidSession 会话token无效，或会话已退出、被吊销
+	ErrInvalidSession = errors.New("invalid session")
+
+	// ErrSessionExpired 会话超过空闲时间或绝对有效期，需要重新登录
+	ErrSessionExpired = errors.New("session expired")
+
+	// ErrSessionTokenExpired 会话token已过期但会话仍然有效，可以刷新
+	ErrSessionTokenExpired = errors.New("session token expired")
+)
+
+// IssuedSession 登录或刷新时签发的会话token
+type IssuedSession struct {
+	Token     string
+	ExpiresIn int
+	CSRFToken string
+	Session   *model.AdminSession
+}
+
+// SessionService 管理界面的登录会话。会话token是短期有效的HS256 JWT，只包含会话ID，
+// 会话本身保存在数据库中，退出登录、修改密码或删除用户后立即失效
+type SessionService struct {
+	db          *gorm.DB
+	userService *UserService
+	key         []byte
+
+	tokenTTL        time.Duration // 会话token的有效期，过期后用 Refresh 换取新token
+	idleTimeout     time.Duration // 超过这个时间没有请求，会话失效
+	absoluteTimeout time.Duration // 从登录开始计算的最长有效期，刷新不会延长
+}
+
+func NewSessionService(db *gorm.DB, userService *UserService, keyPath string, tokenTTL, idleTimeout, absoluteTimeout time.Duration) (*SessionService, error) {
+	key, err := loadSessionKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionService{
+		db:              db,
+		userService:     userService,
+		key:             key,
+		tokenTTL:        tokenTTL,
+		idleTimeout:     idleTimeout,
+		absoluteTimeout: absoluteTimeout,
+	}, nil
+}
+
+// loadSessionKey 读取会话签名密钥，不存在时随机生成
+func loadSessionKey(dir string) ([]byte, error) {
+	path := filepath.Join(dir, sessionKeyFile)
+	key, err := os.ReadFile(path)
+	if err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Login 校验用户名和密码，为管理员创建会话并签发会话token
+func (s *SessionService) Login(username, password, clientIP, userAgent string) (*model.User, *IssuedSession, error) {
+	user, err := s.userService.AuthenticateUser(username, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.Role == "" {
+		return nil, nil, ErrAdminRequired
+	}
+
+	now := time.Now()
+	// 顺便清理已经超时的会话
+	s.db.Where("expires_at < ? OR last_seen_at < ?", now, now.Add(-s.idleTimeout)).Delete(&model.AdminSession{})
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, nil, err
+	}
+	session := &model.AdminSession{
+		ID:         hex.EncodeToString(raw),
+		UserID:     user.ID,
+		Username:   user.Username,
+		ClientIP:   clientIP,
+		UserAgent:  userAgent,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(s.absoluteTimeout),
+	}
+	if err := s.db.Create(session).Error; err != nil {
+		return nil, nil, err
+	}
+
+	issued, err := s.issue(session)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, issued, nil
+}
+
+// Authenticate 校验会话token，返回会话所属的用户。用户的角色每次都从数据库读取，修改后立即生效
+func (s *SessionService) Authenticate(token string) (*model.User, *model.AdminSession, error) {
+	return s.authenticate(token, true)
+}
+
+// Refresh 用会话token换取新的会话token。原token可以已经过期，只要会话没有超时或被吊销
+func (s *SessionService) Refresh(token string) (*model.User, *IssuedSession, error) {
+	user, session, err := s.authenticate(token, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	issued, err := s.issue(session)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, issued, nil
+}
+
+// authenticate 校验token签名和会话状态，并更新会话的最近活动时间
+func (s *SessionService) authenticate(token string, verifyExpiry bool) (*model.User, *model.AdminSession, error) {
+	options := []jwt.ParserOption{jwt.WithValidMethods([]string{"HS256"}), jwt.WithLeeway(tokenLeeway)}
+	if !verifyExpiry {
+		options = append(options, jwt.WithoutClaimsValidation())
+	}
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return s.key, nil
+	}, options...)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, nil, ErrSessionTokenExpired
+	}
+	if err != nil || claims.ID == "" {
+		return nil, nil, ErrInvalidSession
+	}
+
+	var session model.AdminSession
+	if err := s.db.Where("id = ?", claims.ID).First(&session).Error; err != nil {
+		return nil, nil, ErrInvalidSession
+	}
+	now := time.Now()
+	if now.After(session.ExpiresAt) || now.Sub(session.LastSeenAt) > s.idleTimeout {
+		s.db.Delete(&session)
+		return nil, nil, ErrSessionExpired
+	}
+
+	var user model.User
+	if err := s.db.First(&user, session.UserID).Error; err != nil {
+		return nil, nil, ErrInvalidSession
+	}
+
+	if now.Sub(session.LastSeenAt) >= sessionTouchInterval {
+		s.db.Model(&session).UpdateColumn("last_seen_at", now)
+	}
+	return &user, &session, nil
+}
+
+// issue 为会话签发新的会话token，有效期不超过会话的绝对过期时间
+func (s *SessionService) issue(session *model.AdminSession) (*IssuedSession, error) {
+	now := time.Now()
+	expiresAt := now.Add(s.tokenTTL)
+	if expiresAt.After(session.ExpiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+
+	claims := jwt.RegisteredClaims{
+		ID:        session.ID,
+		Subject:   session.Username,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return &IssuedSession{
+		Token:     signed,
+		ExpiresIn: int(expiresAt.Sub(now).Seconds()),
+		CSRFToken: s.CSRFToken(session),
+		Session:   session,
+	}, nil
+}
+
+// CSRFToken 计算会话的CSRF token，由会话ID派生，不需要单独保存
+func (s *SessionService) CSRFToken(session *model.AdminSession) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte("csrf"))
+	mac.Write([]byte{0})
+	mac.Write([]byte(session.ID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CheckCSRF 校验请求提交的CSRF token是否属于该会话
+func (s *SessionService) CheckCSRF(session *model.AdminSession, token string) bool {
+	return token != "" && hmac.Equal([]byte(token), []byte(s.CSRFToken(session)))
+}
+
+// GetActiveSessions 获取所有未超时的会话
+func (s *SessionService) GetActiveSessions() ([]model.AdminSession, error) {
+	var sessions []model.AdminSession
+	now := time.Now()
+	err := s.db.Where("expires_at >= ? AND last_seen_at >= ?", now, now.Add(-s.idleTimeout)).Order("last_seen_at DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+// Revoke 吊销会话，已签发的会话token立即失效
+func (s *SessionService) Revoke(id string) error {
+	return s.db.Where("id = ?", id).Delete(&model.AdminSession{}).Error
+}
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"zmirror/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIdleTimeout     = 30 * time.Minute
+	testAbsoluteTimeout = 12 * time.Hour
+)
+
+// newTestSessionService 创建会话服务和两个账号：管理员admin和普通用户alice，密码都是secret
+func newTestSessionService(t *testing.T) (*SessionService, *UserService) {
+	t.Helper()
+	db := newTestDB(t)
+	userService := newTestUserService(t, db)
+	for _, user := range []*model.User{
+		{Username: "admin", Password: "secret", Role: RoleSuperAdmin},
+		{Username: "alice", Password: "secret"},
+	} {
+		if err := userService.CreateUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sessionService, err := NewSessionService(db, userService, t.TempDir(), 15*time.Minute, testIdleTimeout, testAbsoluteTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sessionService, userService
+}
+
+// signSessionToken 为会话签发指定过期时间的会话token
+func signSessionToken(t *testing.T, s *SessionService, sessionID string, expiresAt time.Time) string {
+	t.Helper()
+	claims := jwt.RegisteredClaims{
+		ID:        sessionID,
+		IssuedAt:  jwt.NewNumericDate(expiresAt.Add(-15 * time.Minute)),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestSessionLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  error
+	}{
+		{"administrator", "admin", "secret", nil},
+		{"wrong password", "admin", "wrong", ErrInvalidCredentials},
+		{"unknown user", "nobody", "secret", ErrInvalidCredentials},
+		{"normal user", "alice", "secret", ErrAdminRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionService, _ := newTestSessionService(t)
+			user, issued, err := sessionService.Login(tt.username, tt.password, "127.0.0.1", "test")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Login() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if user.Username != tt.username {
+				t.Errorf("Login() user = %q, want %q", user.Username, tt.username)
+			}
+			authenticated, session, err := sessionService.Authenticate(issued.Token)
+			if err != nil {
+				t.Fatalf("Authenticate() with new session token: %v", err)
+			}
+			if authenticated.ID != user.ID || session.ID != issued.Session.ID {
+				t.Errorf("Authenticate() returned user %d session %s, want %d %s", authenticated.ID, session.ID, user.ID, issued.Session.ID)
+			}
+		})
+	}
+}
+
+func TestSessionAuthenticateAndRefresh(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare 返回要使用的会话token，可以修改会话使其超时或被吊销
+		prepare    func(t *testing.T, s *SessionService, users *UserService, issued *IssuedSession) string
+		authErr    error
+		refreshErr error
+	}{
+		{
+			name: "valid token",
+			prepare: func(t *testing.T, s *SessionService, users *UserService, issued *IssuedSession) string {
+				return issued.Token
+			},
+		},
+		{
+			name: "expired token within session lifetime",
+			prepare: func(t *testing.T, s *SessionService, users *UserService, issued *IssuedSession) string {
+				return signSessionToken(t, s, issued.Session.ID, time.Now().Add(-time.Minute))
+			},
+			authErr: ErrSessionTokenExpired,
+		},
+		{
+			name: "expired token after idle timeout",
+			prepare: func(t *testing.T, s *SessionService, users *UserService, issued *IssuedSession) string {
+				s.db.Model(&model.AdminSession{}).Where("id = ?", issued.Session.ID).UpdateColumn("last_seen_at", time.Now().Add(-testIdleTimeout-time.Minute))
+				return signSessionToken(t, s, issued.Session.ID, time.Now().Add(-time.Minute))
+			},
+			authErr:    ErrSessionTokenExpired,
+			refreshErr: ErrSessionExpired,
+		},
+		{
+			name: "expired token after absolute timeout",
+			prepare: func(t *testing.T, s *SessionService, users *UserService, issued *IssuedSession) string {
+				s.db.Model(&model.AdminSession{}).Where("id = ?", issued.Session.ID).UpdateColumn("expires_at", time.Now().Add(-time.Minute))
+				return signSessionToken(t, s, issued.Session.ID, time.Now().Add(-time.Minute))
+			},
+			authErr:    ErrSessionTokenExpired,
+			refreshErr: ErrSessionExpired,
+		},
+		{
+			name: "valid token after idle timeout",
+			prepare: func(t *testing.T, s *SessionService, users *UserService, issued *IssuedSession) string {
+				s.db.Model(&model.AdminSession{}).Where("id = ?", issued.Session.ID).UpdateColumn("last_seen_at", time.Now().Add(-testIdleTimeout-time.Minute))
+				return issued.Token
+			},
+			authErr: ErrSessionExpired,
+			// Authenticate已经删除了超时的会话
+			refreshErr: ErrInvalidSession,
+		},
+		{
+			name: "revoked session",
+			prepare: func(t *testing.T, s *SessionService, users *UserService, issued *IssuedSession) string {
+				if err := s.Revoke(issued.Session.ID); err != nil {
+					t.Fatal(err)
+				}
+				return issued.Token
+			},
+			authErr:    ErrInvalidSession,
+			refreshErr: ErrInvalidSession,
+		},
+		{
+			name: "password changed",
+			prepare: func(t *testing.T, s *SessionService, users *UserService, issued *IssuedSession) string {
+				if err := users.UpdatePassword(issued.Session.UserID, "changed"); err != nil {
+					t.Fatal(err)
+				}
+				return issued.Token
+			},
+			authErr:    ErrInvalidSession,
+			refreshErr: ErrInvalidSession,
+		},
+		{
+			name: "tampered token",
+			prepare: func(t *testing.T, s *SessionService, users *UserService, issued *IssuedSession) string {
+				return issued.Token + "x"
+			},
+			authErr:    ErrInvalidSession,
+			refreshErr: ErrInvalidSession,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionService, userService := newTestSessionService(t)
+			_, issued, err := sessionService.Login("admin", "secret", "127.0.0.1", "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			token := tt.prepare(t, sessionService, userService, issued)
+
+			if _, _, err := sessionService.Authenticate(token); !errors.Is(err, tt.authErr) {
+				t.Errorf("Authenticate() error = %v, want %v", err, tt.authErr)
+			}
+
+			_, refreshed, err := sessionService.Refresh(token)
+			if !errors.Is(err, tt.refreshErr) {
+				t.Fatalf("Refresh() error = %v, want %v", err, tt.refreshErr)
+			}
+			if err != nil {
+				// 超时的会话在检查时被删除，之后原token也无法刷新
+				if errors.Is(err, ErrSessionExpired) {
+					if _, _, err := sessionService.Refresh(token); !errors.Is(err, ErrInvalidSession) {
+						t.Errorf("second Refresh() error = %v, want %v", err, ErrInvalidSession)
+					}
+				}
+				return
+			}
+			if refreshed.Session.ID != issued.Session.ID {
+				t.Errorf("Refresh() created session %s, want %s", refreshed.Session.ID, issued.Session.ID)
+			}
+			if _, _, err := sessionService.Authenticate(refreshed.Token); err != nil {
+				t.Errorf("Authenticate() with refreshed token: %v", err)
+			}
+		})
+	}
+}
+
+func TestSessionTokenNeverOutlivesSession(t *testing.T) {
+	sessionService, _ := newTestSessionService(t)
+	_, issued, err := sessionService.Login("admin", "secret", "127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 会话只剩一分钟时，刷新得到的token也只剩一分钟
+	expiresAt := time.Now().Add(time.Minute)
+	sessionService.db.Model(&model.AdminSession{}).Where("id = ?", issued.Session.ID).UpdateColumn("expires_at", expiresAt)
+	_, refreshed, err := sessionService.Refresh(issued.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.ExpiresIn > 60 {
+		t.Errorf("refreshed token expires in %ds, after the session ends", refreshed.ExpiresIn)
+	}
+}
+
+func TestSessionCSRF(t *testing.T) {
+	sessionService, _ := newTestSessionService(t)
+	_, first, err := sessionService.Login("admin", "secret", "127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, err := sessionService.Login("admin", "secret", "127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		session *model.AdminSession
+		token   string
+		want    bool
+	}{
+		{"own token", first.Session, first.CSRFToken, true},
+		{"token of another session", first.Session, second.CSRFToken, false},
+		{"empty token", first.Session, "", false},
+		{"session token instead of csrf token", first.Session, first.Token, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sessionService.CheckCSRF(tt.session, tt.token); got != tt.want {
+				t.Errorf("CheckCSRF() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
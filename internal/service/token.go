@@ -0,0 +1,572 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"zmirror/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// tokenKeyPrefix 签名密钥文件名前缀，文件名为 token-<创建时间戳>.pem
+	tokenKeyPrefix = "token-"
+
+	// tokenLeeway 校验token时间时允许的时钟误差
+	tokenLeeway = 30 * time.Second
+
+	// tokenRotationCheck 检查密钥是否需要轮换的间隔
+	tokenRotationCheck = time.Hour
+)
+
+// TokenAccess token授予的一项资源访问权限
+type TokenAccess struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+// TokenClaims Docker Registry token的声明
+type TokenClaims struct {
+	jwt.RegisteredClaims
+	Access []TokenAccess `json:"access"`
+}
+
+// Allows 判断token是否授予了对资源的指定操作
+func (c *TokenClaims) Allows(resourceType, name, action string) bool {
+	for _, access := range c.Access {
+		if access.Type != resourceType || access.Name != name {
+			continue
+		}
+		for _, granted := range access.Actions {
+			if granted == action || granted == "*" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IssuedToken 签发的token
+type IssuedToken struct {
+	Token     string
+	ExpiresIn int
+	IssuedAt  time.Time
+}
+
+// JSONWebKey JWKS中的一个公钥
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet 公开的签名公钥集合
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// tokenKey 一个签名密钥
+type tokenKey struct {
+	id        string
+	path      string
+	createdAt time.Time
+	signer    crypto.Signer
+	method    jwt.SigningMethod
+}
+
+// TokenService Docker Registry token认证服务：签发和校验带访问范围的JWT，签名密钥保存在本地目录并定期轮换
+type TokenService struct {
+	whitelistService *WhitelistService
+	aclService       *ACLService
+	groupService     *GroupService
+	enabled          bool
+	dir              string
+	realm            string
+	issuer           string
+	service          string
+	method           jwt.SigningMethod
+	expiry           time.Duration
+	rotation         time.Duration
+
+	mu   sync.RWMutex
+	keys []*tokenKey // 按创建时间从新到旧，第一个用于签名
+}
+
+func NewTokenService(whitelistService *WhitelistService, aclService *ACLService, groupService *GroupService, enabled bool, dir, realm, issuer, service, algorithm string, expiry, rotation time.Duration) (*TokenService, error) {
+	var method jwt.SigningMethod
+	switch algorithm {
+	case "RS256":
+		method = jwt.SigningMethodRS256
+	case "ES256":
+		method = jwt.SigningMethodES256
+	default:
+		return nil, fmt.Errorf("unsupported token algorithm %q, use RS256 or ES256", algorithm)
+	}
+	if expiry <= 0 {
+		return nil, fmt.Errorf("token expiry must be positive")
+	}
+
+	s := &TokenService{
+		whitelistService: whitelistService,
+		aclService:       aclService,
+		groupService:     groupService,
+		enabled:          enabled,
+		dir:              dir,
+		realm:            realm,
+		issuer:           issuer,
+		service:          service,
+		method:           method,
+		expiry:           expiry,
+		rotation:         rotation,
+	}
+	if !enabled {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := s.loadKeys(); err != nil {
+		return nil, err
+	}
+	if err := s.Rotate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Enabled Registry API是否使用token认证
+func (s *TokenService) Enabled() bool {
+	return s.enabled
+}
+
+// Realm 配置的token服务地址，为空时由调用方根据请求生成
+func (s *TokenService) Realm() string {
+	return s.realm
+}
+
+// Service token的audience，客户端请求token时的service参数
+func (s *TokenService) Service() string {
+	return s.service
+}
+
+// StartRotation 启动密钥定时轮换任务
+func (s *TokenService) StartRotation() {
+	if !s.enabled || s.rotation <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(tokenRotationCheck)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := s.Rotate(); err != nil {
+				log.Printf("Token: key rotation failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Rotate 当前签名密钥不存在、算法与配置不一致或超过轮换间隔时生成新密钥，
+// 并删除其签发的token已全部过期的旧密钥
+func (s *TokenService) Rotate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if len(s.keys) == 0 || s.keys[0].method != s.method || (s.rotation > 0 && now.Sub(s.keys[0].createdAt) >= s.rotation) {
+		key, err := s.generateKey(now)
+		if err != nil {
+			return err
+		}
+		s.keys = append([]*tokenKey{key}, s.keys...)
+		log.Printf("Token: generated %s signing key %s", s.method.Alg(), key.id)
+	}
+
+	// 旧密钥在被替换后还要保留一个token有效期，用于校验替换前签发的token
+	kept := s.keys[:1]
+	for i := 1; i < len(s.keys); i++ {
+		if now.Sub(s.keys[i-1].createdAt) <= s.expiry+tokenLeeway {
+			kept = append(kept, s.keys[i])
+			continue
+		}
+		if err := os.Remove(s.keys[i].path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Token: failed to remove retired key %s: %v", s.keys[i].path, err)
+		}
+	}
+	s.keys = kept
+	return nil
+}
+
+// loadKeys 加载密钥目录中的所有签名密钥
+func (s *TokenService) loadKeys() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	var keys []*tokenKey
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, tokenKeyPrefix) || !strings.HasSuffix(name, ".pem") {
+			continue
+		}
+		created, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, tokenKeyPrefix), ".pem"), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(s.dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		key, err := parseTokenKey(data)
+		if err != nil {
+			return fmt.Errorf("invalid signing key %s: %v", path, err)
+		}
+		key.path = path
+		key.createdAt = time.Unix(created, 0)
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].createdAt.After(keys[j].createdAt)
+	})
+	s.keys = keys
+	return nil
+}
+
+// generateKey 生成配置算法的新密钥并保存到密钥目录
+func (s *TokenService) generateKey(now time.Time) (*tokenKey, error) {
+	var signer crypto.Signer
+	var err error
+	if s.method == jwt.SigningMethodRS256 {
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	// 同一秒内多次轮换时顺延时间戳，保证文件名唯一
+	created := now.Unix()
+	for _, key := range s.keys {
+		if key.createdAt.Unix() >= created {
+			created = key.createdAt.Unix() + 1
+		}
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("%s%d.pem", tokenKeyPrefix, created))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+
+	key, err := parseTokenKey(data)
+	if err != nil {
+		return nil, err
+	}
+	key.path = path
+	key.createdAt = time.Unix(created, 0)
+	return key, nil
+}
+
+// parseTokenKey 解析PKCS#8格式的私钥，kid取公钥的JWK指纹
+func parseTokenKey(data []byte) (*tokenKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &tokenKey{}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.signer = k
+		key.method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("only P-256 ECDSA keys are supported")
+		}
+		key.signer = k
+		key.method = jwt.SigningMethodES256
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	jwk := publicJWK(key.signer.Public())
+	// RFC 7638 指纹：按字典序只包含必需成员
+	var thumbprint []byte
+	if jwk.Kty == "RSA" {
+		thumbprint, _ = json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N})
+	} else {
+		thumbprint, _ = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y})
+	}
+	sum := sha256.Sum256(thumbprint)
+	key.id = base64.RawURLEncoding.EncodeToString(sum[:])
+	return key, nil
+}
+
+// publicJWK 把公钥转换为JWK，不含kid和alg
+func publicJWK(public crypto.PublicKey) JSONWebKey {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return JSONWebKey{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return JSONWebKey{
+			Kty: "EC",
+			Crv: k.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size))),
+		}
+	}
+	return JSONWebKey{}
+}
+
+// JWKS 返回所有仍可用于校验的公钥
+func (s *TokenService) JWKS() JSONWebKeySet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range s.keys {
+		jwk := publicJWK(key.signer.Public())
+		jwk.Kid = key.id
+		jwk.Use = "sig"
+		jwk.Alg = key.method.Alg()
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// ParseScopes 解析token请求中的scope参数。参数可以出现多次，每个参数也可以包含空格分隔的多个scope，
+// 格式为 type:name:action[,action]，name中可以包含冒号（如带端口的仓库地址）
+func ParseScopes(scopes []string) []TokenAccess {
+	var result []TokenAccess
+	index := make(map[string]int)
+	for _, param := range scopes {
+		for _, scope := range strings.Fields(param) {
+			first := strings.Index(scope, ":")
+			last := strings.LastIndex(scope, ":")
+			if first <= 0 || last == first || last == len(scope)-1 {
+				continue
+			}
+			resourceType := scope[:first]
+			name := scope[first+1 : last]
+			if name == "" {
+				continue
+			}
+
+			key := resourceType + ":" + name
+			i, ok := index[key]
+			if !ok {
+				i = len(result)
+				index[key] = i
+				result = append(result, TokenAccess{Type: resourceType, Name: name, Actions: []string{}})
+			}
+			for _, action := range strings.Split(scope[last+1:], ",") {
+				if action != "" && !containsString(result[i].Actions, action) {
+					result[i].Actions = append(result[i].Actions, action)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// Authorize 按用户权限过滤请求的访问范围：已认证用户可以拉取，匿名用户只能拉取允许匿名的镜像，被禁止的镜像不能拉取和推送；
+// 推送和删除分别需要推送和删除权限；* 和目录列表只授予管理员。已认证用户还要通过仓库访问规则
+func (s *TokenService) Authorize(user *model.User, requested []TokenAccess) []TokenAccess {
+	granted := []TokenAccess{}
+	for _, access := range requested {
+		var actions []string
+		switch access.Type {
+		case "repository":
+			// 仓库组的请求按去掉组名后的仓库检查访问策略和访问规则
+			repository := s.groupService.ResolveName(access.Name)
+
+			// 被访问策略禁止的镜像不签发拉取和推送权限，标签级别的规则在请求manifest时检查
+			denied, err := s.whitelistService.IsImageDenied(repository)
+			if err != nil {
+				denied = true
+			}
+			for _, action := range access.Actions {
+				if denied && (action == "pull" || action == "push" || action == "*") {
+					continue
+				}
+				allowed := false
+				switch action {
+				case "pull":
+					if user != nil {
+						allowed = true
+					} else {
+						whitelisted, err := s.whitelistService.IsImageWhitelisted(repository)
+						allowed = err == nil && whitelisted
+					}
+				case "push":
+					allowed = user != nil && (user.IsAdmin || user.CanPush)
+				case "delete":
+					allowed = user != nil && (user.IsAdmin || user.CanDelete)
+				case "*":
+					allowed = user != nil && user.IsAdmin
+				}
+				if allowed && user != nil && action != "*" {
+					decision, err := s.aclService.Check(user, repository, action)
+					allowed = err == nil && decision.Allowed
+				}
+				if allowed {
+					actions = append(actions, action)
+				}
+			}
+		case "registry":
+			if access.Name == "catalog" && containsString(access.Actions, "*") && user != nil && user.IsAdmin {
+				actions = []string{"*"}
+			}
+		}
+
+		if len(actions) > 0 {
+			granted = append(granted, TokenAccess{Type: access.Type, Name: access.Name, Actions: actions})
+		}
+	}
+	return granted
+}
+
+// Issue 为用户签发token，user为nil表示匿名
+func (s *TokenService) Issue(user *model.User, access []TokenAccess) (*IssuedToken, error) {
+	if !s.enabled {
+		return nil, errors.New("token authentication is disabled")
+	}
+
+	s.mu.RLock()
+	if len(s.keys) == 0 {
+		s.mu.RUnlock()
+		return nil, errors.New("no signing key available")
+	}
+	key := s.keys[0]
+	s.mu.RUnlock()
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return nil, err
+	}
+
+	subject := ""
+	if user != nil {
+		subject = user.Username
+	}
+	if access == nil {
+		access = []TokenAccess{}
+	}
+
+	now := time.Now()
+	claims := TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.issuer,
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{s.service},
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.expiry)),
+			NotBefore: jwt.NewNumericDate(now.Add(-tokenLeeway)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        hex.EncodeToString(jti),
+		},
+		Access: access,
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	signed, err := token.SignedString(key.signer)
+	if err != nil {
+		return nil, err
+	}
+	return &IssuedToken{Token: signed, ExpiresIn: int(s.expiry / time.Second), IssuedAt: now}, nil
+}
+
+// Verify 校验token的签名、签发者、audience和有效期
+func (s *TokenService) Verify(tokenString string) (*TokenClaims, error) {
+	claims := &TokenClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		for _, key := range s.keys {
+			if key.id == kid {
+				if token.Method.Alg() != key.method.Alg() {
+					return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+				}
+				return key.signer.Public(), nil
+			}
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithIssuer(s.issuer),
+		jwt.WithAudience(s.service),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(tokenLeeway),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// containsString 判断字符串切片是否包含指定值
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
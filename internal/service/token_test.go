@@ -0,0 +1,249 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"zmirror/internal/model"
+)
+
+func TestParseScopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		want   []TokenAccess
+	}{
+		{
+			name:   "single scope",
+			scopes: []string{"repository:library/nginx:pull"},
+			want:   []TokenAccess{{Type: "repository", Name: "library/nginx", Actions: []string{"pull"}}},
+		},
+		{
+			name:   "multiple parameters",
+			scopes: []string{"repository:library/nginx:pull", "repository:team/app:pull,push"},
+			want: []TokenAccess{
+				{Type: "repository", Name: "library/nginx", Actions: []string{"pull"}},
+				{Type: "repository", Name: "team/app", Actions: []string{"pull", "push"}},
+			},
+		},
+		{
+			name:   "space separated in one parameter",
+			scopes: []string{"repository:a/b:pull registry:catalog:*"},
+			want: []TokenAccess{
+				{Type: "repository", Name: "a/b", Actions: []string{"pull"}},
+				{Type: "registry", Name: "catalog", Actions: []string{"*"}},
+			},
+		},
+		{
+			name:   "same resource merged",
+			scopes: []string{"repository:a/b:pull", "repository:a/b:push,pull"},
+			want:   []TokenAccess{{Type: "repository", Name: "a/b", Actions: []string{"pull", "push"}}},
+		},
+		{
+			name:   "name with port",
+			scopes: []string{"repository:localhost:5000/app:pull"},
+			want:   []TokenAccess{{Type: "repository", Name: "localhost:5000/app", Actions: []string{"pull"}}},
+		},
+		{
+			name:   "malformed scopes skipped",
+			scopes: []string{"repository", "repository:a/b", ":a/b:pull", "repository::pull", "repository:a/b:", "repository:c/d:,pull,"},
+			want:   []TokenAccess{{Type: "repository", Name: "c/d", Actions: []string{"pull"}}},
+		},
+		{
+			name:   "no scopes",
+			scopes: nil,
+			want:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseScopes(tt.scopes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseScopes() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenClaimsAllows(t *testing.T) {
+	claims := &TokenClaims{Access: ParseScopes([]string{
+		"repository:library/nginx:pull",
+		"repository:team/app:pull,push",
+		"repository:team/admin:*",
+		"registry:catalog:*",
+	})}
+
+	tests := []struct {
+		resourceType string
+		name         string
+		action       string
+		want         bool
+	}{
+		{"repository", "library/nginx", "pull", true},
+		{"repository", "library/nginx", "push", false},
+		{"repository", "team/app", "pull", true},
+		{"repository", "team/app", "push", true},
+		{"repository", "team/app", "delete", false},
+		{"repository", "team/admin", "delete", true},
+		{"repository", "team/other", "pull", false},
+		{"repository", "library/nginx/extra", "pull", false},
+		{"registry", "catalog", "*", true},
+		{"registry", "library/nginx", "pull", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resourceType+":"+tt.name+":"+tt.action, func(t *testing.T) {
+			if got := claims.Allows(tt.resourceType, tt.name, tt.action); got != tt.want {
+				t.Errorf("Allows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestTokenService 创建使用临时密钥目录的token服务
+func newTestTokenService(t *testing.T, dir, algorithm string) *TokenService {
+	t.Helper()
+	db := newTestDB(t)
+	tokenService, err := NewTokenService(NewWhitelistService(db), NewACLService(db), NewGroupService(db, NewRegistryService(db)),
+		true, dir, "", "zmirror", "zmirror", algorithm, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokenService
+}
+
+// backdateKeys 把所有签名密钥的创建时间提前，模拟时间流逝。密钥文件按新的创建时间改名，重启后加载的时间一致
+func backdateKeys(t *testing.T, s *TokenService, d time.Duration) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 从最旧的密钥开始改名，避免覆盖还没改名的文件
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		key := s.keys[i]
+		key.createdAt = key.createdAt.Add(-d)
+		path := filepath.Join(s.dir, fmt.Sprintf("%s%d.pem", tokenKeyPrefix, key.createdAt.Unix()))
+		if err := os.Rename(key.path, path); err != nil {
+			t.Fatal(err)
+		}
+		key.path = path
+	}
+}
+
+func TestTokenRotation(t *testing.T) {
+	for _, algorithm := range []string{"RS256", "ES256"} {
+		t.Run(algorithm, func(t *testing.T) {
+			dir := t.TempDir()
+			tokenService := newTestTokenService(t, dir, algorithm)
+			access := ParseScopes([]string{"repository:team/app:pull"})
+
+			before, err := tokenService.Issue(&model.User{Username: "alice"}, access)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 超过轮换间隔后生成新密钥，之前签发的token仍然有效
+			backdateKeys(t, tokenService, time.Hour)
+			if err := tokenService.Rotate(); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(tokenService.JWKS().Keys); n != 2 {
+				t.Fatalf("JWKS has %d keys after rotation, want 2", n)
+			}
+			claims, err := tokenService.Verify(before.Token)
+			if err != nil {
+				t.Fatalf("token issued before rotation rejected: %v", err)
+			}
+			if claims.Subject != "alice" || !claims.Allows("repository", "team/app", "pull") {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+
+			after, err := tokenService.Issue(&model.User{Username: "alice"}, access)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tokenService.Verify(after.Token); err != nil {
+				t.Fatalf("token issued after rotation rejected: %v", err)
+			}
+
+			// 重启后从密钥目录加载，轮换前后签发的token都能校验
+			reloaded := newTestTokenService(t, dir, algorithm)
+			for name, issued := range map[string]*IssuedToken{"before": before, "after": after} {
+				if _, err := reloaded.Verify(issued.Token); err != nil {
+					t.Errorf("token issued %s rotation rejected after reload: %v", name, err)
+				}
+			}
+
+			// 替换旧密钥的新密钥也超过一个token有效期后，旧密钥被删除，它签发的token失效
+			backdateKeys(t, tokenService, time.Hour)
+			if err := tokenService.Rotate(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tokenService.Verify(before.Token); err == nil {
+				t.Error("token signed by a retired key was accepted")
+			}
+			if _, err := tokenService.Verify(after.Token); err != nil {
+				t.Errorf("token signed by the previous key rejected: %v", err)
+			}
+		})
+	}
+}
+
+func TestTokenVerifyRejectsForeignTokens(t *testing.T) {
+	tokenService := newTestTokenService(t, t.TempDir(), "ES256")
+	other := newTestTokenService(t, t.TempDir(), "ES256")
+
+	issued, err := other.Issue(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokenService.Verify(issued.Token); err == nil {
+		t.Error("token signed by another key was accepted")
+	}
+	if _, err := tokenService.Verify("not-a-token"); err == nil {
+		t.Error("malformed token was accepted")
+	}
+}
+
+func TestTokenAuthorize(t *testing.T) {
+	tokenService := newTestTokenService(t, t.TempDir(), "ES256")
+	requested := ParseScopes([]string{"repository:team/app:pull,push,delete,*", "registry:catalog:*"})
+
+	tests := []struct {
+		name string
+		user *model.User
+		want []TokenAccess
+	}{
+		{
+			name: "anonymous",
+			user: nil,
+			want: []TokenAccess{},
+		},
+		{
+			name: "user without permissions",
+			user: &model.User{ID: 1, Username: "alice"},
+			want: []TokenAccess{{Type: "repository", Name: "team/app", Actions: []string{"pull"}}},
+		},
+		{
+			name: "user with push permission",
+			user: &model.User{ID: 1, Username: "alice", CanPush: true},
+			want: []TokenAccess{{Type: "repository", Name: "team/app", Actions: []string{"pull", "push"}}},
+		},
+		{
+			name: "administrator",
+			user: &model.User{ID: 1, Username: "admin", IsAdmin: true},
+			want: []TokenAccess{
+				{Type: "repository", Name: "team/app", Actions: []string{"pull", "push", "delete", "*"}},
+				{Type: "registry", Name: "catalog", Actions: []string{"*"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenService.Authorize(tt.user, requested); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Authorize() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,380 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"zmirror/internal/model"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// watchCheckInterval 定时检查任务的间隔
+	watchCheckInterval = time.Minute
+
+	// defaultWatchInterval 未设置检查间隔时使用的默认值（分钟）
+	defaultWatchInterval = 5
+
+	// maxWatchTags 单个订阅通配符最多展开的tag数量
+	maxWatchTags = 100
+)
+
+// WatchService 订阅上游镜像tag，定期检查digest变化并通知
+type WatchService struct {
+	db           *gorm.DB
+	proxyService *ProxyService
+	cacheService *CacheService
+	imageService *ImageService
+	client       *http.Client
+
+	mu sync.Mutex // 保护digest记录的比较和写入，同一个tag被并发检查时只记录一次变化
+}
+
+func NewWatchService(db *gorm.DB, proxyService *ProxyService, cacheService *CacheService, imageService *ImageService) *WatchService {
+	return &WatchService{
+		db:           db,
+		proxyService: proxyService,
+		cacheService: cacheService,
+		imageService: imageService,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// GetAllWatches 获取所有tag订阅
+func (s *WatchService) GetAllWatches() ([]model.TagWatch, error) {
+	var watches []model.TagWatch
+	err := s.db.Order("id ASC").Find(&watches).Error
+	return watches, err
+}
+
+// CreateWatch 创建tag订阅
+func (s *WatchService) CreateWatch(watch *model.TagWatch) error {
+	if err := validateWatch(watch); err != nil {
+		return err
+	}
+	return s.db.Create(watch).Error
+}
+
+// UpdateWatch 更新tag订阅
+func (s *WatchService) UpdateWatch(watch *model.TagWatch) error {
+	if err := validateWatch(watch); err != nil {
+		return err
+	}
+	return s.db.Select("pattern", "interval", "webhook_url", "prefetch", "platform", "enabled").Updates(watch).Error
+}
+
+// DeleteWatch 删除tag订阅，已记录的digest历史保留
+func (s *WatchService) DeleteWatch(id uint) error {
+	return s.db.Delete(&model.TagWatch{}, id).Error
+}
+
+// GetHistory 获取digest变化记录，repository为空时返回所有仓库的记录
+func (s *WatchService) GetHistory(repository string, limit int) ([]model.TagDigestChange, error) {
+	var changes []model.TagDigestChange
+	query := s.db.Order("id DESC").Limit(limit)
+	if repository != "" {
+		query = query.Where("repository = ?", repository)
+	}
+	err := query.Find(&changes).Error
+	return changes, err
+}
+
+// validateWatch 校验并规范化订阅规则，仓库名按镜像引用解析，如 postgres:16* 规范为 library/postgres:16*
+func validateWatch(watch *model.TagWatch) error {
+	repository, tag, err := parseWatchPattern(watch.Pattern)
+	if err != nil {
+		return err
+	}
+	watch.Pattern = repository + ":" + tag
+	if watch.Interval < 0 {
+		return fmt.Errorf("interval must not be negative")
+	}
+	if watch.Interval == 0 {
+		watch.Interval = defaultWatchInterval
+	}
+	if watch.WebhookURL != "" && !strings.HasPrefix(watch.WebhookURL, "http://") && !strings.HasPrefix(watch.WebhookURL, "https://") {
+		return fmt.Errorf("webhook url must start with http:// or https://")
+	}
+	if watch.Platform != "" {
+		if _, err := ParsePlatform(watch.Platform); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseWatchPattern 解析订阅规则为仓库名和tag通配符，仓库名不支持通配符
+func parseWatchPattern(pattern string) (repository, tag string, err error) {
+	repository, tag, err = ParseImageReference(pattern)
+	if err != nil {
+		return "", "", err
+	}
+	if IsDigest(tag) {
+		return "", "", fmt.Errorf("pattern must reference a tag, not a digest")
+	}
+	if strings.ContainsAny(repository, "*?[") {
+		return "", "", fmt.Errorf("wildcards are only supported in the tag")
+	}
+	if _, err := path.Match(tag, ""); err != nil {
+		return "", "", fmt.Errorf("invalid tag pattern: %v", err)
+	}
+	return repository, tag, nil
+}
+
+// matchWatch 判断订阅是否覆盖指定的仓库和tag
+func matchWatch(watch *model.TagWatch, repository, tag string) bool {
+	watchRepository, pattern, err := parseWatchPattern(watch.Pattern)
+	if err != nil || watchRepository != repository {
+		return false
+	}
+	matched, _ := path.Match(pattern, tag)
+	return matched
+}
+
+// Check 立即检查一个订阅，返回检测到的digest变化
+func (s *WatchService) Check(id uint) ([]model.TagDigestChange, error) {
+	var watch model.TagWatch
+	if err := s.db.First(&watch, id).Error; err != nil {
+		return nil, err
+	}
+	return s.check(&watch)
+}
+
+// StartScheduler 启动定时检查任务
+func (s *WatchService) StartScheduler() {
+	go func() {
+		ticker := time.NewTicker(watchCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if s.proxyService.IsOffline() {
+				continue
+			}
+			var watches []model.TagWatch
+			if err := s.db.Where("enabled = ?", true).Find(&watches).Error; err != nil {
+				log.Printf("Watch: failed to load tag watches: %v", err)
+				continue
+			}
+
+			for i := range watches {
+				watch := &watches[i]
+				if watch.LastCheckedAt != nil && time.Since(*watch.LastCheckedAt) < time.Duration(watch.Interval)*time.Minute {
+					continue
+				}
+				if _, err := s.check(watch); err != nil {
+					log.Printf("Watch: %s check failed: %v", watch.Pattern, err)
+				}
+			}
+		}
+	}()
+}
+
+// check 检查订阅匹配的所有tag，记录digest变化并通知所有覆盖该tag的订阅
+func (s *WatchService) check(watch *model.TagWatch) ([]model.TagDigestChange, error) {
+	changes, err := s.detect(watch)
+
+	now := time.Now()
+	lastError := ""
+	if err != nil {
+		lastError = err.Error()
+	}
+	s.db.Model(&model.TagWatch{}).Where("id = ?", watch.ID).UpdateColumns(map[string]interface{}{
+		"last_checked_at": now,
+		"last_error":      lastError,
+	})
+
+	for i := range changes {
+		s.dispatch(&changes[i])
+	}
+	return changes, err
+}
+
+// detect 获取订阅匹配的tag在上游的当前digest，与上次记录比较
+func (s *WatchService) detect(watch *model.TagWatch) ([]model.TagDigestChange, error) {
+	repository, pattern, err := parseWatchPattern(watch.Pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string{pattern}
+	if strings.ContainsAny(pattern, "*?[") {
+		if tags, err = s.matchingTags(repository, pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	changes := []model.TagDigestChange{}
+	var failures []string
+	for _, tag := range tags {
+		digest, upstream, err := s.proxyService.ManifestDigest(repository, tag)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", tag, err))
+			continue
+		}
+
+		change, err := s.record(repository, tag, digest, upstream)
+		if err != nil {
+			return changes, err
+		}
+		// 首次记录只作为基准，不算作变化
+		if change != nil && change.PreviousDigest != "" {
+			changes = append(changes, *change)
+		}
+	}
+
+	if len(failures) > 0 {
+		return changes, fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return changes, nil
+}
+
+// record 与上次记录的digest比较，变化时写入新记录，未变化时返回nil
+func (s *WatchService) record(repository, tag, digest, upstream string) (*model.TagDigestChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var last model.TagDigestChange
+	err := s.db.Where("repository = ? AND tag = ?", repository, tag).Order("id DESC").First(&last).Error
+	if err == nil && last.Digest == digest {
+		return nil, nil
+	}
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	change := &model.TagDigestChange{
+		Repository:     repository,
+		Tag:            tag,
+		Digest:         digest,
+		PreviousDigest: last.Digest,
+		Upstream:       upstream,
+	}
+	if err := s.db.Create(change).Error; err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// matchingTags 列出上游仓库中匹配通配符的tag
+func (s *WatchService) matchingTags(repository, pattern string) ([]string, error) {
+	resp, _, err := s.proxyService.ProxyRequest("GET", "/v2/"+repository+"/tags/list", http.Header{})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("invalid tag list: %v", err)
+	}
+
+	var tags []string
+	for _, tag := range list.Tags {
+		if matched, _ := path.Match(pattern, tag); matched {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > maxWatchTags {
+		return nil, fmt.Errorf("pattern matches %d tags, at most %d are allowed", len(tags), maxWatchTags)
+	}
+	return tags, nil
+}
+
+// TagChangeEvent digest变化时发送给webhook的内容
+type TagChangeEvent struct {
+	Event          string    `json:"event"`
+	Watch          string    `json:"watch"`
+	Repository     string    `json:"repository"`
+	Tag            string    `json:"tag"`
+	Digest         string    `json:"digest"`
+	PreviousDigest string    `json:"previous_digest"`
+	Upstream       string    `json:"upstream"`
+	DetectedAt     time.Time `json:"detected_at"`
+}
+
+// dispatch 让本地缓存的旧manifest失效，通知覆盖该tag的所有启用订阅，并按需预取
+func (s *WatchService) dispatch(change *model.TagDigestChange) {
+	log.Printf("Watch: %s:%s changed from %s to %s", change.Repository, change.Tag, change.PreviousDigest, change.Digest)
+
+	if s.cacheService.Enabled() {
+		if err := s.cacheService.InvalidateManifest(change.Repository, change.Tag); err != nil {
+			log.Printf("Watch: failed to invalidate cached manifest %s:%s: %v", change.Repository, change.Tag, err)
+		}
+	}
+
+	var watches []model.TagWatch
+	if err := s.db.Where("enabled = ?", true).Find(&watches).Error; err != nil {
+		log.Printf("Watch: failed to load tag watches: %v", err)
+		return
+	}
+
+	prefetched := make(map[string]bool)
+	for i := range watches {
+		watch := &watches[i]
+		if !matchWatch(watch, change.Repository, change.Tag) {
+			continue
+		}
+		if watch.WebhookURL != "" {
+			if err := s.notify(watch, change); err != nil {
+				log.Printf("Watch: webhook for %s failed: %v", watch.Pattern, err)
+				s.db.Model(&model.TagWatch{}).Where("id = ?", watch.ID).UpdateColumn("last_error", "webhook: "+err.Error())
+			}
+		}
+		if watch.Prefetch && !prefetched[watch.Platform] && !prefetched[""] {
+			prefetched[watch.Platform] = true
+			go s.prefetch(change.Repository, change.Tag, watch.Platform)
+		}
+	}
+}
+
+// notify 向订阅的webhook发送digest变化事件
+func (s *WatchService) notify(watch *model.TagWatch, change *model.TagDigestChange) error {
+	body, err := json.Marshal(TagChangeEvent{
+		Event:          "tag.updated",
+		Watch:          watch.Pattern,
+		Repository:     change.Repository,
+		Tag:            change.Tag,
+		Digest:         change.Digest,
+		PreviousDigest: change.PreviousDigest,
+		Upstream:       change.Upstream,
+		DetectedAt:     change.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(watch.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// prefetch 把变化后的镜像拉取到缓存
+func (s *WatchService) prefetch(repository, tag, platform string) {
+	var platforms []string
+	if platform != "" {
+		platforms = []string{platform}
+	}
+	result, err := s.imageService.Prefetch(repository, tag, platforms)
+	if err != nil {
+		log.Printf("Watch: prefetch %s:%s failed: %v", repository, tag, err)
+		return
+	}
+	log.Printf("Watch: prefetched %s:%s (%d manifests, %d blobs)", repository, tag, result.Manifests, result.Blobs)
+}
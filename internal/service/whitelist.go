@@ -0,0 +1,239 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"zmirror/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// 访问策略规则的效果
+const (
+	WhitelistAnonymous = "anonymous" // 允许匿名拉取
+	WhitelistAuth      = "auth"      // 需要认证，按用户权限处理
+	WhitelistDeny      = "deny"      // 禁止拉取和推送
+)
+
+type WhitelistService struct {
+	db *gorm.DB
+
+	// 启用的规则编译后缓存在内存中，每个请求都要判断，不能每次查询数据库；通过管理API修改后整体替换
+	mu      sync.Mutex
+	matcher atomic.Pointer[whitelistMatcher]
+}
+
+// whitelistMatcher 按优先级排好序的已编译规则
+type whitelistMatcher struct {
+	rules []compiledWhitelist
+}
+
+// compiledWhitelist 一条已编译的规则，正则规则预先编译
+type compiledWhitelist struct {
+	rule model.Whitelist
+	re   *regexp.Regexp
+}
+
+func NewWhitelistService(db *gorm.DB) *WhitelistService {
+	return &WhitelistService{db: db}
+}
+
+// Evaluate 按优先级返回第一条匹配镜像名和引用的启用规则，没有规则匹配时返回nil。
+// reference 为空表示仓库级别的判断（如blob请求），此时只匹配不限制标签的规则
+func (s *WhitelistService) Evaluate(imageName, reference string) (*model.Whitelist, error) {
+	matcher := s.matcher.Load()
+	if matcher == nil {
+		var err error
+		if matcher, err = s.reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range matcher.rules {
+		if matcher.rules[i].match(imageName, reference) {
+			rule := matcher.rules[i].rule
+			return &rule, nil
+		}
+	}
+	return nil, nil
+}
+
+// reload 重新从数据库加载并编译启用的规则。加锁保证并发加载时最后写入的是修改之后查询到的结果，
+// 加载失败时清空缓存，下次判断再重试
+func (s *WhitelistService) reload() (*whitelistMatcher, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var whitelists []model.Whitelist
+	err := s.db.Where("enabled = ?", true).Order("priority ASC, id ASC").Find(&whitelists).Error
+	if err != nil {
+		s.matcher.Store(nil)
+		return nil, err
+	}
+
+	matcher := &whitelistMatcher{rules: make([]compiledWhitelist, 0, len(whitelists))}
+	for _, whitelist := range whitelists {
+		compiled := compiledWhitelist{rule: whitelist}
+		if whitelist.MatchType == "regex" {
+			// 无法编译的正则规则不会匹配任何镜像，与逐条判断时的行为一致
+			if compiled.re, err = compileWhitelistRegex(whitelist.Prefix); err != nil {
+				log.Printf("Whitelist: skipping rule #%d with invalid regular expression: %v", whitelist.ID, err)
+				continue
+			}
+		}
+		matcher.rules = append(matcher.rules, compiled)
+	}
+	s.matcher.Store(matcher)
+	return matcher, nil
+}
+
+// IsImageWhitelisted 检查镜像是否允许匿名拉取
+func (s *WhitelistService) IsImageWhitelisted(imageName string) (bool, error) {
+	rule, err := s.Evaluate(imageName, "")
+	if err != nil {
+		return false, err
+	}
+	return WhitelistEffect(rule) == WhitelistAnonymous, nil
+}
+
+// IsImageDenied 检查镜像是否被规则禁止访问
+func (s *WhitelistService) IsImageDenied(imageName string) (bool, error) {
+	rule, err := s.Evaluate(imageName, "")
+	if err != nil {
+		return false, err
+	}
+	return WhitelistEffect(rule) == WhitelistDeny, nil
+}
+
+// GetAllWhitelists 获取所有白名单，按优先级排序
+func (s *WhitelistService) GetAllWhitelists() ([]model.Whitelist, error) {
+	var whitelists []model.Whitelist
+	err := s.db.Order("priority ASC, id ASC").Find(&whitelists).Error
+	return whitelists, err
+}
+
+// CreateWhitelist 创建白名单
+func (s *WhitelistService) CreateWhitelist(whitelist *model.Whitelist) error {
+	if err := validateWhitelist(whitelist); err != nil {
+		return err
+	}
+	if err := s.db.Create(whitelist).Error; err != nil {
+		return err
+	}
+	_, err := s.reload()
+	return err
+}
+
+// UpdateWhitelist 更新白名单
+func (s *WhitelistService) UpdateWhitelist(whitelist *model.Whitelist) error {
+	if err := validateWhitelist(whitelist); err != nil {
+		return err
+	}
+	err := s.db.Select("prefix", "match_type", "reference", "effect", "priority", "description", "enabled").Updates(whitelist).Error
+	if err != nil {
+		return err
+	}
+	_, err = s.reload()
+	return err
+}
+
+// DeleteWhitelist 删除白名单
+func (s *WhitelistService) DeleteWhitelist(id uint) error {
+	if err := s.db.Delete(&model.Whitelist{}, id).Error; err != nil {
+		return err
+	}
+	_, err := s.reload()
+	return err
+}
+
+// validateWhitelist 校验规则并补全默认值，旧版本的规则相当于匿名可拉取的前缀规则
+func validateWhitelist(whitelist *model.Whitelist) error {
+	whitelist.Prefix = strings.TrimSpace(whitelist.Prefix)
+	whitelist.Reference = strings.TrimSpace(whitelist.Reference)
+	if whitelist.Prefix == "" {
+		return fmt.Errorf("prefix is required")
+	}
+
+	switch whitelist.MatchType {
+	case "":
+		whitelist.MatchType = "prefix"
+	case "prefix":
+	case "glob":
+		if _, err := path.Match(whitelist.Prefix, ""); err != nil {
+			return fmt.Errorf("invalid glob pattern: %v", err)
+		}
+	case "regex":
+		if _, err := compileWhitelistRegex(whitelist.Prefix); err != nil {
+			return fmt.Errorf("invalid regular expression: %v", err)
+		}
+	default:
+		return fmt.Errorf("match_type must be prefix, glob or regex")
+	}
+
+	if whitelist.Reference != "" {
+		if _, err := path.Match(whitelist.Reference, ""); err != nil {
+			return fmt.Errorf("invalid reference pattern: %v", err)
+		}
+	}
+
+	switch whitelist.Effect {
+	case "":
+		whitelist.Effect = WhitelistAnonymous
+	case WhitelistAnonymous, WhitelistAuth, WhitelistDeny:
+	default:
+		return fmt.Errorf("effect must be anonymous, auth or deny")
+	}
+
+	// 标签级规则只在按标签请求manifest时匹配，blob和按digest的请求无法对应到标签：
+	// 允许匿名的规则在拉取镜像层时失效，禁止访问的规则可以按digest绕过，因此只能要求认证
+	if whitelist.Reference != "" && whitelist.Effect != WhitelistAuth {
+		return fmt.Errorf("rules with a reference only support the auth effect")
+	}
+	return nil
+}
+
+// compileWhitelistRegex 编译正则规则。规则需要匹配完整的镜像名，避免 nginx 这样的规则同时命中 team/nginx-private
+func compileWhitelistRegex(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// match 判断规则是否匹配镜像名和引用
+func (c *compiledWhitelist) match(imageName, reference string) bool {
+	whitelist := &c.rule
+	if whitelist.Reference != "" {
+		if reference == "" {
+			return false
+		}
+		if matched, err := path.Match(whitelist.Reference, reference); err != nil || !matched {
+			return false
+		}
+	}
+
+	switch whitelist.MatchType {
+	case "glob":
+		matched, err := path.Match(whitelist.Prefix, imageName)
+		return err == nil && matched
+	case "regex":
+		return c.re.MatchString(imageName)
+	default:
+		return strings.HasPrefix(imageName, whitelist.Prefix)
+	}
+}
+
+// WhitelistEffect 返回规则的效果，没有规则匹配时需要认证
+func WhitelistEffect(whitelist *model.Whitelist) string {
+	if whitelist == nil {
+		return WhitelistAuth
+	}
+	// 旧版本没有效果字段的规则视为允许匿名拉取
+	if whitelist.Effect == "" {
+		return WhitelistAnonymous
+	}
+	return whitelist.Effect
+}
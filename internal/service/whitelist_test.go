@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+
+	"zmirror/internal/model"
+)
+
+func TestValidateWhitelist(t *testing.T) {
+	tests := []struct {
+		name       string
+		whitelist  model.Whitelist
+		wantErr    bool
+		wantEffect string
+	}{
+		{"repository rule defaults to anonymous", model.Whitelist{Prefix: "library/"}, false, WhitelistAnonymous},
+		{"repository deny rule", model.Whitelist{Prefix: "bad/.*", MatchType: "regex", Effect: WhitelistDeny}, false, WhitelistDeny},
+		{"tag-level auth rule", model.Whitelist{Prefix: "library/nginx", Reference: "nightly-*", Effect: WhitelistAuth}, false, WhitelistAuth},
+		{"tag-level deny rule", model.Whitelist{Prefix: "library/nginx", Reference: "latest", Effect: WhitelistDeny}, true, ""},
+		{"tag-level anonymous rule", model.Whitelist{Prefix: "library/nginx", Reference: "1.*", Effect: WhitelistAnonymous}, true, ""},
+		{"tag-level rule without effect", model.Whitelist{Prefix: "library/nginx", Reference: "1.*"}, true, ""},
+		{"invalid regex", model.Whitelist{Prefix: "bad/(", MatchType: "regex"}, true, ""},
+		{"unknown effect", model.Whitelist{Prefix: "library/", Effect: "allow"}, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			whitelist := tt.whitelist
+			err := validateWhitelist(&whitelist)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateWhitelist() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && whitelist.Effect != tt.wantEffect {
+				t.Errorf("effect = %q, want %q", whitelist.Effect, tt.wantEffect)
+			}
+		})
+	}
+}